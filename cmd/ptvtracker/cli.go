@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/ptvtracker-data/internal/common/config"
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/common/logger"
+)
+
+// loadDotEnv loads .env file if it exists
+func loadDotEnv() {
+	// In production, environment variables are typically provided directly
+	// rather than through a .env file, so we only load if the file exists
+	if err := godotenv.Load(); err != nil {
+		// Only panic if it's not a "file not found" error
+		if !os.IsNotExist(err) {
+			panic("Failed to load .env file: " + err.Error())
+		}
+		// If .env doesn't exist, that's okay - proceed with environment variables
+	}
+}
+
+// cliLogger logs to stderr so command output on stdout stays clean
+func cliLogger(verbose bool) logger.Logger {
+	level := "warn"
+	if verbose {
+		level = "info"
+	}
+	return logger.NewWithLevel(level, logger.StderrWriter())
+}
+
+// openDatabase connects using only the database settings from the environment
+func openDatabase(log logger.Logger) (*db.DB, error) {
+	loadDotEnv()
+
+	cfg, err := config.LoadDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("invalid database configuration: %w", err)
+	}
+
+	database, err := db.New(cfg.ConnectionString, log)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+	return database, nil
+}
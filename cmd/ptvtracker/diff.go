@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ptvtracker-data/internal/gtfs-static/diff"
+	"github.com/ptvtracker-data/pkg/gtfs-static/gtfstime"
+)
+
+// runDiff compares two imported versions. Returns the process exit code.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	sourceID := fs.Int("source", 0, "only compare this source ID (0 = all)")
+	date := fs.String("date", "", "first service date to compare, YYYY-MM-DD (default: today in Melbourne)")
+	days := fs.Int("days", 7, "number of service days to compare")
+	limit := fs.Int("limit", 20, "entities listed per change type (text output only)")
+	jsonOut := fs.Bool("json", false, "print the full diff as JSON")
+	verbose := fs.Bool("v", false, "log query progress")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ptvtracker diff [flags] <from_version_id> <to_version_id>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	fromVersion, err1 := strconv.Atoi(fs.Arg(0))
+	toVersion, err2 := strconv.Atoi(fs.Arg(1))
+	if err1 != nil || err2 != nil {
+		fmt.Fprintln(os.Stderr, "version IDs must be integers")
+		return 2
+	}
+
+	opts := diff.DefaultOptions()
+	opts.SourceID = *sourceID
+	opts.ServiceDays = *days
+	opts.MaxChanges = *limit
+	if *jsonOut {
+		opts.MaxChanges = 0
+	}
+
+	loc, err := time.LoadLocation("Australia/Melbourne")
+	if err != nil {
+		loc = time.UTC
+	}
+	opts.ServiceDate = time.Now().In(loc)
+	if *date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", *date, loc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -date %q: %v\n", *date, err)
+			return 2
+		}
+		opts.ServiceDate = parsed
+	}
+
+	log := cliLogger(*verbose)
+	database, err := openDatabase(log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer database.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	result, err := diff.New(database, log).Diff(ctx, fromVersion, toVersion, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff failed: %v\n", err)
+		return 1
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fmt.Fprintf(os.Stderr, "writing JSON: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	printDiff(os.Stdout, result)
+	return 0
+}
+
+func printDiff(w io.Writer, result *diff.Result) {
+	fmt.Fprintf(w, "Version %d -> %d\n\n", result.FromVersion, result.ToVersion)
+
+	kinds := []string{"stops", "routes", "trips", "shapes"}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTITY\tADDED\tREMOVED\tCHANGED")
+	for _, kind := range kinds {
+		d := result.Entities[kind]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", kind, d.AddedCount, d.RemovedCount, d.ChangedCount)
+	}
+	tw.Flush()
+
+	for _, kind := range kinds {
+		d := result.Entities[kind]
+		printChanges(w, kind, "added", d.Added, d.AddedCount)
+		printChanges(w, kind, "removed", d.Removed, d.RemovedCount)
+		printChanges(w, kind, "changed", d.Changed, d.ChangedCount)
+	}
+
+	fmt.Fprintln(w)
+	if len(result.Service) == 0 {
+		fmt.Fprintln(w, "No service-level changes")
+		return
+	}
+
+	fmt.Fprintln(w, "Service changes (trips, first and last departure per route per day):")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tSOURCE\tROUTE\tTRIPS\tFIRST\tLAST")
+	for _, c := range result.Service {
+		route := c.RouteID
+		if c.RouteShortName != "" {
+			route = fmt.Sprintf("%s (%s)", c.RouteShortName, c.RouteID)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d -> %d\t%s -> %s\t%s -> %s\n",
+			c.Date, c.SourceID, route,
+			c.From.Trips, c.To.Trips,
+			serviceTime(c.From, c.From.FirstDeparture), serviceTime(c.To, c.To.FirstDeparture),
+			serviceTime(c.From, c.From.LastDeparture), serviceTime(c.To, c.To.LastDeparture))
+	}
+	tw.Flush()
+}
+
+func printChanges(w io.Writer, kind, change string, items []diff.EntityChange, total int) {
+	if total == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%s %s (%d):\n", kind, change, total)
+	for _, item := range items {
+		fmt.Fprintf(w, "  [%d] %s", item.SourceID, item.ID)
+		if len(item.Fields) > 0 {
+			parts := make([]string, 0, len(item.Fields))
+			for _, f := range item.Fields {
+				if f.From == "" && f.To == "" {
+					parts = append(parts, f.Field)
+					continue
+				}
+				parts = append(parts, fmt.Sprintf("%s: %q -> %q", f.Field, f.From, f.To))
+			}
+			fmt.Fprintf(w, "  %s", strings.Join(parts, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+	if hidden := total - len(items); hidden > 0 {
+		fmt.Fprintf(w, "  ... %d more\n", hidden)
+	}
+}
+
+// serviceTime formats a departure, or "-" when the route has no service that day
+func serviceTime(s diff.RouteService, seconds int) string {
+	if s.Trips == 0 {
+		return "-"
+	}
+	return gtfstime.FormatTime(seconds)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/pkg/gtfs-static/gtfswriter"
+)
+
+// runExport writes an imported version back to a GTFS zip. Returns the
+// process exit code.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	versionID := fs.Int("version", 0, "version ID to export (default: the dataset's active version)")
+	dataset := fs.String("dataset", db.DefaultDataset, "dataset whose active version is exported")
+	sources := fs.String("sources", "", "comma-separated source IDs to export (default: all)")
+	output := fs.String("o", "", "zip file to write (default: gtfs_v<version>.zip)")
+	extensions := fs.Bool("extensions", false, "also write derived tables as ext_*.txt files")
+	prefixIDs := fs.String("prefix-ids", "auto", "prefix IDs with their source ID: auto (when exporting several sources), always or never")
+	verbose := fs.Bool("v", false, "log query progress")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ptvtracker export [flags]")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Writes an imported version, or some of its sources, to a GTFS zip.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *prefixIDs != "auto" && *prefixIDs != "always" && *prefixIDs != "never" {
+		fmt.Fprintf(os.Stderr, "invalid -prefix-ids %q: want auto, always or never\n", *prefixIDs)
+		return 2
+	}
+
+	var sourceIDs []int
+	for _, item := range splitList(*sources) {
+		id, err := strconv.Atoi(item)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid source ID %q\n", item)
+			return 2
+		}
+		sourceIDs = append(sourceIDs, id)
+	}
+
+	log := cliLogger(*verbose)
+	database, err := openDatabase(log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer database.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *versionID == 0 {
+		active, err := db.NewDatasetVersionChecker(database, *dataset).GetActiveVersion(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if active == nil {
+			fmt.Fprintf(os.Stderr, "dataset %q has no active version; choose one with -version\n", *dataset)
+			return 1
+		}
+		*versionID = active.VersionID
+	}
+
+	opts := gtfswriter.Options{
+		VersionID:  *versionID,
+		SourceIDs:  sourceIDs,
+		PrefixIDs:  *prefixIDs == "always",
+		Extensions: *extensions,
+	}
+	if *prefixIDs == "auto" {
+		count := len(sourceIDs)
+		if count == 0 {
+			all, err := gtfswriter.VersionSources(ctx, database.DB(), *versionID)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			count = len(all)
+		}
+		opts.PrefixIDs = count > 1
+	}
+
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("gtfs_v%d.zip", *versionID)
+	}
+
+	summary, err := exportZip(ctx, database, path, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Exported version %d (sources %v) to %s\n", summary.VersionID, summary.SourceIDs, path)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, file := range summary.Files {
+		fmt.Fprintf(tw, "  %s\t%d\n", file.Name, file.Rows)
+	}
+	tw.Flush()
+	return 0
+}
+
+// exportZip writes the export to a temporary file next to path and renames
+// it into place, so a failed export never leaves a truncated zip behind
+func exportZip(ctx context.Context, database *db.DB, path string, opts gtfswriter.Options) (*gtfswriter.Summary, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".export-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("creating output file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	summary, err := gtfswriter.Export(ctx, database.DB(), tmp, opts)
+	if err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("exporting version %d: %w", opts.VersionID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("writing output file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("writing output file: %w", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/gtfs-static/archive"
+	"github.com/ptvtracker-data/internal/gtfs-static/importer"
+	"github.com/ptvtracker-data/internal/gtfs-static/scraper"
+)
+
+// runImport loads chosen sources and files of a GTFS zip or PTV master zip
+// into a new version, for development. Returns the process exit code.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dataset := fs.String("dataset", db.DefaultDataset, "dataset the version belongs to")
+	sources := fs.String("sources", "", "comma-separated folder keys of the master zip to import (default: all)")
+	sourceID := fs.Int("source", 0, "source ID for a single-feed zip")
+	files := fs.String("files", "", "comma-separated GTFS files to import, plus the files they reference (default: all)")
+	skipFiles := fs.String("skip-files", "", "comma-separated GTFS files not to import, e.g. shapes.txt")
+	pattern := fs.String("pattern", scraper.DefaultNestedPattern, "pattern matching nested source zips in a master zip; its first group is the folder key")
+	name := fs.String("name", "", "version name (default: import_<timestamp>)")
+	throwaway := fs.Bool("throwaway", false, "import into a throwaway version that can't be activated and is cleaned up after a day")
+	batchSize := fs.Int("batch-size", importer.DefaultOptions().BatchSize, "rows per COPY statement")
+	verbose := fs.Bool("v", false, "log import progress")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ptvtracker import [flags] <gtfs.zip>")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Imports some or all sources and files of a master zip, or of a single feed with -source, into a new inactive version.")
+		fmt.Fprintln(fs.Output(), "Use 'ptvtracker versions activate' to make it active.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	zipPath := fs.Arg(0)
+
+	selected, err := selectFiles(splitList(*files), splitList(*skipFiles))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	layout, err := scraper.NewLayout(*pattern, nil, splitList(*sources), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	log := cliLogger(*verbose)
+	database, err := openDatabase(log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer database.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	tempDir, err := os.MkdirTemp("", "ptvtracker-import-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating temp directory: %v\n", err)
+		return 2
+	}
+	defer os.RemoveAll(tempDir)
+
+	vc := db.NewDatasetVersionChecker(database, *dataset)
+	datasetSources, err := vc.DatasetSources(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	feeds, feedFiles, err := importSources(ctx, vc, zipPath, layout, *sourceID, datasetSources, tempDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer feedFiles.Close()
+
+	// A version missing sources or files of its dataset must never go live
+	imported := make(map[int]bool, len(feeds))
+	for _, feed := range feeds {
+		imported[feed.sourceID] = true
+	}
+	partial := selected != nil || len(imported) < len(datasetSources)
+
+	versionName := *name
+	if versionName == "" {
+		versionName = "import_" + time.Now().Format("2006-01-02_15:04:05")
+	}
+
+	var versionID int
+	if *throwaway {
+		description := fmt.Sprintf("Selective import of %s", filepath.Base(zipPath))
+		versionID, err = vc.CreateThrowawayVersion(ctx, versionName, description)
+	} else {
+		var info os.FileInfo
+		if info, err = os.Stat(zipPath); err == nil {
+			versionID, err = vc.CreateNewVersion(ctx, versionName, zipPath, info.ModTime())
+		}
+		if err == nil && partial {
+			err = vc.MarkPartial(ctx, versionID)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	opts := importer.DefaultOptions()
+	opts.BatchSize = *batchSize
+	opts.Files = selected
+
+	for _, feed := range feeds {
+		start := time.Now()
+		imp := importer.NewImporter(database, feed.sourceID, versionID, opts)
+		if err := imp.ImportReader(ctx, feed.data, feed.size); err != nil {
+			fmt.Fprintf(os.Stderr, "importing source %d into version %d: %v\n", feed.sourceID, versionID, err)
+			return 1
+		}
+		fmt.Printf("Imported source %d in %s\n", feed.sourceID, time.Since(start).Round(time.Second))
+	}
+
+	fmt.Printf("Imported version %d (%s)\n", versionID, versionName)
+	return 0
+}
+
+type importFeed struct {
+	sourceID int
+	data     io.ReaderAt
+	size     int64
+}
+
+// importSources opens the nested zips of a master zip that the layout
+// includes, in place where possible (see archive.Reader.OpenNested), or returns zipPath
+// itself as sourceID when it is a single feed. Every source must be one of
+// datasetSources. The feeds are readable until files is closed.
+func importSources(ctx context.Context, vc *db.VersionChecker, zipPath string, layout scraper.Layout, sourceID int, datasetSources map[int]bool, tempDir string) (feeds []importFeed, files closeAll, err error) {
+	masterFile, err := os.Open(zipPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening zip file: %w", err)
+	}
+	files = closeAll{masterFile}
+	defer func() {
+		if err != nil {
+			files.Close()
+		}
+	}()
+
+	info, err := masterFile.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening zip file: %w", err)
+	}
+	reader, err := archive.NewReader(masterFile, info.Size(), archive.DefaultLimits())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", zipPath, err)
+	}
+
+	known, err := vc.TransportSources(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, unknown := layout.Resolve(reader.File, known)
+	for _, name := range unknown {
+		fmt.Fprintf(os.Stderr, "skipping %s: not mapped to a registered transport source\n", name)
+	}
+
+	if sourceID != 0 {
+		if len(entries) > 0 {
+			return nil, nil, fmt.Errorf("%s is a master zip; choose sources with -sources instead of -source", zipPath)
+		}
+		feeds = append(feeds, importFeed{sourceID: sourceID, data: masterFile, size: info.Size()})
+	}
+	for _, entry := range entries {
+		path := filepath.Join(tempDir, fmt.Sprintf("source_%d.zip", entry.SourceID))
+		nested, err := reader.OpenNested(entry.File, path, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening nested zip for source %d: %w", entry.SourceID, err)
+		}
+		files = append(files, nested)
+		feeds = append(feeds, importFeed{sourceID: entry.SourceID, data: nested, size: nested.Size})
+	}
+	if len(feeds) == 0 {
+		return nil, nil, fmt.Errorf("no sources to import in %s; use -source for a single-feed zip", zipPath)
+	}
+
+	for _, feed := range feeds {
+		if !datasetSources[feed.sourceID] {
+			return nil, nil, fmt.Errorf("source %d is not registered to the dataset", feed.sourceID)
+		}
+	}
+
+	return feeds, files, nil
+}
+
+// selectFiles resolves -files and -skip-files to the files to import, or nil
+// for all of them. A skipped file that a selected file references is an
+// error, since the foreign keys couldn't be satisfied without it.
+func selectFiles(files, skip []string) ([]string, error) {
+	if len(files) == 0 && len(skip) == 0 {
+		return nil, nil
+	}
+	if len(files) == 0 {
+		files = importer.Files
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for _, name := range skip {
+		if _, err := importer.WithDependencies([]string{name}); err != nil {
+			return nil, err
+		}
+		skipped[name] = true
+	}
+
+	var wanted []string
+	for _, name := range files {
+		if !skipped[name] {
+			wanted = append(wanted, name)
+		}
+	}
+
+	selected, err := importer.WithDependencies(wanted)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range selected {
+		if skipped[name] {
+			return nil, fmt.Errorf("%s can't be skipped: other selected files reference it", name)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no GTFS files selected")
+	}
+	return selected, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -2,32 +2,66 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 
-	"github.com/joho/godotenv"
+	"github.com/ptvtracker-data/internal/api"
 	"github.com/ptvtracker-data/internal/common/config"
 	"github.com/ptvtracker-data/internal/common/db"
 	"github.com/ptvtracker-data/internal/common/logger"
 	"github.com/ptvtracker-data/internal/common/maintenance"
+	"github.com/ptvtracker-data/internal/gtfs-static/gates"
 	"github.com/ptvtracker-data/internal/gtfs-static/scraper"
 	gtfs_realtime "github.com/ptvtracker-data/internal/gtfs-realtime"
 )
 
 func main() {
-	// Load .env file if it exists
-	// In production, environment variables are typically provided directly
-	// rather than through a .env file, so we only load if the file exists
-	if err := godotenv.Load(); err != nil {
-		// Only panic if it's not a "file not found" error
-		if !os.IsNotExist(err) {
-			panic("Failed to load .env file: " + err.Error())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "versions":
+			os.Exit(runVersions(os.Args[2:]))
+		case "help", "-h", "--help":
+			printUsage(os.Stdout)
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+			printUsage(os.Stderr)
+			os.Exit(2)
 		}
-		// If .env doesn't exist, that's okay - proceed with environment variables
 	}
 
+	runServe()
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: ptvtracker [command] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  serve      run the ingestion service (default)")
+	fmt.Fprintln(w, "  validate   validate a GTFS zip or PTV master zip offline")
+	fmt.Fprintln(w, "  diff       compare two imported GTFS versions")
+	fmt.Fprintln(w, "  import     import chosen sources and files of a GTFS zip into a new version")
+	fmt.Fprintln(w, "  export     write an imported version back to a GTFS zip")
+	fmt.Fprintln(w, "  versions   list, activate, roll back and pin GTFS versions")
+}
+
+// runServe runs the long-lived ingestion service
+func runServe() {
+	loadDotEnv()
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -58,6 +92,7 @@ func main() {
 		"version", "1.0.0",
 		"log_level", cfg.Logging.Level,
 		"static_url", cfg.GTFSStatic.URL,
+		"static_datasets", len(cfg.StaticDatasets()),
 		"realtime_endpoints", len(cfg.GTFSRealtime.Endpoints),
 	)
 
@@ -95,24 +130,54 @@ func main() {
 		}
 	}(cleanupScheduler)
 
-	// Start GTFS-Static scheduler (single source)
-	log.Info("Starting GTFS-Static scheduler", "url", cfg.GTFSStatic.URL)
-	schedulerCfg := scraper.Config{
-		URL:           cfg.GTFSStatic.URL,
-		CheckInterval: cfg.GTFSStatic.CheckInterval,
-		DownloadDir:   cfg.GTFSStatic.DownloadDir,
-	}
-	// Create dependencies for scheduler
-	metadataFetcher := scraper.NewHTTPMetadataFetcher(log)
-	downloader := scraper.NewHTTPDownloader(log)
-	scheduler := scraper.NewScheduler(schedulerCfg, database, log, metadataFetcher, downloader, cleanupScheduler)
-	wg.Add(1)
-	go func(s *scraper.GTFSScheduler) {
-		defer wg.Done()
-		if err := s.Start(ctx); err != nil {
-			log.Error("GTFS-Static scheduler error", "error", err)
+	// Start one GTFS-Static scheduler per dataset
+	for _, static := range cfg.StaticDatasets() {
+		log.Info("Starting GTFS-Static scheduler",
+			"dataset", static.Dataset,
+			"url", static.URL,
+			"source_type", static.SourceType)
+		schedulerCfg := scraper.Config{
+			Dataset:             static.Dataset,
+			CheckInterval:       static.CheckInterval,
+			DownloadDir:         static.DownloadDir,
+			Validation:          scraper.ValidationMode(static.Validation),
+			ValidationReportDir: static.ValidationReportDir,
+			CopyBatchSize:       static.CopyBatchSize,
+			ImportParallelism:   static.ImportParallelism,
+			MaterializeDays:     static.MaterializeDays,
+			ShapeTolerances:     static.ShapeTolerances,
+			StopClusterRadius:   static.StopClusterRadius,
+			Gates: gates.Config{
+				MaxRowChangePercent: static.GateMaxRowChangePercent,
+				RequiredSources:     static.GateRequiredSources,
+				MinCalendarDays:     static.GateMinCalendarDays,
+				MaxValidationErrors: static.GateMaxValidationErrors,
+			},
+			NotifyWebhookURL: cfg.Logging.DiscordURL,
+		}
+		// Create the dataset source for the scheduler
+		downloads := scraper.DefaultDownloaderConfig()
+		downloads.MaxAttempts = static.DownloadMaxAttempts
+		downloads.IdleTimeout = static.DownloadIdleTimeout
+		downloads.MaxSize = int64(static.DownloadMaxSizeMB) << 20
+		source, err := scraper.NewStaticSource(scraper.StaticSourceType(static.SourceType), static.URL, downloads, log)
+		if err != nil {
+			log.Fatal("Invalid GTFS-Static source", "dataset", static.Dataset, "error", err)
+		}
+		layout, err := scraper.NewLayout(static.NestedPattern, static.NestedSources, static.IncludeSources, static.ExcludeSources)
+		if err != nil {
+			log.Fatal("Invalid GTFS-Static archive layout", "dataset", static.Dataset, "error", err)
 		}
-	}(scheduler)
+		schedulerCfg.Layout = layout
+		scheduler := scraper.NewScheduler(schedulerCfg, database, log, source, cleanupScheduler)
+		wg.Add(1)
+		go func(s *scraper.GTFSScheduler, dataset string) {
+			defer wg.Done()
+			if err := s.Start(ctx); err != nil {
+				log.Error("GTFS-Static scheduler error", "dataset", dataset, "error", err)
+			}
+		}(scheduler, static.Dataset)
+	}
 
 	// Start GTFS-Realtime manager (if API key is provided)
 	if cfg.GTFSRealtime.APIKey != "" {
@@ -129,6 +194,18 @@ func main() {
 		log.Info("GTFS-Realtime manager disabled (no API key provided)")
 	}
 
+	// Start REST API (if an address is configured)
+	if cfg.API.Addr != "" {
+		apiServer := api.NewServer(cfg.API.Addr, database, log)
+		wg.Add(1)
+		go func(s *api.Server) {
+			defer wg.Done()
+			if err := s.Start(ctx); err != nil {
+				log.Error("REST API error", "error", err)
+			}
+		}(apiServer)
+	}
+
 	// Wait for shutdown signal
 	<-sigChan
 	log.Info("Shutdown signal received")
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ptvtracker-data/internal/common/logger"
+	"github.com/ptvtracker-data/internal/gtfs-static/archive"
+	"github.com/ptvtracker-data/internal/gtfs-static/parser"
+	"github.com/ptvtracker-data/internal/gtfs-static/scraper"
+	"github.com/ptvtracker-data/internal/gtfs-static/validator"
+	"github.com/ptvtracker-data/pkg/gtfs-static/models"
+)
+
+// sourceResult is the outcome of parsing and validating one GTFS feed
+type sourceResult struct {
+	Source     string            `json:"source"`
+	Records    map[string]int    `json:"records"`
+	ParseError string            `json:"parse_error,omitempty"`
+	Report     *validator.Report `json:"report"`
+}
+
+func (r *sourceResult) failed() bool {
+	return r.ParseError != "" || r.Report.HasErrors()
+}
+
+// runValidate parses and validates a GTFS zip, or every source inside a PTV
+// master zip, without touching the database. Returns the process exit code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print the full reports as JSON")
+	maxIssues := fs.Int("issues", 20, "number of issues to print per source (text output only)")
+	verbose := fs.Bool("v", false, "log parser and validator progress")
+	pattern := fs.String("pattern", scraper.DefaultNestedPattern, "pattern matching nested source zips in a master zip; its first group names the source")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ptvtracker validate [flags] <gtfs.zip>")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Accepts a single GTFS zip or a master zip with nested source zips, by default the PTV N/google_transit.zip layout.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	zipPath := fs.Arg(0)
+
+	log := cliLogger(*verbose)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	tempDir, err := os.MkdirTemp("", "ptvtracker-validate-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating temp directory: %v\n", err)
+		return 2
+	}
+	defer os.RemoveAll(tempDir)
+
+	layout, err := scraper.NewLayout(*pattern, nil, nil, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	sources, files, err := openSources(zipPath, layout, tempDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	defer files.Close()
+
+	results := make([]*sourceResult, 0, len(sources))
+	for _, src := range sources {
+		result, err := validateFeed(ctx, log, src.name, src.data, src.size)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", src.name, err)
+			return 2
+		}
+		results = append(results, result)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Fprintf(os.Stderr, "writing JSON: %v\n", err)
+			return 2
+		}
+	} else {
+		printResults(os.Stdout, results, *maxIssues)
+	}
+
+	for _, r := range results {
+		if r.failed() {
+			return 1
+		}
+	}
+	return 0
+}
+
+type feedSource struct {
+	name string
+	data io.ReaderAt
+	size int64
+}
+
+// openSources returns the GTFS feeds in zipPath. A master zip is detected by
+// nested zips matching the layout's pattern, which are read in place where
+// possible (see archive.Reader.OpenNested). The feeds are readable until files is closed.
+func openSources(zipPath string, layout scraper.Layout, tempDir string) (sources []feedSource, files closeAll, err error) {
+	masterFile, err := os.Open(zipPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening zip file: %w", err)
+	}
+	files = closeAll{masterFile}
+	defer func() {
+		if err != nil {
+			files.Close()
+		}
+	}()
+
+	info, err := masterFile.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening zip file: %w", err)
+	}
+	reader, err := archive.NewReader(masterFile, info.Size(), archive.DefaultLimits())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", zipPath, err)
+	}
+
+	for i, file := range reader.File {
+		key, ok := layout.Match(file.Name)
+		if !ok {
+			continue
+		}
+
+		nested, err := reader.OpenNested(file, filepath.Join(tempDir, fmt.Sprintf("source_%d.zip", i)), nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening nested zip for source %s: %w", key, err)
+		}
+		files = append(files, nested)
+		sources = append(sources, feedSource{name: "source " + key, data: nested, size: nested.Size})
+	}
+	sort.Slice(sources, func(i, j int) bool { return naturalLess(sources[i].name, sources[j].name) })
+
+	if len(sources) == 0 {
+		sources = []feedSource{{name: filepath.Base(zipPath), data: masterFile, size: info.Size()}}
+	}
+	return sources, files, nil
+}
+
+// closeAll closes several files, returning the first error
+type closeAll []io.Closer
+
+func (c closeAll) Close() error {
+	var first error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// validateFeed runs the parser with counting callbacks, then the validator.
+// A parser failure is recorded in the result rather than aborting the run.
+func validateFeed(ctx context.Context, log logger.Logger, name string, data io.ReaderAt, size int64) (*sourceResult, error) {
+	result := &sourceResult{
+		Source:  name,
+		Records: make(map[string]int),
+	}
+
+	count := func(file string) { result.Records[file]++ }
+	callbacks := parser.ParseCallbacks{
+		OnAgency:       func(*models.Agency) error { count("agency.txt"); return nil },
+		OnStop:         func(*models.Stop) error { count("stops.txt"); return nil },
+		OnRoute:        func(*models.Route) error { count("routes.txt"); return nil },
+		OnTrip:         func(*models.Trip) error { count("trips.txt"); return nil },
+		OnStopTime:     func(*models.StopTime) error { count("stop_times.txt"); return nil },
+		OnCalendar:     func(*models.Calendar) error { count("calendar.txt"); return nil },
+		OnCalendarDate: func(*models.CalendarDate) error { count("calendar_dates.txt"); return nil },
+		OnShape:        func(*models.Shape) error { count("shapes.txt"); return nil },
+		OnLevel:        func(*models.Level) error { count("levels.txt"); return nil },
+		OnPathway:      func(*models.Pathway) error { count("pathways.txt"); return nil },
+		OnTransfer:     func(*models.Transfer) error { count("transfers.txt"); return nil },
+	}
+
+	if err := parser.New(log).ParseReader(ctx, data, size, callbacks); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		result.ParseError = err.Error()
+	}
+
+	report, err := validator.New(log, validator.DefaultOptions()).ValidateReader(ctx, data, size)
+	if err != nil {
+		return nil, err
+	}
+	result.Report = report
+
+	return result, nil
+}
+
+func printResults(w io.Writer, results []*sourceResult, maxIssues int) {
+	for i, r := range results {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "== %s ==\n", r.Source)
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FILE\tRECORDS\tERRORS\tWARNINGS")
+		for _, file := range fileNames(r) {
+			summary := r.Report.Files[file]
+			if summary == nil {
+				summary = &validator.FileSummary{}
+			}
+			records, ok := r.Records[file]
+			if !ok {
+				records = summary.Records
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", file, records, summary.Errors, summary.Warnings)
+		}
+		fmt.Fprintf(tw, "total\t\t%d\t%d\n", r.Report.ErrorCount, r.Report.WarningCount)
+		tw.Flush()
+
+		if r.ParseError != "" {
+			fmt.Fprintf(w, "parse error: %s\n", r.ParseError)
+		}
+
+		shown := 0
+		for _, issue := range r.Report.Issues {
+			if shown >= maxIssues {
+				break
+			}
+			fmt.Fprintf(w, "%-7s %s:%d", issue.Severity, issue.File, issue.Line)
+			if issue.Field != "" {
+				fmt.Fprintf(w, " [%s]", issue.Field)
+			}
+			fmt.Fprintf(w, " %s: %s\n", issue.Code, issue.Message)
+			shown++
+		}
+		if hidden := r.Report.ErrorCount + r.Report.WarningCount - shown; hidden > 0 {
+			fmt.Fprintf(w, "... %d more issues (use -json for the full report)\n", hidden)
+		}
+	}
+}
+
+// fileNames merges the files seen by the parser and the validator
+func fileNames(r *sourceResult) []string {
+	seen := make(map[string]bool)
+	for _, name := range r.Report.FileNames() {
+		seen[name] = true
+	}
+	for name := range r.Records {
+		seen[name] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// naturalLess orders names with numeric suffixes numerically, so "source 2"
+// sorts before "source 10"
+func naturalLess(a, b string) bool {
+	ai, aerr := strconv.Atoi(a[strings.LastIndexByte(a, ' ')+1:])
+	bi, berr := strconv.Atoi(b[strings.LastIndexByte(b, ' ')+1:])
+	if aerr == nil && berr == nil {
+		return ai < bi
+	}
+	return a < b
+}
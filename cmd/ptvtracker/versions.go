@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/pkg/gtfs-static/models"
+)
+
+func printVersionsUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: ptvtracker versions <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  list [-json]               list versions with record counts and sizes")
+	fmt.Fprintln(w, "  activate [-force] <id>     make a version active within its dataset and pin it")
+	fmt.Fprintln(w, "  rollback [-dataset name]   reactivate and pin the dataset's previously active version")
+	fmt.Fprintln(w, "  pin <id>                   pin a version (no auto-activation, never cleaned up)")
+	fmt.Fprintln(w, "  unpin <id>                 remove a pin; unpinning the active version resumes automatic updates")
+}
+
+// runVersions manages GTFS versions by hand. Returns the process exit code.
+func runVersions(args []string) int {
+	if len(args) == 0 {
+		printVersionsUsage(os.Stderr)
+		return 2
+	}
+
+	command, args := args[0], args[1:]
+	fs := flag.NewFlagSet("versions "+command, flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print as JSON (list only)")
+	force := fs.Bool("force", false, "activate even if the version's import did not complete (activate only)")
+	dataset := fs.String("dataset", db.DefaultDataset, "dataset to roll back (rollback only)")
+	verbose := fs.Bool("v", false, "log database activity")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var versionID int
+	switch command {
+	case "activate", "pin", "unpin":
+		if fs.NArg() != 1 {
+			printVersionsUsage(os.Stderr)
+			return 2
+		}
+		id, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid version ID %q\n", fs.Arg(0))
+			return 2
+		}
+		versionID = id
+	case "list", "rollback":
+	case "help", "-h", "--help":
+		printVersionsUsage(os.Stdout)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown versions command %q\n\n", command)
+		printVersionsUsage(os.Stderr)
+		return 2
+	}
+
+	log := cliLogger(*verbose)
+	database, err := openDatabase(log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer database.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	vc := db.NewDatasetVersionChecker(database, *dataset)
+
+	switch command {
+	case "list":
+		versions, err := vc.ListVersions(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(versions); err != nil {
+				fmt.Fprintf(os.Stderr, "writing JSON: %v\n", err)
+				return 1
+			}
+			return 0
+		}
+		printVersions(os.Stdout, versions)
+
+	case "activate":
+		if !*force {
+			if err := vc.CheckActivatable(ctx, versionID); err != nil {
+				fmt.Fprintf(os.Stderr, "refusing to activate: %v (use -force to override)\n", err)
+				return 1
+			}
+		}
+		if err := vc.ActivatePinned(ctx, versionID); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Activated and pinned version %d; unpin it to resume automatic updates\n", versionID)
+
+	case "rollback":
+		id, err := vc.Rollback(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Rolled back dataset %s to version %d and pinned it; unpin it to resume automatic updates\n", *dataset, id)
+
+	case "pin", "unpin":
+		if err := vc.SetPinned(ctx, versionID, command == "pin"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Version %d %sned\n", versionID, command)
+	}
+
+	return 0
+}
+
+func printVersions(w io.Writer, versions []models.VersionSummary) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDATASET\tNAME\tSTATE\tACTIVATED\tSTOP_TIMES\tTRIPS\tSTOPS\tSIZE\tAGE")
+	for _, v := range versions {
+		state := "inactive"
+		if v.IsActive {
+			state = "active"
+		}
+		if v.IsPinned {
+			state += ",pinned"
+		}
+		activated := "-"
+		if v.ActivatedAt != nil {
+			activated = v.ActivatedAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%dd\n",
+			v.VersionID, v.Dataset, v.VersionName, state, activated,
+			v.StopTimesCount, v.TripsCount, v.StopsCount, v.EstimatedSize, v.AgeDays)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,393 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ptvtracker-data/pkg/gtfs-static/gtfstime"
+)
+
+const (
+	defaultDepartureLimit  = 20
+	maxDepartureLimit      = 200
+	defaultDepartureWindow = time.Hour
+	maxDepartureWindow     = 24 * time.Hour
+
+	// fromSkew is how far in the past the from parameter may be, for client
+	// clocks running behind; such a from is read as now
+	fromSkew = time.Minute
+)
+
+// Departure is one scheduled departure, with realtime estimates when the
+// latest trip updates cover it
+type Departure struct {
+	TripID             string     `json:"trip_id"`
+	RouteID            string     `json:"route_id"`
+	RouteShortName     string     `json:"route_short_name"`
+	RouteLongName      string     `json:"route_long_name"`
+	RouteType          int        `json:"route_type"`
+	RouteColor         string     `json:"route_color,omitempty"`
+	RouteTextColor     string     `json:"route_text_color,omitempty"`
+	Headsign           string     `json:"headsign"`
+	DirectionID        *int       `json:"direction_id"`
+	Platform           string     `json:"platform"`
+	StopSequence       int        `json:"stop_sequence"`
+	ScheduledDeparture time.Time  `json:"scheduled_departure"`
+	EstimatedDeparture *time.Time `json:"estimated_departure,omitempty"`
+	DelaySeconds       *int       `json:"delay_seconds,omitempty"`
+	Skipped            bool       `json:"skipped,omitempty"`
+	Realtime           bool       `json:"realtime"`
+}
+
+// DeparturesResponse is the body of GET .../departures
+type DeparturesResponse struct {
+	StopID     string      `json:"stop_id"`
+	SourceID   int         `json:"source_id"`
+	From       time.Time   `json:"from"`
+	To         time.Time   `json:"to"`
+	Departures []Departure `json:"departures"`
+	Pagination Pagination  `json:"pagination"`
+}
+
+// Pagination describes the page of a list response. NextOffset is set when
+// there are more results.
+type Pagination struct {
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// departureQuery is a parsed departures request
+type departureQuery struct {
+	stopID      string
+	sourceID    int
+	routeIDs    []string
+	directionID sql.NullInt16
+	serviceDate time.Time
+	from        time.Time
+	to          time.Time
+	limit       int
+	offset      int
+}
+
+// handleDepartures lists upcoming departures at a stop, or at every platform
+// of a station, from gtfs.get_stop_departures in the active version.
+//
+// Query parameters: route_id (repeatable or comma-separated), direction_id
+// (0 or 1), from (RFC 3339, default now), window (duration, default 1h, at
+// most 24h), limit (default 20, at most 200) and offset.
+//
+// gtfs.get_stop_departures only covers the current service day from now
+// on, so from must lie between now and the end of the service day, and a
+// window running past the end stops there; the response's to says where.
+func (s *Server) handleDepartures(w http.ResponseWriter, r *http.Request) {
+	q, err := s.parseDepartureQuery(r, time.Now())
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	ctx := r.Context()
+	exists, err := s.stopExists(ctx, q.stopID, q.sourceID)
+	if err != nil {
+		s.logger.Error("Failed to look up stop", "stop_id", q.stopID, "source_id", q.sourceID, "error", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to look up stop")
+		return
+	}
+	if !exists {
+		s.writeError(w, http.StatusNotFound, "stop %s not found in source %d", q.stopID, q.sourceID)
+		return
+	}
+
+	departures, more, err := s.queryDepartures(ctx, q)
+	if err != nil {
+		s.logger.Error("Failed to query departures", "stop_id", q.stopID, "source_id", q.sourceID, "error", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to query departures")
+		return
+	}
+
+	// Realtime is best effort: without it the timetable is still useful
+	if len(departures) > 0 {
+		if err := s.mergeRealtime(ctx, q, departures); err != nil {
+			s.logger.Warn("Failed to merge realtime updates", "stop_id", q.stopID, "source_id", q.sourceID, "error", err)
+		}
+	}
+
+	resp := DeparturesResponse{
+		StopID:     q.stopID,
+		SourceID:   q.sourceID,
+		From:       q.from.UTC(),
+		To:         q.to.UTC(),
+		Departures: departures,
+		Pagination: Pagination{Limit: q.limit, Offset: q.offset},
+	}
+	if more {
+		next := q.offset + q.limit
+		resp.Pagination.NextOffset = &next
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) parseDepartureQuery(r *http.Request, now time.Time) (*departureQuery, error) {
+	sourceID, err := strconv.Atoi(r.PathValue("source_id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source_id %q", r.PathValue("source_id"))
+	}
+
+	q := &departureQuery{
+		stopID:      r.PathValue("stop_id"),
+		sourceID:    sourceID,
+		serviceDate: gtfstime.ServiceDate(now, s.location),
+		from:        now,
+		limit:       defaultDepartureLimit,
+	}
+	dayEnd := q.serviceDate.AddDate(0, 0, 1)
+	params := r.URL.Query()
+
+	for _, value := range params["route_id"] {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				q.routeIDs = append(q.routeIDs, id)
+			}
+		}
+	}
+
+	if value := params.Get("direction_id"); value != "" {
+		if value != "0" && value != "1" {
+			return nil, fmt.Errorf("direction_id must be 0 or 1")
+		}
+		direction, _ := strconv.Atoi(value)
+		q.directionID = sql.NullInt16{Int16: int16(direction), Valid: true}
+	}
+
+	if value := params.Get("from"); value != "" {
+		from, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from %q: want RFC 3339, e.g. 2024-05-01T08:00:00+10:00", value)
+		}
+		if from.Before(now.Add(-fromSkew)) {
+			return nil, fmt.Errorf("from %s is in the past: departures are only listed from now on", value)
+		}
+		if !from.Before(dayEnd) {
+			return nil, fmt.Errorf("from %s is past the end of the current service day, %s", value, dayEnd.Format(time.RFC3339))
+		}
+		if from.After(now) {
+			q.from = from
+		}
+	}
+
+	window := defaultDepartureWindow
+	if value := params.Get("window"); value != "" {
+		window, err = time.ParseDuration(value)
+		if err != nil || window <= 0 || window > maxDepartureWindow {
+			return nil, fmt.Errorf("invalid window %q: want a duration up to %s, e.g. 90m", value, maxDepartureWindow)
+		}
+	}
+	q.to = q.from.Add(window)
+	if q.to.After(dayEnd) {
+		q.to = dayEnd
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxDepartureLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxDepartureLimit)
+		}
+		q.limit = limit
+	}
+
+	if value := params.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("offset must be a non-negative integer")
+		}
+		q.offset = offset
+	}
+
+	return q, nil
+}
+
+// stopExists reports whether stopID is a stop of the source's active version
+func (s *Server) stopExists(ctx context.Context, stopID string, sourceID int) (bool, error) {
+	var exists bool
+	err := s.db.DB().QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM gtfs.stops
+			WHERE stop_id = $1 AND source_id = $2
+			  AND version_id = gtfs.active_version_for_source($2)
+		)
+	`, stopID, sourceID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("checking stop: %w", err)
+	}
+	return exists, nil
+}
+
+// queryDepartures returns a page of departures in the query's window and
+// whether there are more. The function is called without a limit, since
+// filters and paging apply to its result.
+func (s *Server) queryDepartures(ctx context.Context, q *departureQuery) ([]Departure, bool, error) {
+	dayStart := gtfstime.ServiceDayStart(q.serviceDate, s.location)
+	fromSeconds := int(q.from.Sub(dayStart) / time.Second)
+	toSeconds := int(q.to.Sub(dayStart) / time.Second)
+
+	rows, err := s.db.DB().QueryContext(ctx, `
+		SELECT trip_id, route_id, COALESCE(route_short_name, ''), COALESCE(route_long_name, ''),
+		       route_type, COALESCE(route_color, ''), COALESCE(route_text_color, ''),
+		       COALESCE(trip_headsign, ''), direction_id, COALESCE(platform_id, ''),
+		       stop_sequence, scheduled_departure_time_seconds
+		FROM gtfs.get_stop_departures($1, $2, NULL)
+		WHERE scheduled_departure_time_seconds >= $3 AND scheduled_departure_time_seconds < $4
+		  AND (cardinality($5::text[]) = 0 OR route_id = ANY($5::text[]))
+		  AND ($6::smallint IS NULL OR direction_id = $6)
+		ORDER BY scheduled_departure_time_seconds, route_id, trip_id
+		LIMIT $7 OFFSET $8
+	`, q.stopID, q.sourceID, fromSeconds, toSeconds, pq.Array(q.routeIDs), q.directionID, q.limit+1, q.offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("querying departures: %w", err)
+	}
+	defer rows.Close()
+
+	departures := []Departure{}
+	for rows.Next() {
+		var d Departure
+		var direction sql.NullInt16
+		var seconds int
+		if err := rows.Scan(
+			&d.TripID,
+			&d.RouteID,
+			&d.RouteShortName,
+			&d.RouteLongName,
+			&d.RouteType,
+			&d.RouteColor,
+			&d.RouteTextColor,
+			&d.Headsign,
+			&direction,
+			&d.Platform,
+			&d.StopSequence,
+			&seconds,
+		); err != nil {
+			return nil, false, fmt.Errorf("scanning departure: %w", err)
+		}
+		if direction.Valid {
+			id := int(direction.Int16)
+			d.DirectionID = &id
+		}
+		d.ScheduledDeparture = gtfstime.Resolve(q.serviceDate, seconds, s.location)
+		departures = append(departures, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("iterating departures: %w", err)
+	}
+
+	if len(departures) > q.limit {
+		return departures[:q.limit], true, nil
+	}
+	return departures, false, nil
+}
+
+// stopTimeUpdate is a row of gtfs_rt.get_stop_time_updates
+type stopTimeUpdate struct {
+	startDate      sql.NullTime
+	stopSequence   int
+	departureDelay sql.NullInt64
+	departureTime  sql.NullInt64
+	arrivalDelay   sql.NullInt64
+	arrivalTime    sql.NullInt64
+	skipped        bool
+}
+
+// mergeRealtime fills in estimates from the source's latest trip updates at
+// the stop. An update matches a departure by trip and stop_sequence, or by
+// trip alone when the trip has a single update at the stop.
+func (s *Server) mergeRealtime(ctx context.Context, q *departureQuery, departures []Departure) error {
+	rows, err := s.db.DB().QueryContext(ctx, `
+		SELECT trip_id, start_date, stop_sequence, departure_delay, departure_time,
+		       arrival_delay, arrival_time, schedule_relationship
+		FROM gtfs_rt.get_stop_time_updates($1, $2)
+	`, q.stopID, q.sourceID)
+	if err != nil {
+		return fmt.Errorf("querying stop time updates: %w", err)
+	}
+	defer rows.Close()
+
+	// Overnight departures run on yesterday's service
+	yesterday := q.serviceDate.AddDate(0, 0, -1)
+
+	updates := make(map[string][]stopTimeUpdate)
+	for rows.Next() {
+		var tripID string
+		var u stopTimeUpdate
+		var relationship sql.NullInt16
+		if err := rows.Scan(&tripID, &u.startDate, &u.stopSequence, &u.departureDelay, &u.departureTime,
+			&u.arrivalDelay, &u.arrivalTime, &relationship); err != nil {
+			return fmt.Errorf("scanning stop time update: %w", err)
+		}
+		if u.startDate.Valid && !sameDate(u.startDate.Time, q.serviceDate) && !sameDate(u.startDate.Time, yesterday) {
+			continue
+		}
+		u.skipped = relationship.Valid && relationship.Int16 == 1 // StopTimeUpdate SKIPPED
+		updates[tripID] = append(updates[tripID], u)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating stop time updates: %w", err)
+	}
+
+	for i := range departures {
+		d := &departures[i]
+		candidates := updates[d.TripID]
+
+		var match *stopTimeUpdate
+		for j := range candidates {
+			if candidates[j].stopSequence == d.StopSequence {
+				match = &candidates[j]
+				break
+			}
+		}
+		if match == nil && len(candidates) == 1 {
+			match = &candidates[0]
+		}
+		if match != nil {
+			applyUpdate(d, match)
+		}
+	}
+	return nil
+}
+
+// applyUpdate sets a departure's estimate, preferring departure over arrival
+// predictions and absolute times over delays
+func applyUpdate(d *Departure, u *stopTimeUpdate) {
+	if u.skipped {
+		d.Realtime, d.Skipped = true, true
+		return
+	}
+
+	var estimated time.Time
+	switch {
+	case u.departureTime.Valid && u.departureTime.Int64 > 0:
+		estimated = time.Unix(u.departureTime.Int64, 0).UTC()
+	case u.departureDelay.Valid:
+		estimated = d.ScheduledDeparture.Add(time.Duration(u.departureDelay.Int64) * time.Second)
+	case u.arrivalTime.Valid && u.arrivalTime.Int64 > 0:
+		estimated = time.Unix(u.arrivalTime.Int64, 0).UTC()
+	case u.arrivalDelay.Valid:
+		estimated = d.ScheduledDeparture.Add(time.Duration(u.arrivalDelay.Int64) * time.Second)
+	default:
+		return
+	}
+
+	delay := int(estimated.Sub(d.ScheduledDeparture) / time.Second)
+	d.Realtime = true
+	d.EstimatedDeparture = &estimated
+	d.DelaySeconds = &delay
+}
+
+func sameDate(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.Month() == b.Month() && a.Day() == b.Day()
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/common/logger"
+	"github.com/ptvtracker-data/internal/gtfs-static/importer"
+	"github.com/ptvtracker-data/pkg/gtfs-static/gtfstime"
+)
+
+// The fixture lives in its own dataset and source, so activating its version
+// leaves the real data alone
+const (
+	testDataset  = "api_test"
+	testSourceID = 9901
+	testStopID   = "S1"
+)
+
+// fixture is a small version loaded through the importer, with departures
+// from S1 at fixed offsets from when the test starts
+type fixture struct {
+	db          *db.DB
+	serviceDate time.Time
+	first       time.Time // T1's scheduled departure from S1
+}
+
+// fixtureTrips are the trips departing S1, in minutes after the first
+var fixtureTrips = []struct {
+	tripID    string
+	routeID   string
+	direction int
+	minutes   int
+}{
+	{"T1", "R1", 0, 0},
+	{"T2", "R1", 1, 10},
+	{"T3", "R2", 0, 20},
+	{"T4", "R2", 0, 30},
+	{"T5", "R1", 0, 90}, // outside the default one hour window
+}
+
+func TestDepartures(t *testing.T) {
+	f := loadFixture(t)
+	server := NewServer("", f.db, logger.New(io.Discard))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	path := fmt.Sprintf("/api/v1/sources/%d/stops/%s/departures", testSourceID, testStopID)
+
+	t.Run("unknown stop", func(t *testing.T) {
+		status, _ := get(t, ts, fmt.Sprintf("/api/v1/sources/%d/stops/NOPE/departures", testSourceID))
+		if status != http.StatusNotFound {
+			t.Fatalf("status = %d, want 404", status)
+		}
+	})
+
+	t.Run("bad parameters", func(t *testing.T) {
+		now := time.Now()
+		for _, query := range []string{
+			"direction_id=2",
+			"direction_id=x",
+			"window=0s",
+			"window=abc",
+			"window=25h",
+			"limit=0",
+			"limit=201",
+			"limit=x",
+			"offset=-1",
+			"offset=x",
+			"from=yesterday",
+			"from=" + url.QueryEscape(now.Add(-time.Hour).Format(time.RFC3339)),
+			"from=" + url.QueryEscape(f.serviceDate.AddDate(0, 0, 1).Add(time.Hour).Format(time.RFC3339)),
+		} {
+			status, _ := get(t, ts, path+"?"+query)
+			if status != http.StatusBadRequest {
+				t.Errorf("%s: status = %d, want 400", query, status)
+			}
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		resp := getDepartures(t, ts, path)
+		if got, want := tripIDs(resp), []string{"T1", "T2", "T3", "T4"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("trips = %v, want %v", got, want)
+		}
+		if !resp.Departures[0].ScheduledDeparture.Equal(f.first) {
+			t.Errorf("T1 scheduled at %s, want %s", resp.Departures[0].ScheduledDeparture, f.first)
+		}
+
+		from := f.first.Add(15 * time.Minute)
+		resp = getDepartures(t, ts, path+"?window=2h&from="+url.QueryEscape(from.Format(time.RFC3339)))
+		if got, want := tripIDs(resp), []string{"T3", "T4", "T5"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("trips from %s = %v, want %v", from, got, want)
+		}
+	})
+
+	t.Run("window stops at the end of the service day", func(t *testing.T) {
+		resp := getDepartures(t, ts, path+"?window=24h")
+		if dayEnd := f.serviceDate.AddDate(0, 0, 1); !resp.To.Equal(dayEnd) {
+			t.Errorf("to = %s, want %s", resp.To, dayEnd)
+		}
+	})
+
+	t.Run("route and direction filters", func(t *testing.T) {
+		resp := getDepartures(t, ts, path+"?route_id=R2")
+		if got, want := tripIDs(resp), []string{"T3", "T4"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("route R2 trips = %v, want %v", got, want)
+		}
+
+		resp = getDepartures(t, ts, path+"?route_id=R1&direction_id=1")
+		if got, want := tripIDs(resp), []string{"T2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("route R1 direction 1 trips = %v, want %v", got, want)
+		}
+
+		resp = getDepartures(t, ts, path+"?route_id=R2,R9&route_id=R1&direction_id=0")
+		if got, want := tripIDs(resp), []string{"T1", "T3", "T4"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("direction 0 trips = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		resp := getDepartures(t, ts, path+"?limit=3")
+		if got, want := tripIDs(resp), []string{"T1", "T2", "T3"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("first page = %v, want %v", got, want)
+		}
+		if next := resp.Pagination.NextOffset; next == nil || *next != 3 {
+			t.Fatalf("next_offset = %v, want 3", next)
+		}
+
+		resp = getDepartures(t, ts, path+"?limit=3&offset=3")
+		if got, want := tripIDs(resp), []string{"T4"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("second page = %v, want %v", got, want)
+		}
+		if next := resp.Pagination.NextOffset; next != nil {
+			t.Errorf("next_offset = %d on the last page, want none", *next)
+		}
+	})
+
+	t.Run("realtime", func(t *testing.T) {
+		resp := getDepartures(t, ts, path)
+		byTrip := make(map[string]Departure)
+		for _, d := range resp.Departures {
+			byTrip[d.TripID] = d
+		}
+
+		delayed := byTrip["T1"]
+		if !delayed.Realtime || delayed.DelaySeconds == nil || *delayed.DelaySeconds != 120 {
+			t.Errorf("T1 realtime = %v, delay = %v, want a 120s delay", delayed.Realtime, delayed.DelaySeconds)
+		}
+		if want := f.first.Add(2 * time.Minute); delayed.EstimatedDeparture == nil || !delayed.EstimatedDeparture.Equal(want) {
+			t.Errorf("T1 estimated = %v, want %s", delayed.EstimatedDeparture, want)
+		}
+
+		if skipped := byTrip["T2"]; !skipped.Realtime || !skipped.Skipped {
+			t.Errorf("T2 realtime = %v, skipped = %v, want a skipped stop", skipped.Realtime, skipped.Skipped)
+		}
+
+		if scheduled := byTrip["T3"]; scheduled.Realtime || scheduled.EstimatedDeparture != nil {
+			t.Errorf("T3 has realtime data, want the timetable only")
+		}
+	})
+}
+
+// loadFixture imports the fixture feed into a fresh active version of the
+// test dataset, with a trip update delaying T1 and skipping T2 at S1. It
+// skips the test without a database.
+func loadFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+
+	loc, err := time.LoadLocation("Australia/Melbourne")
+	if err != nil {
+		t.Fatalf("loading timezone: %v", err)
+	}
+	now := time.Now().In(loc)
+	serviceDate := gtfstime.ServiceDate(now, loc)
+	if now.Add(3 * time.Hour).After(serviceDate.AddDate(0, 0, 1)) {
+		t.Skip("too close to the end of the service day for the fixture's departures")
+	}
+
+	database, err := db.New(connStr, logger.New(io.Discard))
+	if err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	ctx := context.Background()
+	conn := database.DB()
+
+	cleanup := func() {
+		conn.ExecContext(ctx, "DELETE FROM gtfs_rt.feed_messages WHERE source_id = $1", testSourceID)
+		conn.ExecContext(ctx, "DELETE FROM gtfs.versions WHERE dataset = $1", testDataset)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	for _, stmt := range []string{
+		`INSERT INTO gtfs.datasets (dataset, description) VALUES ('api_test', 'REST API test fixture')
+		 ON CONFLICT (dataset) DO NOTHING`,
+		`INSERT INTO gtfs.transport_sources (source_id, source_name, description, dataset)
+		 VALUES (9901, 'API test', 'REST API test fixture', 'api_test')
+		 ON CONFLICT (source_id) DO UPDATE SET dataset = EXCLUDED.dataset`,
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("creating test source: %v", err)
+		}
+	}
+
+	// Departures start on the next minute after five minutes from now, so
+	// they are still upcoming while the test runs
+	dayStart := gtfstime.ServiceDayStart(serviceDate, loc)
+	firstSeconds := int(now.Add(5*time.Minute).Sub(dayStart)/time.Minute+1) * 60
+
+	vc := db.NewDatasetVersionChecker(database, testDataset)
+	versionID, err := vc.CreateNewVersion(ctx, "api_test", "fixture", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp := importer.NewImporter(database, testSourceID, versionID, importer.DefaultOptions())
+	if err := imp.ImportFS(ctx, fixtureFeed(serviceDate, firstSeconds)); err != nil {
+		t.Fatalf("importing fixture: %v", err)
+	}
+	if err := vc.ActivateVersion(ctx, versionID); err != nil {
+		t.Fatal(err)
+	}
+
+	var feedMessageID int
+	if err := conn.QueryRowContext(ctx, `
+		INSERT INTO gtfs_rt.feed_messages (timestamp, source_id, version_id, feed_type)
+		VALUES (NOW(), $1, $2, 'trip_updates')
+		RETURNING feed_message_id
+	`, testSourceID, versionID).Scan(&feedMessageID); err != nil {
+		t.Fatalf("inserting feed message: %v", err)
+	}
+	for _, update := range []struct {
+		tripID       string
+		delay        any
+		relationship int
+	}{
+		{"T1", 120, 0},
+		{"T2", nil, 1}, // SKIPPED
+	} {
+		if _, err := conn.ExecContext(ctx, `
+			WITH tu AS (
+				INSERT INTO gtfs_rt.trip_updates (feed_message_id, entity_id, trip_id, start_date)
+				VALUES ($1, $2, $2, $3)
+				RETURNING trip_update_id
+			)
+			INSERT INTO gtfs_rt.stop_time_updates (trip_update_id, stop_sequence, stop_id, departure_delay, schedule_relationship)
+			SELECT trip_update_id, 1, $4, $5, $6 FROM tu
+		`, feedMessageID, update.tripID, serviceDate.Format("2006-01-02"), testStopID, update.delay, update.relationship); err != nil {
+			t.Fatalf("inserting trip update for %s: %v", update.tripID, err)
+		}
+	}
+
+	return &fixture{
+		db:          database,
+		serviceDate: serviceDate,
+		first:       gtfstime.Resolve(serviceDate, firstSeconds, loc),
+	}
+}
+
+// fixtureFeed is a GTFS feed whose trips leave S1 firstSeconds into the
+// service day plus their offsets, and reach S2 five minutes later
+func fixtureFeed(serviceDate time.Time, firstSeconds int) fstest.MapFS {
+	var trips, stopTimes strings.Builder
+	trips.WriteString("route_id,service_id,trip_id,direction_id\n")
+	stopTimes.WriteString("trip_id,arrival_time,departure_time,stop_id,stop_sequence\n")
+	for _, trip := range fixtureTrips {
+		fmt.Fprintf(&trips, "%s,DAILY,%s,%d\n", trip.routeID, trip.tripID, trip.direction)
+
+		departure := firstSeconds + trip.minutes*60
+		for i, stopID := range []string{"S1", "S2"} {
+			at := gtfstime.FormatTime(departure + i*300)
+			fmt.Fprintf(&stopTimes, "%s,%s,%s,%s,%d\n", trip.tripID, at, at, stopID, i+1)
+		}
+	}
+
+	file := func(content string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(content)} }
+	return fstest.MapFS{
+		"agency.txt": file("agency_id,agency_name,agency_url,agency_timezone\n" +
+			"A,Test Transit,https://example.com,Australia/Melbourne\n"),
+		"stops.txt": file("stop_id,stop_name,stop_lat,stop_lon\n" +
+			"S1,First Stop,-37.8136,144.9631\n" +
+			"S2,Second Stop,-37.8183,144.9671\n"),
+		"routes.txt": file("route_id,agency_id,route_short_name,route_long_name,route_type\n" +
+			"R1,A,1,Route One,3\n" +
+			"R2,A,2,Route Two,3\n"),
+		"calendar.txt": file("service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+			fmt.Sprintf("DAILY,1,1,1,1,1,1,1,%s,%s\n",
+				serviceDate.AddDate(0, 0, -1).Format("20060102"), serviceDate.AddDate(0, 0, 1).Format("20060102"))),
+		"trips.txt":      file(trips.String()),
+		"stop_times.txt": file(stopTimes.String()),
+	}
+}
+
+func get(t *testing.T, ts *httptest.Server, path string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return resp.StatusCode, body
+}
+
+func getDepartures(t *testing.T, ts *httptest.Server, path string) DeparturesResponse {
+	t.Helper()
+	status, body := get(t, ts, path)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", path, status, body)
+	}
+	var resp DeparturesResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("decoding %s: %v", path, err)
+	}
+	return resp
+}
+
+func tripIDs(resp DeparturesResponse) []string {
+	ids := make([]string, len(resp.Departures))
+	for i, d := range resp.Departures {
+		ids[i] = d.TripID
+	}
+	return ids
+}
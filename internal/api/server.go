@@ -0,0 +1,98 @@
+// Package api serves the imported timetable and realtime data as a JSON
+// REST API.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/common/logger"
+)
+
+// Server is the REST API
+type Server struct {
+	db       *db.DB
+	logger   logger.Logger
+	server   *http.Server
+	location *time.Location
+}
+
+func NewServer(addr string, database *db.DB, log logger.Logger) *Server {
+	loc, err := time.LoadLocation("Australia/Melbourne")
+	if err != nil {
+		loc = time.UTC
+	}
+
+	s := &Server{
+		db:       database,
+		logger:   log,
+		location: loc,
+	}
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	return s
+}
+
+// Handler returns the API's routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/sources/{source_id}/stops/{stop_id}/departures", s.handleDepartures)
+	return mux
+}
+
+// Start serves until ctx is cancelled, then waits for requests in flight
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", s.server.Addr, err)
+	}
+	s.logger.Info("Serving REST API", "addr", listener.Addr().String())
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down API: %w", err)
+	}
+	if err := <-errChan; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// errorResponse is the body of every error response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.logger.Warn("Failed to write API response", "error", err)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	s.writeJSON(w, status, errorResponse{Error: fmt.Sprintf(format, args...)})
+}
@@ -3,28 +3,91 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ptvtracker-data/internal/gtfs-static/importer"
 )
 
 type Config struct {
 	Database     DatabaseConfig
 	GTFSStatic   GTFSStaticConfig
 	GTFSRealtime GTFSRealtimeConfig
+	API          APIConfig
 	Logging      LoggingConfig
+
+	// ExtraStaticDatasets are the datasets named in GTFS_STATIC_DATASETS,
+	// imported alongside GTFSStatic (the "default" dataset)
+	ExtraStaticDatasets []GTFSStaticConfig
+}
+
+// StaticDatasets returns the default dataset followed by any extra ones
+func (c *Config) StaticDatasets() []GTFSStaticConfig {
+	return append([]GTFSStaticConfig{c.GTFSStatic}, c.ExtraStaticDatasets...)
 }
 
 type DatabaseConfig struct {
 	ConnectionString string
 }
 
-// GTFS_STATIC_URL (required)
+// GTFS_STATIC_URL (required, CKAN resource URL, plain URL, file path or directory depending on the source type)
+// GTFS_STATIC_SOURCE_TYPE (optional, ckan|http|file|directory, default ckan)
 // GTFS_STATIC_CHECK_INTERVAL (optional, default 30m)
 // GTFS_STATIC_DOWNLOAD_DIR (optional, default /tmp/gtfs-static)
+// GTFS_STATIC_DOWNLOAD_MAX_ATTEMPTS (optional, tries per download with backoff and resume, default 5)
+// GTFS_STATIC_DOWNLOAD_IDLE_TIMEOUT (optional, abort an attempt after no data for this long, default 2m)
+// GTFS_STATIC_DOWNLOAD_MAX_SIZE_MB (optional, largest archive accepted, 0 disables, default 2048)
+// GTFS_STATIC_VALIDATION (optional, off|report|strict, default report)
+// GTFS_STATIC_VALIDATION_REPORT_DIR (optional, JSON reports are written here when set)
+// GTFS_STATIC_COPY_BATCH_SIZE (optional, rows per COPY statement, default 50000)
+// GTFS_STATIC_IMPORT_PARALLELISM (optional, sources imported at once, default 4)
+// GTFS_STATIC_MATERIALIZE_DAYS (optional, service days of absolute departure instants written at import, 0 disables, default 0)
+// GTFS_STATIC_STOP_CLUSTER_RADIUS (optional, metres within which stations and standalone stops are clustered, 0 disables, default 40)
+// GTFS_STATIC_SHAPE_TOLERANCES (optional, comma-separated metres at which shape geometries are stored, 0 = every point, "off" disables, default 0,5,20,100)
+// GTFS_STATIC_GATE_MAX_ROW_CHANGE_PCT (optional, max per-source row count change vs active version, 0 disables, default 50)
+// GTFS_STATIC_GATE_REQUIRED_SOURCES (optional, comma-separated source IDs, default every source in the active version)
+// GTFS_STATIC_GATE_MIN_CALENDAR_DAYS (optional, days ahead each source's calendar must cover, 0 disables, default 7)
+// GTFS_STATIC_GATE_MAX_VALIDATION_ERRORS (optional, max validation errors across sources, -1 disables, default -1)
+// GTFS_STATIC_NESTED_PATTERN (optional, regexp for nested source zips whose first group is the folder key, default ^(\d+)/google_transit\.zip$)
+// GTFS_STATIC_NESTED_SOURCES (optional, folder=source pairs mapping folder keys to a source ID or name, e.g. "12=13,vline=Regional Train")
+// GTFS_STATIC_INCLUDE_SOURCES (optional, comma-separated folder keys, default every folder)
+// GTFS_STATIC_EXCLUDE_SOURCES (optional, comma-separated folder keys to skip)
+//
+// GTFS_STATIC_DATASETS (optional, comma-separated names of extra datasets, e.g. vline)
+// Each extra dataset reads GTFS_STATIC_<NAME>_URL (required), _SOURCE_TYPE,
+// _CHECK_INTERVAL, _GATE_REQUIRED_SOURCES, _NESTED_PATTERN, _NESTED_SOURCES,
+// _INCLUDE_SOURCES and _EXCLUDE_SOURCES, and inherits the other settings
+// from the default dataset. Its downloads go in <download dir>/<name>.
 type GTFSStaticConfig struct {
-	URL           string
-	CheckInterval time.Duration
-	DownloadDir   string
+	Dataset             string
+	URL                 string
+	SourceType          string
+	CheckInterval       time.Duration
+	DownloadDir         string
+	DownloadMaxAttempts int
+	DownloadIdleTimeout time.Duration
+	DownloadMaxSizeMB   int
+	Validation          string
+	ValidationReportDir string
+	CopyBatchSize       int
+	ImportParallelism   int
+	MaterializeDays     int
+	ShapeTolerances     []float64
+	StopClusterRadius   float64
+
+	GateMaxRowChangePercent float64
+	GateRequiredSources     []int
+	GateMinCalendarDays     int
+	GateMaxValidationErrors int
+
+	NestedPattern  string
+	NestedSources  map[string]string
+	IncludeSources []string
+	ExcludeSources []string
 }
 
 type GTFSRealtimeConfig struct {
@@ -33,6 +96,10 @@ type GTFSRealtimeConfig struct {
 	RateLimitPerMin  int
 	CacheExpiration  time.Duration
 	Endpoints        []EndpointConfig
+
+	// PublishAddr is where the latest feeds are re-served over HTTP; empty
+	// disables the publisher
+	PublishAddr string
 }
 
 type EndpointConfig struct {
@@ -42,6 +109,12 @@ type EndpointConfig struct {
 	Source   string // "metrobus", "metrotrain", "tram"
 }
 
+// APIConfig configures the REST API. API_ADDR (optional, e.g. :8080, empty
+// disables the API)
+type APIConfig struct {
+	Addr string
+}
+
 type LoggingConfig struct {
 	Level      string
 	FilePath   string
@@ -50,18 +123,26 @@ type LoggingConfig struct {
 
 func Load() (*Config, error) {
 	cfg := &Config{
-		Database: DatabaseConfig{
-			ConnectionString: getEnvMultiple([]string{
-				"DATABASE_URL",
-				"POSTGRES_URL",
-				"POSTGRESQL_URL",
-				"PG_CONNECTION_STRING",
-			}, ""),
-		},
+		Database: databaseConfigFromEnv(),
 		GTFSStatic: GTFSStaticConfig{
-			URL:           getEnv("GTFS_STATIC_URL", ""),
-			CheckInterval: getDurationEnv("GTFS_STATIC_CHECK_INTERVAL", 30*time.Minute),
-			DownloadDir:   getEnv("GTFS_STATIC_DOWNLOAD_DIR", "/tmp/gtfs-static"),
+			Dataset:             "default",
+			URL:                 getEnv("GTFS_STATIC_URL", ""),
+			SourceType:          getEnv("GTFS_STATIC_SOURCE_TYPE", "ckan"),
+			CheckInterval:       getDurationEnv("GTFS_STATIC_CHECK_INTERVAL", 30*time.Minute),
+			DownloadDir:         getEnv("GTFS_STATIC_DOWNLOAD_DIR", "/tmp/gtfs-static"),
+			DownloadMaxAttempts: getIntEnv("GTFS_STATIC_DOWNLOAD_MAX_ATTEMPTS", 5),
+			DownloadIdleTimeout: getDurationEnv("GTFS_STATIC_DOWNLOAD_IDLE_TIMEOUT", 2*time.Minute),
+			DownloadMaxSizeMB:   getIntEnv("GTFS_STATIC_DOWNLOAD_MAX_SIZE_MB", 2048),
+			Validation:          getEnv("GTFS_STATIC_VALIDATION", "report"),
+			ValidationReportDir: getEnv("GTFS_STATIC_VALIDATION_REPORT_DIR", ""),
+			CopyBatchSize:       getIntEnv("GTFS_STATIC_COPY_BATCH_SIZE", 50000),
+			ImportParallelism:   getIntEnv("GTFS_STATIC_IMPORT_PARALLELISM", 4),
+			MaterializeDays:     getIntEnv("GTFS_STATIC_MATERIALIZE_DAYS", 0),
+			StopClusterRadius:   getFloatEnv("GTFS_STATIC_STOP_CLUSTER_RADIUS", 40),
+
+			GateMaxRowChangePercent: getFloatEnv("GTFS_STATIC_GATE_MAX_ROW_CHANGE_PCT", 50),
+			GateMinCalendarDays:     getIntEnv("GTFS_STATIC_GATE_MIN_CALENDAR_DAYS", 7),
+			GateMaxValidationErrors: getIntEnv("GTFS_STATIC_GATE_MAX_VALIDATION_ERRORS", -1),
 		},
 		GTFSRealtime: GTFSRealtimeConfig{
 			APIKey:          getEnv("GTFS_RT_API_KEY", ""),
@@ -69,6 +150,10 @@ func Load() (*Config, error) {
 			RateLimitPerMin: getIntEnv("GTFS_RT_RATE_LIMIT_PER_MIN", 25),
 			CacheExpiration: getDurationEnv("GTFS_RT_CACHE_EXPIRATION", 30*time.Second),
 			Endpoints:       getDefaultEndpoints(),
+			PublishAddr:     getEnv("GTFS_RT_PUBLISH_ADDR", ""),
+		},
+		API: APIConfig{
+			Addr: getEnv("API_ADDR", ""),
 		},
 		Logging: LoggingConfig{
 			Level:      getEnv("LOG_LEVEL", "info"),
@@ -81,9 +166,147 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("GTFS_STATIC_URL environment variable is required")
 	}
 
+	switch cfg.GTFSStatic.SourceType {
+	case "ckan", "http", "file", "directory":
+	default:
+		return nil, fmt.Errorf("GTFS_STATIC_SOURCE_TYPE must be ckan, http, file or directory, got %q", cfg.GTFSStatic.SourceType)
+	}
+
+	switch cfg.GTFSStatic.Validation {
+	case "off", "report", "strict":
+	default:
+		return nil, fmt.Errorf("GTFS_STATIC_VALIDATION must be off, report or strict, got %q", cfg.GTFSStatic.Validation)
+	}
+
+	requiredSources, err := getIntListEnv("GTFS_STATIC_GATE_REQUIRED_SOURCES")
+	if err != nil {
+		return nil, err
+	}
+	cfg.GTFSStatic.GateRequiredSources = requiredSources
+
+	shapeTolerances, err := getShapeTolerancesEnv("GTFS_STATIC_SHAPE_TOLERANCES")
+	if err != nil {
+		return nil, err
+	}
+	cfg.GTFSStatic.ShapeTolerances = shapeTolerances
+
+	if err := loadLayout(&cfg.GTFSStatic, "GTFS_STATIC_"); err != nil {
+		return nil, err
+	}
+
+	extra, err := extraStaticDatasets(cfg.GTFSStatic)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ExtraStaticDatasets = extra
+
 	return cfg, nil
 }
 
+var datasetNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// extraStaticDatasets builds the datasets listed in GTFS_STATIC_DATASETS from
+// their own variables, inheriting everything else from base
+func extraStaticDatasets(base GTFSStaticConfig) ([]GTFSStaticConfig, error) {
+	var datasets []GTFSStaticConfig
+	seen := map[string]bool{base.Dataset: true}
+
+	for _, name := range strings.Split(getEnv("GTFS_STATIC_DATASETS", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !datasetNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("GTFS_STATIC_DATASETS: invalid dataset name %q (lowercase letters, digits and underscores)", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("GTFS_STATIC_DATASETS: dataset %q listed twice", name)
+		}
+		seen[name] = true
+
+		prefix := "GTFS_STATIC_" + strings.ToUpper(name) + "_"
+		ds := base
+		ds.Dataset = name
+		ds.URL = getEnv(prefix+"URL", "")
+		ds.SourceType = getEnv(prefix+"SOURCE_TYPE", base.SourceType)
+		ds.CheckInterval = getDurationEnv(prefix+"CHECK_INTERVAL", base.CheckInterval)
+		ds.DownloadDir = filepath.Join(base.DownloadDir, name)
+
+		if ds.URL == "" {
+			return nil, fmt.Errorf("%sURL environment variable is required", prefix)
+		}
+		switch ds.SourceType {
+		case "ckan", "http", "file", "directory":
+		default:
+			return nil, fmt.Errorf("%sSOURCE_TYPE must be ckan, http, file or directory, got %q", prefix, ds.SourceType)
+		}
+
+		requiredSources, err := getIntListEnv(prefix + "GATE_REQUIRED_SOURCES")
+		if err != nil {
+			return nil, err
+		}
+		ds.GateRequiredSources = requiredSources
+
+		if err := loadLayout(&ds, prefix); err != nil {
+			return nil, err
+		}
+
+		datasets = append(datasets, ds)
+	}
+
+	return datasets, nil
+}
+
+// loadLayout reads the master archive layout variables under prefix, keeping
+// the values already in ds for any that are unset
+func loadLayout(ds *GTFSStaticConfig, prefix string) error {
+	ds.NestedPattern = getEnv(prefix+"NESTED_PATTERN", ds.NestedPattern)
+	if ds.NestedPattern != "" {
+		re, err := regexp.Compile(ds.NestedPattern)
+		if err != nil {
+			return fmt.Errorf("%sNESTED_PATTERN: %w", prefix, err)
+		}
+		if re.NumSubexp() < 1 {
+			return fmt.Errorf("%sNESTED_PATTERN needs a capture group for the folder key", prefix)
+		}
+	}
+
+	sources, err := getMapEnv(prefix + "NESTED_SOURCES")
+	if err != nil {
+		return err
+	}
+	if sources != nil {
+		ds.NestedSources = sources
+	}
+	if include := getListEnv(prefix + "INCLUDE_SOURCES"); include != nil {
+		ds.IncludeSources = include
+	}
+	if exclude := getListEnv(prefix + "EXCLUDE_SOURCES"); exclude != nil {
+		ds.ExcludeSources = exclude
+	}
+	return nil
+}
+
+// LoadDatabase reads only the database settings, for commands that don't run the scrapers
+func LoadDatabase() (*DatabaseConfig, error) {
+	cfg := databaseConfigFromEnv()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func databaseConfigFromEnv() DatabaseConfig {
+	return DatabaseConfig{
+		ConnectionString: getEnvMultiple([]string{
+			"DATABASE_URL",
+			"POSTGRES_URL",
+			"POSTGRESQL_URL",
+			"PG_CONNECTION_STRING",
+		}, ""),
+	}
+}
+
 // Validate checks if the database configuration is valid
 func (c *DatabaseConfig) Validate() error {
 	if c.ConnectionString == "" {
@@ -127,6 +350,92 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// getIntListEnv parses a comma-separated list of integers; unset means nil
+func getIntListEnv(key string) ([]int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	var values []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid integer %q", key, part)
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+// getShapeTolerancesEnv parses a comma-separated list of non-negative
+// tolerances in metres; "off" disables shape geometries
+func getShapeTolerancesEnv(key string) ([]float64, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	switch value {
+	case "":
+		return slices.Clone(importer.DefaultShapeTolerances), nil
+	case "off":
+		return nil, nil
+	}
+
+	var tolerances []float64
+	for _, part := range getListEnv(key) {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("%s: invalid tolerance %q", key, part)
+		}
+		if slices.Contains(tolerances, f) {
+			return nil, fmt.Errorf("%s: tolerance %q listed twice", key, part)
+		}
+		tolerances = append(tolerances, f)
+	}
+	return tolerances, nil
+}
+
+// getListEnv parses a comma-separated list of strings; unset means nil
+func getListEnv(key string) []string {
+	var values []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// getMapEnv parses comma-separated key=value pairs; unset means nil
+func getMapEnv(key string) (map[string]string, error) {
+	parts := getListEnv(key)
+	if parts == nil {
+		return nil, nil
+	}
+
+	values := make(map[string]string, len(parts))
+	for _, part := range parts {
+		k, v, ok := strings.Cut(part, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("%s: expected key=value, got %q", key, part)
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
 func getDefaultEndpoints() []EndpointConfig {
 	return []EndpointConfig{
 		{
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ArchiveChecksum returns the SHA-256 of the master archive a version was
+// imported from, or "" if it wasn't recorded
+func (vc *VersionChecker) ArchiveChecksum(ctx context.Context, versionID int) (string, error) {
+	var sum sql.NullString
+	err := vc.db.conn.QueryRowContext(ctx,
+		"SELECT archive_sha256 FROM gtfs.versions WHERE version_id = $1", versionID).Scan(&sum)
+	if err != nil {
+		return "", fmt.Errorf("querying archive checksum of version %d: %w", versionID, err)
+	}
+	return sum.String, nil
+}
+
+// SetArchiveChecksum records the SHA-256 of a version's master archive
+func (vc *VersionChecker) SetArchiveChecksum(ctx context.Context, versionID int, sum string) error {
+	_, err := vc.db.conn.ExecContext(ctx,
+		"UPDATE gtfs.versions SET archive_sha256 = $2 WHERE version_id = $1", versionID, sum)
+	if err != nil {
+		return fmt.Errorf("recording archive checksum of version %d: %w", versionID, err)
+	}
+	return nil
+}
+
+// RecordUnchangedRelease moves a version's release timestamp to a re-upload
+// of identical content, so the scheduler stops treating it as new
+func (vc *VersionChecker) RecordUnchangedRelease(ctx context.Context, versionID int, sourceURL string, lastModified time.Time) error {
+	_, err := vc.db.conn.ExecContext(ctx,
+		"UPDATE gtfs.versions SET updated_at = $2, source_url = $3 WHERE version_id = $1",
+		versionID, lastModified, sourceURL)
+	if err != nil {
+		return fmt.Errorf("recording unchanged release for version %d: %w", versionID, err)
+	}
+	return nil
+}
+
+// SourceChecksums returns the SHA-256 of each source zip loaded into a version
+func (vc *VersionChecker) SourceChecksums(ctx context.Context, versionID int) (map[int]string, error) {
+	rows, err := vc.db.conn.QueryContext(ctx,
+		"SELECT source_id, sha256 FROM gtfs.version_sources WHERE version_id = $1", versionID)
+	if err != nil {
+		return nil, fmt.Errorf("querying source checksums: %w", err)
+	}
+	defer rows.Close()
+
+	sums := make(map[int]string)
+	for rows.Next() {
+		var sourceID int
+		var sum string
+		if err := rows.Scan(&sourceID, &sum); err != nil {
+			return nil, fmt.Errorf("scanning source checksum: %w", err)
+		}
+		sums[sourceID] = sum
+	}
+
+	return sums, rows.Err()
+}
+
+// RecordSourceChecksum stores a source zip's SHA-256 inside the transaction
+// that loads it. copiedFrom is the version the rows came from, or 0.
+func (vc *VersionChecker) RecordSourceChecksum(ctx context.Context, tx *sql.Tx, versionID, sourceID int, sum string, copiedFrom int) error {
+	var from sql.NullInt64
+	if copiedFrom != 0 {
+		from = sql.NullInt64{Int64: int64(copiedFrom), Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO gtfs.version_sources (version_id, source_id, sha256, copied_from)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (version_id, source_id)
+		DO UPDATE SET sha256 = EXCLUDED.sha256, copied_from = EXCLUDED.copied_from, created_at = NOW()
+	`, versionID, sourceID, sum, from)
+	if err != nil {
+		return fmt.Errorf("recording checksum of source %d: %w", sourceID, err)
+	}
+	return nil
+}
+
+// CopySource copies a source's rows from one version to another inside tx.
+// Departure instants are left out because they depend on the import date.
+func (vc *VersionChecker) CopySource(ctx context.Context, tx *sql.Tx, fromVersionID, toVersionID, sourceID int) (int64, error) {
+	var tables []string
+	for _, table := range VersionedTables {
+		if table != "departure_instants" {
+			tables = append(tables, table)
+		}
+	}
+
+	var rows int64
+	err := tx.QueryRowContext(ctx, "SELECT gtfs.copy_version_source($1, $2, $3, $4)",
+		fromVersionID, toVersionID, sourceID, pq.Array(tables)).Scan(&rows)
+	if err != nil {
+		return 0, fmt.Errorf("copying source %d from version %d: %w", sourceID, fromVersionID, err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,328 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Import job statuses, mirrored by the CHECK constraints in 004_import_jobs.sql
+// and 006_activation_gates.sql
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobAbandoned = "abandoned"
+	JobRejected  = "rejected" // imported but failed the activation gates
+)
+
+// ImportJob is one attempt to load a dataset into an inactive version
+type ImportJob struct {
+	JobID        int
+	VersionID    int
+	SourceURL    string
+	LastModified time.Time
+	Status       string
+	StartedAt    time.Time
+}
+
+// ImportJobTracker records import progress so interrupted imports can resume
+type ImportJobTracker struct {
+	db *DB
+}
+
+func NewImportJobTracker(db *DB) *ImportJobTracker {
+	return &ImportJobTracker{db: db}
+}
+
+// FindResumableJob returns the latest unfinished job for the dataset whose
+// version still exists and is inactive, or nil if there is none
+func (t *ImportJobTracker) FindResumableJob(ctx context.Context, sourceURL string, lastModified time.Time) (*ImportJob, error) {
+	query := `
+		SELECT j.job_id, j.version_id, j.source_url, j.last_modified, j.status, j.started_at
+		FROM gtfs.import_jobs j
+		JOIN gtfs.versions v ON v.version_id = j.version_id
+		WHERE j.source_url = $1
+		  AND j.last_modified = $2
+		  AND j.status IN ('running', 'failed')
+		  AND v.is_active = false
+		ORDER BY j.started_at DESC
+		LIMIT 1
+	`
+
+	var job ImportJob
+	err := t.db.conn.QueryRowContext(ctx, query, sourceURL, lastModified).Scan(
+		&job.JobID,
+		&job.VersionID,
+		&job.SourceURL,
+		&job.LastModified,
+		&job.Status,
+		&job.StartedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying resumable import job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// CreateJob starts a new job for versionID and abandons unfinished or
+// rejected jobs for any other release of the same dataset, leaving their
+// versions to be garbage-collected
+func (t *ImportJobTracker) CreateJob(ctx context.Context, versionID int, sourceURL string, lastModified time.Time) (*ImportJob, error) {
+	tx, err := t.db.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	abandoned, err := tx.ExecContext(ctx, `
+		UPDATE gtfs.import_jobs
+		SET status = 'abandoned', updated_at = NOW(), finished_at = NOW(),
+		    error = COALESCE(error, 'superseded by a newer dataset')
+		WHERE status IN ('running', 'failed', 'rejected')
+		  AND NOT (source_url = $1 AND last_modified = $2)
+		  AND version_id IN (
+		      SELECT version_id FROM gtfs.versions
+		      WHERE dataset = (SELECT dataset FROM gtfs.versions WHERE version_id = $3))
+	`, sourceURL, lastModified, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("abandoning superseded jobs: %w", err)
+	}
+
+	job := &ImportJob{
+		VersionID:    versionID,
+		SourceURL:    sourceURL,
+		LastModified: lastModified,
+		Status:       JobRunning,
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO gtfs.import_jobs (version_id, source_url, last_modified)
+		VALUES ($1, $2, $3)
+		RETURNING job_id, started_at
+	`, versionID, sourceURL, lastModified).Scan(&job.JobID, &job.StartedAt)
+	if err != nil {
+		return nil, fmt.Errorf("creating import job: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+
+	if n, _ := abandoned.RowsAffected(); n > 0 {
+		t.db.logger.Info("Abandoned superseded import jobs", "count", n)
+	}
+	t.db.logger.Info("Created import job", "job_id", job.JobID, "version_id", versionID)
+
+	return job, nil
+}
+
+// IsRejected reports whether the dataset was already imported and rejected by
+// the activation gates
+func (t *ImportJobTracker) IsRejected(ctx context.Context, sourceURL string, lastModified time.Time) (bool, error) {
+	var rejected bool
+	err := t.db.conn.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM gtfs.import_jobs
+			WHERE source_url = $1 AND last_modified = $2 AND status = 'rejected')
+	`, sourceURL, lastModified).Scan(&rejected)
+	if err != nil {
+		return false, fmt.Errorf("checking rejected import jobs: %w", err)
+	}
+	return rejected, nil
+}
+
+// ResumeJob marks an existing job as running again
+func (t *ImportJobTracker) ResumeJob(ctx context.Context, jobID int) error {
+	_, err := t.db.conn.ExecContext(ctx, `
+		UPDATE gtfs.import_jobs
+		SET status = 'running', error = NULL, updated_at = NOW(), finished_at = NULL
+		WHERE job_id = $1
+	`, jobID)
+	if err != nil {
+		return fmt.Errorf("resuming import job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+// FinishJob records the final status of a job
+func (t *ImportJobTracker) FinishJob(ctx context.Context, jobID int, status string, jobErr error) error {
+	var errText sql.NullString
+	if jobErr != nil {
+		errText = sql.NullString{String: jobErr.Error(), Valid: true}
+	}
+
+	_, err := t.db.conn.ExecContext(ctx, `
+		UPDATE gtfs.import_jobs
+		SET status = $2, error = $3, updated_at = NOW(), finished_at = NOW()
+		WHERE job_id = $1
+	`, jobID, status, errText)
+	if err != nil {
+		return fmt.Errorf("finishing import job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+// CompletedSources returns the sources already loaded by a job
+func (t *ImportJobTracker) CompletedSources(ctx context.Context, jobID int) (map[int]bool, error) {
+	rows, err := t.db.conn.QueryContext(ctx, `
+		SELECT source_id FROM gtfs.import_job_sources
+		WHERE job_id = $1 AND status = 'completed'
+	`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("querying completed sources: %w", err)
+	}
+	defer rows.Close()
+
+	completed := make(map[int]bool)
+	for rows.Next() {
+		var sourceID int
+		if err := rows.Scan(&sourceID); err != nil {
+			return nil, fmt.Errorf("scanning source: %w", err)
+		}
+		completed[sourceID] = true
+	}
+
+	return completed, rows.Err()
+}
+
+// StartSource marks a source as running
+func (t *ImportJobTracker) StartSource(ctx context.Context, jobID, sourceID int) error {
+	_, err := t.db.conn.ExecContext(ctx, `
+		INSERT INTO gtfs.import_job_sources (job_id, source_id, status, started_at)
+		VALUES ($1, $2, 'running', NOW())
+		ON CONFLICT (job_id, source_id)
+		DO UPDATE SET status = 'running', error = NULL, started_at = NOW(), finished_at = NULL
+	`, jobID, sourceID)
+	if err != nil {
+		return fmt.Errorf("starting source %d: %w", sourceID, err)
+	}
+	return t.touch(ctx, jobID)
+}
+
+// FailSource records why a source failed
+func (t *ImportJobTracker) FailSource(ctx context.Context, jobID, sourceID int, sourceErr error) error {
+	_, err := t.db.conn.ExecContext(ctx, `
+		UPDATE gtfs.import_job_sources
+		SET status = 'failed', error = $3, finished_at = NOW()
+		WHERE job_id = $1 AND source_id = $2
+	`, jobID, sourceID, sourceErr.Error())
+	if err != nil {
+		return fmt.Errorf("failing source %d: %w", sourceID, err)
+	}
+	return t.touch(ctx, jobID)
+}
+
+// RecordValidation stores a source's validation issue counts
+func (t *ImportJobTracker) RecordValidation(ctx context.Context, jobID, sourceID, errorCount, warningCount int) error {
+	_, err := t.db.conn.ExecContext(ctx, `
+		UPDATE gtfs.import_job_sources
+		SET validation_errors = $3, validation_warnings = $4
+		WHERE job_id = $1 AND source_id = $2
+	`, jobID, sourceID, errorCount, warningCount)
+	if err != nil {
+		return fmt.Errorf("recording validation for source %d: %w", sourceID, err)
+	}
+	return nil
+}
+
+// ValidationErrors returns the total validation errors across a job's sources
+func (t *ImportJobTracker) ValidationErrors(ctx context.Context, jobID int) (int, error) {
+	var total int
+	err := t.db.conn.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(validation_errors), 0) FROM gtfs.import_job_sources WHERE job_id = $1
+	`, jobID).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("summing validation errors: %w", err)
+	}
+	return total, nil
+}
+
+// RecordFile stores the rows loaded from one file. It runs inside the import
+// transaction so it only becomes visible if the source commits.
+func (t *ImportJobTracker) RecordFile(ctx context.Context, tx *sql.Tx, jobID, sourceID int, fileName string, rowCount int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO gtfs.import_job_files (job_id, source_id, file_name, row_count)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (job_id, source_id, file_name)
+		DO UPDATE SET row_count = EXCLUDED.row_count, completed_at = NOW()
+	`, jobID, sourceID, fileName, rowCount)
+	if err != nil {
+		return fmt.Errorf("recording file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// CompleteSource checkpoints a source inside the import transaction, so the
+// data and the checkpoint commit together
+func (t *ImportJobTracker) CompleteSource(ctx context.Context, tx *sql.Tx, jobID, sourceID int) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE gtfs.import_job_sources
+		SET status = 'completed', error = NULL, finished_at = NOW()
+		WHERE job_id = $1 AND source_id = $2
+	`, jobID, sourceID)
+	if err != nil {
+		return fmt.Errorf("completing source %d: %w", sourceID, err)
+	}
+	return nil
+}
+
+// AbandonStaleJobs marks unfinished jobs untouched for longer than staleAfter as abandoned
+func (t *ImportJobTracker) AbandonStaleJobs(ctx context.Context, staleAfter time.Duration) (int64, error) {
+	result, err := t.db.conn.ExecContext(ctx, `
+		UPDATE gtfs.import_jobs
+		SET status = 'abandoned', updated_at = NOW(), finished_at = NOW(),
+		    error = COALESCE(error, 'no progress before the stale timeout')
+		WHERE status IN ('running', 'failed')
+		  AND updated_at < NOW() - make_interval(secs => $1)
+	`, staleAfter.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("abandoning stale jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// AbandonedVersions returns inactive, unpinned versions whose only jobs were
+// abandoned
+func (t *ImportJobTracker) AbandonedVersions(ctx context.Context) ([]int, error) {
+	rows, err := t.db.conn.QueryContext(ctx, `
+		SELECT v.version_id
+		FROM gtfs.versions v
+		WHERE v.is_active = false
+		  AND NOT v.is_pinned
+		  AND EXISTS (
+		      SELECT 1 FROM gtfs.import_jobs j
+		      WHERE j.version_id = v.version_id AND j.status = 'abandoned')
+		  AND NOT EXISTS (
+		      SELECT 1 FROM gtfs.import_jobs j
+		      WHERE j.version_id = v.version_id AND j.status <> 'abandoned')
+		ORDER BY v.version_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("querying abandoned versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var versionID int
+		if err := rows.Scan(&versionID); err != nil {
+			return nil, fmt.Errorf("scanning version: %w", err)
+		}
+		versions = append(versions, versionID)
+	}
+
+	return versions, rows.Err()
+}
+
+func (t *ImportJobTracker) touch(ctx context.Context, jobID int) error {
+	_, err := t.db.conn.ExecContext(ctx, "UPDATE gtfs.import_jobs SET updated_at = NOW() WHERE job_id = $1", jobID)
+	if err != nil {
+		return fmt.Errorf("updating import job %d: %w", jobID, err)
+	}
+	return nil
+}
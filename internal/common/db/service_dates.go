@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ActiveService is a service running on a given date
+type ActiveService struct {
+	SourceID  int
+	ServiceID string
+}
+
+// ServiceCalendar answers which services run on a date, using the
+// gtfs.service_dates expansion written at import time
+type ServiceCalendar struct {
+	db *DB
+}
+
+func NewServiceCalendar(db *DB) *ServiceCalendar {
+	return &ServiceCalendar{db: db}
+}
+
+// ActiveServices returns the services of versionID running on date, limited
+// to sourceID unless it is 0. Only the calendar date of date is used.
+func (sc *ServiceCalendar) ActiveServices(ctx context.Context, versionID, sourceID int, date time.Time) ([]ActiveService, error) {
+	rows, err := sc.db.conn.QueryContext(ctx, `
+		SELECT source_id, service_id
+		FROM gtfs.service_dates
+		WHERE version_id = $1 AND date = $2::date AND ($3 = 0 OR source_id = $3)
+		ORDER BY source_id, service_id
+	`, versionID, date.Format("2006-01-02"), sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("querying active services: %w", err)
+	}
+	defer rows.Close()
+
+	var services []ActiveService
+	for rows.Next() {
+		var s ActiveService
+		if err := rows.Scan(&s.SourceID, &s.ServiceID); err != nil {
+			return nil, fmt.Errorf("scanning active service: %w", err)
+		}
+		services = append(services, s)
+	}
+
+	return services, rows.Err()
+}
+
+// IsServiceActive reports whether a service of versionID runs on date
+func (sc *ServiceCalendar) IsServiceActive(ctx context.Context, versionID, sourceID int, serviceID string, date time.Time) (bool, error) {
+	var active bool
+	err := sc.db.conn.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM gtfs.service_dates
+			WHERE version_id = $1 AND source_id = $2 AND service_id = $3 AND date = $4::date)
+	`, versionID, sourceID, serviceID, date.Format("2006-01-02")).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("checking service %s: %w", serviceID, err)
+	}
+	return active, nil
+}
@@ -0,0 +1,24 @@
+package db
+
+// VersionedTables lists the gtfs tables keyed by version_id, ordered so
+// children come before the tables they reference (safe deletion order)
+var VersionedTables = []string{
+	"departure_instants", // Derived from stop_times and service_dates
+	"service_dates",      // Derived from calendar and calendar_dates
+	"shape_geometries",   // Derived from shapes
+	"stop_hierarchy",     // Derived from stops
+	"trip_patterns",      // Derived from trips and stop_times, references route_patterns
+	"pattern_stops",      // References route_patterns, stops
+	"route_patterns",     // Derived from trips and stop_times, references routes
+	"stop_times",         // References trips
+	"trips",              // References routes, calendar, shapes
+	"shapes",             // Independent
+	"calendar_dates",     // References calendar
+	"calendar",           // Independent
+	"transfers",          // References stops
+	"pathways",           // References levels, stops
+	"levels",             // Independent
+	"stops",              // Independent
+	"routes",             // References agency
+	"agency",             // Independent
+}
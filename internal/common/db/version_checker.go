@@ -9,35 +9,46 @@ import (
 	"github.com/ptvtracker-data/pkg/gtfs-static/models"
 )
 
+// DefaultDataset is the dataset versions belong to unless configured otherwise
+const DefaultDataset = "default"
+
+// VersionChecker manages the versions of one dataset
 type VersionChecker struct {
-	db *DB
+	db      *DB
+	dataset string
 }
 
 func NewVersionChecker(db *DB) *VersionChecker {
-	return &VersionChecker{db: db}
+	return NewDatasetVersionChecker(db, DefaultDataset)
+}
+
+func NewDatasetVersionChecker(db *DB, dataset string) *VersionChecker {
+	return &VersionChecker{db: db, dataset: dataset}
 }
 
 func (vc *VersionChecker) GetActiveVersion(ctx context.Context) (*models.VersionInfo, error) {
 	query := `
-		SELECT version_id, version_name, created_at, updated_at, is_active, source_url, description
+		SELECT version_id, version_name, dataset, created_at, updated_at, is_active, is_pinned, source_url, description
 		FROM gtfs.versions
-		WHERE is_active = true
+		WHERE is_active = true AND dataset = $1
 		LIMIT 1
 	`
 
 	var version models.VersionInfo
-	err := vc.db.conn.QueryRowContext(ctx, query).Scan(
+	err := vc.db.conn.QueryRowContext(ctx, query, vc.dataset).Scan(
 		&version.VersionID,
 		&version.VersionName,
+		&version.Dataset,
 		&version.CreatedAt,
 		&version.UpdatedAt,
 		&version.IsActive,
+		&version.IsPinned,
 		&version.SourceURL,
 		&version.Description,
 	)
 
 	if err == sql.ErrNoRows {
-		vc.db.logger.Info("No active version found in database")
+		vc.db.logger.Info("No active version found in database", "dataset", vc.dataset)
 		return nil, nil
 	}
 
@@ -53,7 +64,11 @@ func (vc *VersionChecker) GetActiveVersion(ctx context.Context) (*models.Version
 	return &version, nil
 }
 
-func (vc *VersionChecker) HasNewerVersion(ctx context.Context, lastModified time.Time) (bool, error) {
+// HasNewRelease reports whether lastModified differs from the release the
+// active version was imported from. Any difference counts, so a publisher
+// clock that moves backwards can't block updates; re-uploads of identical
+// content are caught by the archive checksum after downloading.
+func (vc *VersionChecker) HasNewRelease(ctx context.Context, lastModified time.Time) (bool, error) {
 	activeVersion, err := vc.GetActiveVersion(ctx)
 	if err != nil {
 		return false, fmt.Errorf("getting active version: %w", err)
@@ -66,14 +81,14 @@ func (vc *VersionChecker) HasNewerVersion(ctx context.Context, lastModified time
 	}
 
 	// Compare timestamps
-	isNewer := lastModified.After(activeVersion.UpdatedAt)
+	isNew := !lastModified.Equal(activeVersion.UpdatedAt)
 
 	vc.db.logger.Info("Version comparison",
 		"dataset_modified", lastModified,
 		"active_version_updated", activeVersion.UpdatedAt,
-		"is_newer", isNewer)
+		"is_new", isNew)
 
-	return isNewer, nil
+	return isNew, nil
 }
 
 func (vc *VersionChecker) CreateNewVersion(ctx context.Context, versionName string, sourceURL string, lastModified time.Time) (int, error) {
@@ -91,13 +106,13 @@ func (vc *VersionChecker) CreateNewVersion(ctx context.Context, versionName stri
 	// Create new version
 	var versionID int
 	query := `
-		INSERT INTO gtfs.versions (version_name, source_url, updated_at, is_active, description)
-		VALUES ($1, $2, $3, false, $4)
+		INSERT INTO gtfs.versions (version_name, source_url, updated_at, is_active, description, dataset)
+		VALUES ($1, $2, $3, false, $4, $5)
 		RETURNING version_id
 	`
 
 	description := fmt.Sprintf("GTFS data imported from %s at %s", sourceURL, lastModified.Format(time.RFC3339))
-	err = tx.QueryRowContext(ctx, query, versionName, sourceURL, lastModified, description).Scan(&versionID)
+	err = tx.QueryRowContext(ctx, query, versionName, sourceURL, lastModified, description, vc.dataset).Scan(&versionID)
 	if err != nil {
 		return 0, fmt.Errorf("creating version: %w", err)
 	}
@@ -108,26 +123,75 @@ func (vc *VersionChecker) CreateNewVersion(ctx context.Context, versionName stri
 
 	vc.db.logger.Info("Created new version",
 		"version_id", versionID,
-		"version_name", versionName)
+		"version_name", versionName,
+		"dataset", vc.dataset)
+
+	return versionID, nil
+}
+
+// CreateThrowawayVersion creates an inactive version for a selective import
+// that can never be activated; cleanup deletes it a day after it is created
+func (vc *VersionChecker) CreateThrowawayVersion(ctx context.Context, versionName string, description string) (int, error) {
+	var versionID int
+	err := vc.db.conn.QueryRowContext(ctx, `
+		INSERT INTO gtfs.versions (version_name, is_active, is_throwaway, description, dataset)
+		VALUES ($1, false, true, $2, $3)
+		RETURNING version_id
+	`, versionName, description, vc.dataset).Scan(&versionID)
+	if err != nil {
+		return 0, fmt.Errorf("creating throwaway version: %w", err)
+	}
+
+	vc.db.logger.Info("Created throwaway version",
+		"version_id", versionID,
+		"version_name", versionName,
+		"dataset", vc.dataset)
 
 	return versionID, nil
 }
 
+// MarkPartial records that a version holds only some sources or files of its
+// dataset, so it can never be activated
+func (vc *VersionChecker) MarkPartial(ctx context.Context, versionID int) error {
+	if _, err := vc.db.conn.ExecContext(ctx,
+		"UPDATE gtfs.versions SET is_partial = true WHERE version_id = $1", versionID); err != nil {
+		return fmt.Errorf("marking version %d partial: %w", versionID, err)
+	}
+	return nil
+}
+
 func (vc *VersionChecker) ActivateVersion(ctx context.Context, versionID int) error {
+	return vc.activate(ctx, versionID, false)
+}
+
+// ActivatePinned activates a version by hand and pins it, so the scheduler
+// doesn't replace it with the latest release on its next check
+func (vc *VersionChecker) ActivatePinned(ctx context.Context, versionID int) error {
+	return vc.activate(ctx, versionID, true)
+}
+
+func (vc *VersionChecker) activate(ctx context.Context, versionID int, pin bool) error {
 	tx, err := vc.db.BeginTx(ctx)
 	if err != nil {
 		return fmt.Errorf("beginning transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Deactivate all versions
-	_, err = tx.ExecContext(ctx, "UPDATE gtfs.versions SET is_active = false WHERE is_active = true")
+	// Deactivate the active version of the same dataset; other datasets are untouched
+	_, err = tx.ExecContext(ctx, `
+		UPDATE gtfs.versions SET is_active = false
+		WHERE is_active = true
+		  AND dataset = (SELECT dataset FROM gtfs.versions WHERE version_id = $1)
+	`, versionID)
 	if err != nil {
 		return fmt.Errorf("deactivating versions: %w", err)
 	}
 
-	// Activate the specified version
-	result, err := tx.ExecContext(ctx, "UPDATE gtfs.versions SET is_active = true WHERE version_id = $1", versionID)
+	// Activate the specified version; throwaway and partial versions never are
+	result, err := tx.ExecContext(ctx, `
+		UPDATE gtfs.versions SET is_active = true, activated_at = NOW(), is_pinned = is_pinned OR $2
+		WHERE version_id = $1 AND is_throwaway = false AND is_partial = false
+	`, versionID, pin)
 	if err != nil {
 		return fmt.Errorf("activating version: %w", err)
 	}
@@ -138,13 +202,178 @@ func (vc *VersionChecker) ActivateVersion(ctx context.Context, versionID int) er
 	}
 
 	if rows == 0 {
-		return fmt.Errorf("version %d not found", versionID)
+		return fmt.Errorf("version %d not found or is a throwaway or partial version", versionID)
 	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("committing transaction: %w", err)
 	}
 
-	vc.db.logger.Info("Activated version", "version_id", versionID)
+	vc.db.logger.Info("Activated version", "version_id", versionID, "pinned", pin)
 	return nil
 }
+
+// ListVersions returns the versions of every dataset with record counts and
+// estimated sizes, grouped by dataset and newest first
+func (vc *VersionChecker) ListVersions(ctx context.Context) ([]models.VersionSummary, error) {
+	query := `
+		SELECT version_id, version_name, dataset, created_at, is_active, is_pinned, activated_at,
+		       stop_times_count, trips_count, stops_count, estimated_size, age_days
+		FROM gtfs.list_versions_with_sizes()
+	`
+
+	rows, err := vc.db.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("listing versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []models.VersionSummary
+	for rows.Next() {
+		var v models.VersionSummary
+		var activatedAt sql.NullTime
+		if err := rows.Scan(
+			&v.VersionID,
+			&v.VersionName,
+			&v.Dataset,
+			&v.CreatedAt,
+			&v.IsActive,
+			&v.IsPinned,
+			&activatedAt,
+			&v.StopTimesCount,
+			&v.TripsCount,
+			&v.StopsCount,
+			&v.EstimatedSize,
+			&v.AgeDays,
+		); err != nil {
+			return nil, fmt.Errorf("scanning version: %w", err)
+		}
+		if activatedAt.Valid {
+			v.ActivatedAt = &activatedAt.Time
+		}
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// CheckActivatable returns an error if versionID doesn't exist or its import
+// hasn't finished, so a half-loaded version can't be made active by hand
+func (vc *VersionChecker) CheckActivatable(ctx context.Context, versionID int) error {
+	var exists bool
+	if err := vc.db.conn.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM gtfs.versions WHERE version_id = $1)", versionID).Scan(&exists); err != nil {
+		return fmt.Errorf("checking version: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("version %d not found", versionID)
+	}
+
+	var status sql.NullString
+	err := vc.db.conn.QueryRowContext(ctx, `
+		SELECT status FROM gtfs.import_jobs
+		WHERE version_id = $1
+		ORDER BY started_at DESC
+		LIMIT 1
+	`, versionID).Scan(&status)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("checking import status: %w", err)
+	}
+	if status.Valid && status.String != JobCompleted {
+		return fmt.Errorf("version %d import is %s, not completed", versionID, status.String)
+	}
+
+	return nil
+}
+
+// Rollback reactivates and pins the dataset's most recently active version
+// other than the current one and returns its ID. The pin stops the scheduler
+// from importing the rolled-back release again.
+func (vc *VersionChecker) Rollback(ctx context.Context) (int, error) {
+	var versionID int
+	err := vc.db.conn.QueryRowContext(ctx, `
+		SELECT version_id FROM gtfs.versions
+		WHERE is_active = false AND activated_at IS NOT NULL AND dataset = $1
+		ORDER BY activated_at DESC
+		LIMIT 1
+	`, vc.dataset).Scan(&versionID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no previously active version of dataset %q to roll back to", vc.dataset)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("finding previous version: %w", err)
+	}
+
+	if err := vc.ActivatePinned(ctx, versionID); err != nil {
+		return 0, err
+	}
+
+	vc.db.logger.Info("Rolled back to previous version", "version_id", versionID)
+	return versionID, nil
+}
+
+// SetPinned pins or unpins a version
+func (vc *VersionChecker) SetPinned(ctx context.Context, versionID int, pinned bool) error {
+	result, err := vc.db.conn.ExecContext(ctx,
+		"UPDATE gtfs.versions SET is_pinned = $2 WHERE version_id = $1", versionID, pinned)
+	if err != nil {
+		return fmt.Errorf("updating pin: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("version %d not found", versionID)
+	}
+
+	vc.db.logger.Info("Updated version pin", "version_id", versionID, "pinned", pinned)
+	return nil
+}
+
+// DatasetSources returns the transport sources registered to the dataset
+func (vc *VersionChecker) DatasetSources(ctx context.Context) (map[int]bool, error) {
+	rows, err := vc.db.conn.QueryContext(ctx,
+		"SELECT source_id FROM gtfs.transport_sources WHERE dataset = $1", vc.dataset)
+	if err != nil {
+		return nil, fmt.Errorf("querying sources of dataset %s: %w", vc.dataset, err)
+	}
+	defer rows.Close()
+
+	sources := make(map[int]bool)
+	for rows.Next() {
+		var sourceID int
+		if err := rows.Scan(&sourceID); err != nil {
+			return nil, fmt.Errorf("scanning source: %w", err)
+		}
+		sources[sourceID] = true
+	}
+
+	return sources, rows.Err()
+}
+
+// TransportSources returns the ID of every registered transport source by name
+func (vc *VersionChecker) TransportSources(ctx context.Context) (map[string]int, error) {
+	rows, err := vc.db.conn.QueryContext(ctx, "SELECT source_id, source_name FROM gtfs.transport_sources")
+	if err != nil {
+		return nil, fmt.Errorf("querying transport sources: %w", err)
+	}
+	defer rows.Close()
+
+	sources := make(map[string]int)
+	for rows.Next() {
+		var sourceID int
+		var name string
+		if err := rows.Scan(&sourceID, &name); err != nil {
+			return nil, fmt.Errorf("scanning transport source: %w", err)
+		}
+		sources[name] = sourceID
+	}
+
+	return sources, rows.Err()
+}
@@ -70,6 +70,11 @@ func ConsoleWriter() io.Writer {
 	return zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
 }
 
+// StderrWriter returns a console writer on stderr, keeping stdout free for command output
+func StderrWriter() io.Writer {
+	return zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
+}
+
 // FileWriter returns a file writer with rotation
 func FileWriter(path string) io.Writer {
 	return &lumberjack.Logger{
@@ -3,6 +3,7 @@ package maintenance
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/ptvtracker-data/internal/common/db"
 	"github.com/ptvtracker-data/internal/common/logger"
@@ -55,13 +56,22 @@ func New(database *db.DB, logger logger.Logger) *Maintenance {
 func (m *Maintenance) CleanupOldGTFSVersions(ctx context.Context, keepInactiveVersions int) ([]VersionCleanupResult, error) {
 	m.logger.Info("Starting simple GTFS version cleanup", "keep_inactive_versions", keepInactiveVersions)
 
-	// Get versions to delete (inactive versions beyond keep limit)
+	// Get versions to delete (inactive versions beyond keep limit, per dataset,
+	// and throwaway versions more than a day old)
 	query := `
 		SELECT version_id, version_name, created_at
-		FROM gtfs.versions 
-		WHERE is_active = false
-		ORDER BY created_at DESC
-		OFFSET $1`
+		FROM (
+		    SELECT version_id, version_name, created_at, is_throwaway,
+		           ROW_NUMBER() OVER (PARTITION BY dataset, is_throwaway ORDER BY created_at DESC) AS rank
+		    FROM gtfs.versions
+		    WHERE is_active = false
+		      AND is_pinned = false
+		      AND version_id NOT IN (
+		          SELECT version_id FROM gtfs.import_jobs WHERE status IN ('running', 'failed', 'rejected'))
+		) ranked
+		WHERE (NOT is_throwaway AND rank > $1)
+		   OR (is_throwaway AND created_at < NOW() - INTERVAL '1 day')
+		ORDER BY created_at DESC`
 	
 	rows, err := m.db.DB().QueryContext(ctx, query, keepInactiveVersions)
 	if err != nil {
@@ -152,19 +162,7 @@ func (m *Maintenance) deleteGTFSVersion(ctx context.Context, versionID int) (int
 	totalDeleted := int64(0)
 	
 	// Delete in dependency order to avoid foreign key conflicts
-	tables := []string{
-		"stop_times",     // References trips
-		"trips",          // References routes, calendar, shapes  
-		"shapes",         // Independent
-		"calendar_dates", // References calendar
-		"calendar",       // Independent
-		"transfers",      // References stops
-		"pathways",       // References levels, stops
-		"levels",         // Independent
-		"stops",          // Independent
-		"routes",         // References agency
-		"agency",         // Independent
-	}
+	tables := db.VersionedTables
 
 	for _, table := range tables {
 		query := fmt.Sprintf("DELETE FROM gtfs.%s WHERE version_id = $1", table)
@@ -194,6 +192,42 @@ func (m *Maintenance) deleteGTFSVersion(ctx context.Context, versionID int) (int
 	return totalDeleted, nil
 }
 
+// CleanupAbandonedImports deletes inactive versions whose imports will never
+// finish: jobs superseded by a newer dataset, and jobs with no progress for
+// staleAfter (typically a crash). Returns the number of versions deleted.
+func (m *Maintenance) CleanupAbandonedImports(ctx context.Context, staleAfter time.Duration) (int, error) {
+	tracker := db.NewImportJobTracker(m.db)
+
+	stale, err := tracker.AbandonStaleJobs(ctx, staleAfter)
+	if err != nil {
+		return 0, err
+	}
+	if stale > 0 {
+		m.logger.Warn("Marked stale import jobs as abandoned", "count", stale, "stale_after", staleAfter)
+	}
+
+	versions, err := tracker.AbandonedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, versionID := range versions {
+		m.logger.Info("Deleting version from abandoned import", "version_id", versionID)
+
+		records, err := m.deleteGTFSVersion(ctx, versionID)
+		if err != nil {
+			m.logger.Error("Failed to delete abandoned version", "version_id", versionID, "error", err)
+			continue
+		}
+
+		deleted++
+		m.logger.Info("Deleted abandoned version", "version_id", versionID, "records_deleted", records)
+	}
+
+	return deleted, nil
+}
+
 // BatchedCleanupResult represents the result of a batched cleanup operation
 type BatchedCleanupResult struct {
 	TableName      string
@@ -519,6 +553,11 @@ func (m *Maintenance) PerformPostImportMaintenance(ctx context.Context) error {
 		return fmt.Errorf("refreshing materialized views: %w", err)
 	}
 
+	// Remove versions left behind by crashed or superseded imports
+	if _, err := m.CleanupAbandonedImports(ctx, DefaultSchedulerConfig().AbandonedImportTimeout); err != nil {
+		return fmt.Errorf("cleaning up abandoned imports: %w", err)
+	}
+
 	// Cleanup old versions (keep 1 inactive version as backup)
 	_, err := m.CleanupOldGTFSVersions(ctx, 1)
 	if err != nil {
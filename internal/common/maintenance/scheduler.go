@@ -19,8 +19,8 @@ type CleanupScheduler struct {
 	isRunning           bool
 	mu                  sync.RWMutex
 	cancelFn            context.CancelFunc
-	importLock          sync.RWMutex // Prevents cleanup during GTFS imports
-	isImportInProgress  bool
+	importLock          sync.RWMutex // Guards importsInProgress
+	importsInProgress   int          // Imports running now; cleanup waits for none
 }
 
 // SchedulerConfig contains configuration for the cleanup scheduler
@@ -31,6 +31,7 @@ type SchedulerConfig struct {
 	KeepInactiveVersions    int           // Number of inactive GTFS versions to keep
 	BatchSize               int           // Records per batch for batched cleanup (default: 10000)
 	UseBatchedCleanup       bool          // Whether to use batched cleanup (default: true)
+	AbandonedImportTimeout  time.Duration // Unfinished imports idle this long are garbage-collected
 }
 
 // DefaultSchedulerConfig returns sensible defaults
@@ -42,6 +43,7 @@ func DefaultSchedulerConfig() SchedulerConfig {
 		KeepInactiveVersions:    1,              // Keep 1 inactive version as backup
 		BatchSize:               1,              // Not used for truncate
 		UseBatchedCleanup:       true,           // Use simple truncate cleanup
+		AbandonedImportTimeout:  7 * 24 * time.Hour,
 	}
 }
 
@@ -109,25 +111,33 @@ func (s *CleanupScheduler) IsRunning() bool {
 	return s.isRunning
 }
 
-// LockForImport prevents cleanup operations during GTFS imports
+// LockForImport prevents cleanup operations during a GTFS import. Imports
+// of different datasets may hold it at the same time.
 func (s *CleanupScheduler) LockForImport() {
 	s.importLock.Lock()
-	s.isImportInProgress = true
-	s.logger.Info("Cleanup operations locked for GTFS import")
+	s.importsInProgress++
+	imports := s.importsInProgress
+	s.importLock.Unlock()
+	s.logger.Info("Cleanup operations locked for GTFS import", "imports_in_progress", imports)
 }
 
-// UnlockAfterImport allows cleanup operations to resume after GTFS import
+// UnlockAfterImport allows cleanup operations to resume once every GTFS
+// import has finished
 func (s *CleanupScheduler) UnlockAfterImport() {
-	s.isImportInProgress = false
+	s.importLock.Lock()
+	if s.importsInProgress > 0 {
+		s.importsInProgress--
+	}
+	imports := s.importsInProgress
 	s.importLock.Unlock()
-	s.logger.Info("Cleanup operations unlocked after GTFS import")
+	s.logger.Info("Cleanup operations unlocked after GTFS import", "imports_in_progress", imports)
 }
 
 // canPerformCleanup checks if cleanup operations are allowed
 func (s *CleanupScheduler) canPerformCleanup() bool {
 	s.importLock.RLock()
 	defer s.importLock.RUnlock()
-	return !s.isImportInProgress
+	return s.importsInProgress == 0
 }
 
 // realtimeCleanupLoop runs periodic real-time data cleanup
@@ -217,6 +227,12 @@ func (s *CleanupScheduler) performStaticCleanup(ctx context.Context) {
 		"keep_inactive_versions", s.config.KeepInactiveVersions)
 	
 	start := time.Now()
+	if deleted, err := s.maintenance.CleanupAbandonedImports(ctx, s.config.AbandonedImportTimeout); err != nil {
+		s.logger.Error("Abandoned import cleanup failed", "error", err)
+	} else if deleted > 0 {
+		s.logger.Info("Removed versions from abandoned imports", "versions_deleted", deleted)
+	}
+
 	results, err := s.maintenance.CleanupOldGTFSVersions(ctx, s.config.KeepInactiveVersions)
 	duration := time.Since(start)
 
@@ -271,7 +287,8 @@ func (s *CleanupScheduler) GetStatus() map[string]interface{} {
 
 	return map[string]interface{}{
 		"is_running":               s.isRunning,
-		"is_import_in_progress":    s.isImportInProgress,
+		"is_import_in_progress":    s.importsInProgress > 0,
+		"imports_in_progress":      s.importsInProgress,
 		"realtime_interval":        s.config.RealtimeCleanupInterval.String(),
 		"static_interval":          s.config.StaticCleanupInterval.String(),
 		"realtime_retention_days":  s.config.RealtimeRetentionDays,
@@ -296,4 +296,14 @@ func (fc *feedCache) set(key string, entry *cacheEntry) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
 	fc.data[key] = entry
-}
\ No newline at end of file
+}
+
+// Latest returns the most recent feed fetched from the named endpoint and
+// when it was fetched, or nil before the first successful fetch
+func (c *Consumer) Latest(name string) (*gtfs_proto.FeedMessage, time.Time) {
+	entry := c.cache.get(name)
+	if entry == nil {
+		return nil, time.Time{}
+	}
+	return entry.feedMessage, entry.timestamp
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ptvtracker-data/internal/common/config"
 	"github.com/ptvtracker-data/internal/common/db"
 	"github.com/ptvtracker-data/internal/common/logger"
 	"github.com/ptvtracker-data/internal/gtfs-realtime/consumer"
 	"github.com/ptvtracker-data/internal/gtfs-realtime/processor"
+	"github.com/ptvtracker-data/internal/gtfs-realtime/publisher"
 )
 
 type Manager struct {
@@ -17,6 +19,7 @@ type Manager struct {
 	logger    logger.Logger
 	consumer  *consumer.Consumer
 	processor *processor.Processor
+	publisher *publisher.Server
 	db        *db.DB
 	mu        sync.RWMutex
 	isRunning bool
@@ -24,13 +27,17 @@ type Manager struct {
 }
 
 func NewManager(cfg config.GTFSRealtimeConfig, database *db.DB, log logger.Logger) *Manager {
-	return &Manager{
+	m := &Manager{
 		config:    cfg,
 		logger:    log,
 		db:        database,
 		consumer:  consumer.NewConsumer(cfg, log),
 		processor: processor.NewProcessor(database, log),
 	}
+	if cfg.PublishAddr != "" {
+		m.publisher = publisher.NewServer(cfg.PublishAddr, cfg.Endpoints, m.consumer, log)
+	}
+	return m
 }
 
 func (m *Manager) Start(ctx context.Context) error {
@@ -62,6 +69,15 @@ func (m *Manager) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to start processor: %w", err)
 	}
 
+	// Start publisher
+	if m.publisher != nil {
+		if err := m.publisher.Start(); err != nil {
+			cancel()
+			m.consumer.Stop()
+			return fmt.Errorf("failed to start publisher: %w", err)
+		}
+	}
+
 	m.isRunning = true
 	m.logger.Info("GTFS-realtime manager started successfully")
 
@@ -86,6 +102,15 @@ func (m *Manager) Stop() {
 	// Stop consumer
 	m.consumer.Stop()
 
+	// Stop publisher
+	if m.publisher != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := m.publisher.Shutdown(ctx); err != nil {
+			m.logger.Warn("Failed to stop publisher", "error", err)
+		}
+		cancel()
+	}
+
 	m.isRunning = false
 	m.logger.Info("GTFS-realtime manager stopped")
 }
@@ -22,9 +22,14 @@ type Processor struct {
 	logger         logger.Logger
 	maintenance    *maintenance.Maintenance
 	sourceMapping  map[string]int // maps source name to source_id
-	versionMapping map[string]int // maps version info to version_id
+	versionMapping map[int]int    // maps source_id to its dataset's active version_id
+	versionsLoaded time.Time      // when versionMapping was last refreshed
 }
 
+// versionRefreshInterval bounds how long the processor keeps using a version
+// after another one is activated for its dataset
+const versionRefreshInterval = time.Minute
+
 type ProcessorStats struct {
 	ProcessedMessages int64
 	ProcessedEntities int64
@@ -43,7 +48,7 @@ func NewProcessor(dbWrapper *db.DB, log logger.Logger) *Processor {
 		logger:         log,
 		maintenance:    maintenance.New(dbWrapper, log),
 		sourceMapping:  make(map[string]int),
-		versionMapping: make(map[string]int),
+		versionMapping: make(map[int]int),
 	}
 }
 
@@ -130,7 +135,7 @@ func (p *Processor) processFeedMessage(result *consumer.FeedResult) error {
 		return fmt.Errorf("unknown source: %s", result.Endpoint.Source)
 	}
 
-	versionID, err := p.getOrCreateVersion(result.Message.Header)
+	versionID, err := p.getOrCreateVersion(result.Message.Header, sourceID)
 	if err != nil {
 		return fmt.Errorf("failed to get version: %w", err)
 	}
@@ -212,44 +217,58 @@ func (p *Processor) processFeedMessage(result *consumer.FeedResult) error {
 	return nil
 }
 
-func (p *Processor) getOrCreateVersion(header *gtfs_proto.FeedHeader) (int, error) {
-	// For GTFS-realtime, we always use the currently active GTFS-static version
-	// since realtime data relates to the static schedule
-	
-	cacheKey := "active_version"
-	
-	// Check if we have the active version cached
-	if versionID, exists := p.versionMapping[cacheKey]; exists {
-		return versionID, nil
+func (p *Processor) getOrCreateVersion(header *gtfs_proto.FeedHeader, sourceID int) (int, error) {
+	// For GTFS-realtime, we always use the active GTFS-static version of the
+	// dataset the source belongs to, since realtime data relates to that schedule
+	if time.Since(p.versionsLoaded) > versionRefreshInterval {
+		if err := p.refreshVersionMappings(); err != nil {
+			return 0, err
+		}
 	}
 
-	// Query database for the active version
-	var versionID int
-	err := p.db.QueryRow(`
-		SELECT version_id 
-		FROM gtfs.versions 
-		WHERE is_active = TRUE
-		LIMIT 1
-	`).Scan(&versionID)
-
-	if err == sql.ErrNoRows {
+	versionID, exists := p.versionMapping[sourceID]
+	if !exists {
 		// No active version found, this shouldn't happen in normal operation
-		return 0, fmt.Errorf("no active GTFS version found")
-	} else if err != nil {
-		return 0, fmt.Errorf("failed to query active version: %w", err)
+		return 0, fmt.Errorf("no active GTFS version found for source %d", sourceID)
 	}
 
-	// Cache the active version
-	p.versionMapping[cacheKey] = versionID
-	
 	// Log the feed version if provided (for debugging)
 	if header.FeedVersion != nil && *header.FeedVersion != "" {
 		p.logger.Debug("GTFS-RT feed version", "feed_version", *header.FeedVersion, "using_version_id", versionID)
 	}
-	
+
 	return versionID, nil
 }
 
+// refreshVersionMappings reloads the active version of every source's dataset
+func (p *Processor) refreshVersionMappings() error {
+	rows, err := p.db.Query(`
+		SELECT ts.source_id, v.version_id
+		FROM gtfs.transport_sources ts
+		JOIN gtfs.versions v ON v.dataset = ts.dataset AND v.is_active = TRUE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query active versions: %w", err)
+	}
+	defer rows.Close()
+
+	mapping := make(map[int]int)
+	for rows.Next() {
+		var sourceID, versionID int
+		if err := rows.Scan(&sourceID, &versionID); err != nil {
+			return fmt.Errorf("failed to scan active version: %w", err)
+		}
+		mapping[sourceID] = versionID
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read active versions: %w", err)
+	}
+
+	p.versionMapping = mapping
+	p.versionsLoaded = time.Now()
+	return nil
+}
+
 func (p *Processor) insertFeedMessage(tx *sql.Tx, header *gtfs_proto.FeedHeader, sourceID, versionID int, feedType string) (int, error) {
 	var feedMessageID int
 	var timestamp time.Time
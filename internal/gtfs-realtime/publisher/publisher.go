@@ -0,0 +1,278 @@
+// Package publisher re-serves the realtime feeds the consumer polls, so
+// internal apps get GTFS-Realtime without their own upstream API key.
+//
+// Feeds are served per feed type, merged across modes:
+//
+//	GET /gtfs-rt/trip_updates
+//
+// or for one mode, named after its endpoint without the feed type suffix:
+//
+//	GET /gtfs-rt/trip_updates/tram
+//
+// Responses are FeedMessage protobufs, or JSON with ?format=json or an
+// Accept header asking for application/json. Each response has a strong
+// ETag, and If-None-Match gets a 304 while the feed is unchanged.
+package publisher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/config"
+	"github.com/ptvtracker-data/internal/common/logger"
+	gtfs_proto "github.com/ptvtracker-data/pkg/gtfs-realtime/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// FeedSource returns the latest feed fetched from an endpoint; the consumer
+// implements it
+type FeedSource interface {
+	Latest(name string) (*gtfs_proto.FeedMessage, time.Time)
+}
+
+// Server serves the latest feeds over HTTP
+type Server struct {
+	endpoints []config.EndpointConfig
+	source    FeedSource
+	logger    logger.Logger
+	server    *http.Server
+
+	mu    sync.Mutex
+	cache map[string]*response
+}
+
+// response is an encoded feed, reused until one of its endpoints is fetched
+// again
+type response struct {
+	stamp string
+	body  []byte
+	etag  string
+}
+
+func NewServer(addr string, endpoints []config.EndpointConfig, source FeedSource, log logger.Logger) *Server {
+	s := &Server{
+		endpoints: endpoints,
+		source:    source,
+		logger:    log,
+		cache:     make(map[string]*response),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /gtfs-rt/{feed}", s.handleFeed)
+	mux.HandleFunc("GET /gtfs-rt/{feed}/{mode}", s.handleFeed)
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	return s
+}
+
+// Start listens on the configured address and serves in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", s.server.Addr, err)
+	}
+
+	s.logger.Info("Publishing GTFS-realtime feeds", "addr", listener.Addr().String())
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("GTFS-realtime publisher stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for those in flight
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	feedType, mode := r.PathValue("feed"), r.PathValue("mode")
+
+	endpoints := s.match(feedType, mode)
+	if len(endpoints) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	format := contentTypeProtobuf
+	switch r.URL.Query().Get("format") {
+	case "json":
+		format = contentTypeJSON
+	case "pb", "protobuf":
+	case "":
+		if strings.Contains(r.Header.Get("Accept"), contentTypeJSON) {
+			format = contentTypeJSON
+		}
+	default:
+		http.Error(w, "format must be json or pb", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.response(endpoints, feedType, mode, format)
+	if err != nil {
+		s.logger.Error("Failed to encode feed", "feed", feedType, "mode", mode, "error", err)
+		http.Error(w, "failed to encode feed", http.StatusInternalServerError)
+		return
+	}
+	if resp == nil {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "feed not fetched yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("ETag", resp.etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Vary", "Accept")
+	if etagMatches(r.Header.Get("If-None-Match"), resp.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.body)))
+	w.Write(resp.body)
+}
+
+// match returns the endpoints of a feed type, or of one mode of it
+func (s *Server) match(feedType, mode string) []config.EndpointConfig {
+	var matched []config.EndpointConfig
+	for _, endpoint := range s.endpoints {
+		if endpoint.FeedType != feedType {
+			continue
+		}
+		if mode != "" && endpointMode(endpoint) != mode {
+			continue
+		}
+		matched = append(matched, endpoint)
+	}
+	return matched
+}
+
+// response returns the encoded feed of endpoints, building it only when an
+// endpoint has been fetched since the cached one. Nil means no endpoint has
+// been fetched yet.
+func (s *Server) response(endpoints []config.EndpointConfig, feedType, mode, format string) (*response, error) {
+	var stamp strings.Builder
+	var names []string
+	var messages []*gtfs_proto.FeedMessage
+	for _, endpoint := range endpoints {
+		msg, fetched := s.source.Latest(endpoint.Name)
+		if msg == nil {
+			continue
+		}
+		fmt.Fprintf(&stamp, "%s@%d;", endpoint.Name, fetched.UnixNano())
+		names = append(names, endpointMode(endpoint))
+		messages = append(messages, msg)
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	key := feedType + "/" + mode + "/" + format
+	s.mu.Lock()
+	cached := s.cache[key]
+	s.mu.Unlock()
+	if cached != nil && cached.stamp == stamp.String() {
+		return cached, nil
+	}
+
+	feed := messages[0]
+	if mode == "" {
+		feed = merge(names, messages)
+	}
+
+	var body []byte
+	var err error
+	if format == contentTypeJSON {
+		body, err = protojson.MarshalOptions{UseProtoNames: true}.Marshal(feed)
+	} else {
+		body, err = proto.Marshal(feed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	resp := &response{
+		stamp: stamp.String(),
+		body:  body,
+		etag:  `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}
+
+	s.mu.Lock()
+	s.cache[key] = resp
+	s.mu.Unlock()
+	return resp, nil
+}
+
+// merge combines the feeds of several modes into one full dataset. Entity
+// IDs are only unique within a feed, so each is prefixed with its mode.
+func merge(modes []string, messages []*gtfs_proto.FeedMessage) *gtfs_proto.FeedMessage {
+	var timestamp uint64
+	var count int
+	for _, msg := range messages {
+		timestamp = max(timestamp, msg.GetHeader().GetTimestamp())
+		count += len(msg.Entity)
+	}
+
+	merged := &gtfs_proto.FeedMessage{
+		Header: &gtfs_proto.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Incrementality:      gtfs_proto.FeedHeader_FULL_DATASET.Enum(),
+			Timestamp:           proto.Uint64(timestamp),
+		},
+		Entity: make([]*gtfs_proto.FeedEntity, 0, count),
+	}
+	for i, msg := range messages {
+		for _, entity := range msg.Entity {
+			// Clone rather than rename in place: the processor reads the
+			// same messages
+			clone := proto.Clone(entity).(*gtfs_proto.FeedEntity)
+			clone.Id = proto.String(modes[i] + ":" + entity.GetId())
+			merged.Entity = append(merged.Entity, clone)
+		}
+	}
+	return merged
+}
+
+// endpointMode names an endpoint's mode, e.g. "tram" for
+// "tram_trip_updates"
+func endpointMode(endpoint config.EndpointConfig) string {
+	return strings.TrimSuffix(endpoint.Name, "_"+endpoint.FeedType)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 requires for If-None-Match
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+// Package archive opens zip files defensively. GTFS archives come from
+// outside, so entry counts, sizes, compression ratios and names are checked
+// before anything is extracted, and reads are capped at the sizes the archive
+// declares so a lying header can't turn into a zip bomb.
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// ErrLimitExceeded is returned when an archive or entry is too large
+	ErrLimitExceeded = errors.New("archive limit exceeded")
+	// ErrInvalidPath is returned for entry names that are absolute, escape
+	// the archive root or contain unusual characters
+	ErrInvalidPath = errors.New("invalid path in archive")
+)
+
+// ratioMinSize is the smallest entry the compression ratio is checked for;
+// tiny files legitimately compress at odd ratios
+const ratioMinSize = 1 << 20
+
+// Limits bounds what an archive may contain. Zero disables a limit.
+type Limits struct {
+	MaxEntries          int
+	MaxEntrySize        int64   // uncompressed bytes in one entry
+	MaxTotalSize        int64   // uncompressed bytes across all entries
+	MaxCompressionRatio float64 // uncompressed / compressed size of one entry
+}
+
+// DefaultLimits returns limits comfortably above the PTV master archive,
+// whose largest entry (a stop_times.txt) is around a gigabyte
+func DefaultLimits() Limits {
+	return Limits{
+		MaxEntries:          10000,
+		MaxEntrySize:        4 << 30, // 4 GiB
+		MaxTotalSize:        8 << 30, // 8 GiB
+		MaxCompressionRatio: 200,
+	}
+}
+
+// Reader is a zip archive that passed Check
+type Reader struct {
+	*zip.Reader
+	limits Limits
+	src    io.ReaderAt // the archive itself, for reading stored entries in place
+	closer io.Closer
+}
+
+// Open opens and checks the zip file at path
+func Open(path string, limits Limits) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening zip file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("opening zip file: %w", err)
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("opening zip file: %w", err)
+	}
+
+	if err := Check(zr, limits); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &Reader{Reader: zr, limits: limits, src: f, closer: f}, nil
+}
+
+// NewReader checks a zip archive read from r
+func NewReader(r io.ReaderAt, size int64, limits Limits) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("reading zip: %w", err)
+	}
+
+	if err := Check(zr, limits); err != nil {
+		return nil, err
+	}
+
+	return &Reader{Reader: zr, limits: limits, src: r}, nil
+}
+
+// Close closes the underlying file, if Open opened one
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// OpenFile opens an entry of the archive, capped at its declared size and the limits
+func (r *Reader) OpenFile(file *zip.File) (io.ReadCloser, error) {
+	return OpenFile(file, r.limits)
+}
+
+// Open implements fs.FS like zip.Reader, applying the limits to every file read
+func (r *Reader) Open(name string) (fs.File, error) {
+	f, err := r.Reader.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range r.File {
+		if file.Name == name {
+			return &limitedFile{File: f, r: &limitedReader{rc: f, file: file, limits: r.limits}}, nil
+		}
+	}
+	return f, nil // a directory, which has no data to limit
+}
+
+// Check validates an archive's entry count, declared sizes and names
+func Check(zr *zip.Reader, limits Limits) error {
+	if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+		return fmt.Errorf("%w: %d entries, at most %d allowed", ErrLimitExceeded, len(zr.File), limits.MaxEntries)
+	}
+
+	var total uint64
+	for _, file := range zr.File {
+		if err := ValidPath(file.Name); err != nil {
+			return err
+		}
+		if err := checkSize(file, file.UncompressedSize64, limits); err != nil {
+			return err
+		}
+
+		total += file.UncompressedSize64
+		if limits.MaxTotalSize > 0 && total > uint64(limits.MaxTotalSize) {
+			return fmt.Errorf("%w: entries total more than %d bytes", ErrLimitExceeded, limits.MaxTotalSize)
+		}
+	}
+
+	return nil
+}
+
+// ValidPath rejects entry names that could write outside an extraction
+// directory or are likely to confuse tools: absolute paths, ".." elements,
+// backslashes, drive letters, and control or invalid UTF-8 characters
+func ValidPath(name string) error {
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("%w: %q is not valid UTF-8", ErrInvalidPath, name)
+	}
+	if strings.ContainsRune(name, '\\') || strings.Contains(name, ":") {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: %q contains control characters", ErrInvalidPath, name)
+		}
+	}
+
+	// Directory entries end in a slash, which fs.ValidPath doesn't allow
+	if !fs.ValidPath(strings.TrimSuffix(name, "/")) {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+	return nil
+}
+
+// OpenFile opens a zip entry, failing the read once it passes the entry's
+// declared size or the limits
+func OpenFile(file *zip.File, limits Limits) (io.ReadCloser, error) {
+	if err := ValidPath(file.Name); err != nil {
+		return nil, err
+	}
+	if err := checkSize(file, file.UncompressedSize64, limits); err != nil {
+		return nil, err
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{rc: rc, file: file, limits: limits}, nil
+}
+
+func checkSize(file *zip.File, size uint64, limits Limits) error {
+	if limits.MaxEntrySize > 0 && size > uint64(limits.MaxEntrySize) {
+		return fmt.Errorf("%w: %s is over %d bytes", ErrLimitExceeded, file.Name, limits.MaxEntrySize)
+	}
+	if limits.MaxCompressionRatio > 0 && size >= ratioMinSize {
+		compressed := max(file.CompressedSize64, 1)
+		if ratio := float64(size) / float64(compressed); ratio > limits.MaxCompressionRatio {
+			return fmt.Errorf("%w: %s compresses %.0f:1, at most %.0f:1 allowed",
+				ErrLimitExceeded, file.Name, ratio, limits.MaxCompressionRatio)
+		}
+	}
+	return nil
+}
+
+// limitedReader counts the bytes actually decompressed, since the sizes in
+// the archive's headers are not to be trusted
+type limitedReader struct {
+	rc     io.ReadCloser
+	file   *zip.File
+	limits Limits
+	n      uint64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.n += uint64(n)
+
+	if r.n > r.file.UncompressedSize64 {
+		return n, fmt.Errorf("%w: %s is larger than its declared %d bytes",
+			ErrLimitExceeded, r.file.Name, r.file.UncompressedSize64)
+	}
+	if lerr := checkSize(r.file, r.n, r.limits); lerr != nil {
+		return n, lerr
+	}
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	return r.rc.Close()
+}
+
+// limitedFile is an fs.File whose reads go through a limitedReader
+type limitedFile struct {
+	fs.File
+	r *limitedReader
+}
+
+func (f *limitedFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
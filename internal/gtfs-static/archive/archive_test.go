@@ -0,0 +1,319 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry is a file to write into a test archive. A declared size other than
+// zero is written to the header in place of the real one, like a forged
+// archive would.
+type entry struct {
+	name     string
+	data     []byte
+	declared uint64
+}
+
+func buildZip(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		if strings.HasSuffix(e.name, "/") {
+			if _, err := zw.Create(e.name); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		var compressed bytes.Buffer
+		fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(e.data)
+		fw.Close()
+
+		size := uint64(len(e.data))
+		if e.declared != 0 {
+			size = e.declared
+		}
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               e.name,
+			Method:             zip.Deflate,
+			CRC32:              crc32.ChecksumIEEE(e.data),
+			CompressedSize64:   uint64(compressed.Len()),
+			UncompressedSize64: size,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(compressed.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func files(n int) []entry {
+	entries := make([]entry, n)
+	for i := range entries {
+		entries[i] = entry{name: string(rune('a'+i)) + ".txt", data: []byte("x")}
+	}
+	return entries
+}
+
+func TestNewReader(t *testing.T) {
+	zeros := make([]byte, 2<<20) // compresses far beyond 200:1
+	text := []byte(strings.Repeat("stop_id,stop_name\n", 10))
+	limits := Limits{MaxEntries: 3, MaxEntrySize: 4 << 20, MaxTotalSize: 5 << 20, MaxCompressionRatio: 200}
+
+	tests := []struct {
+		name    string
+		entries []entry
+		limits  Limits
+		wantErr error
+	}{
+		{"small feed", []entry{{name: "gtfs/"}, {name: "gtfs/stops.txt", data: text}}, limits, nil},
+		{"as many entries as allowed", files(3), limits, nil},
+		{"too many entries", files(4), limits, ErrLimitExceeded},
+		{"high compression ratio", []entry{{name: "stop_times.txt", data: zeros}}, limits, ErrLimitExceeded},
+		{"ratio not limited", []entry{{name: "stop_times.txt", data: zeros}}, Limits{}, nil},
+		{"declared entry too large", []entry{{name: "stops.txt", data: text, declared: 8 << 20}}, limits, ErrLimitExceeded},
+		{"declared total too large", []entry{
+			{name: "a.txt", data: text, declared: 3 << 20},
+			{name: "b.txt", data: text, declared: 3 << 20},
+		}, Limits{MaxTotalSize: 5 << 20}, ErrLimitExceeded},
+		{"parent directory", []entry{{name: "../stops.txt", data: text}}, limits, ErrInvalidPath},
+		{"nested parent directory", []entry{{name: "gtfs/../../stops.txt", data: text}}, limits, ErrInvalidPath},
+		{"absolute path", []entry{{name: "/etc/stops.txt", data: text}}, limits, ErrInvalidPath},
+		{"drive letter", []entry{{name: "C:/stops.txt", data: text}}, limits, ErrInvalidPath},
+		{"backslash", []entry{{name: `..\stops.txt`, data: text}}, limits, ErrInvalidPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildZip(t, tt.entries...)
+			_, err := NewReader(bytes.NewReader(data), int64(len(data)), tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewReader() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	text := []byte(strings.Repeat("trip_id,stop_id,stop_sequence\n", 100))
+
+	tests := []struct {
+		name   string
+		entry  entry
+		limits Limits
+		ok     bool
+	}{
+		{"declared size", entry{name: "stop_times.txt", data: text}, DefaultLimits(), true},
+		// The header passes every check, so the lie only shows once more
+		// bytes come out than it declares
+		{"larger than declared", entry{name: "stop_times.txt", data: text, declared: 10}, DefaultLimits(), false},
+		{"over the entry limit", entry{name: "stop_times.txt", data: text}, Limits{MaxEntrySize: 100}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildZip(t, tt.entry)
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rc, err := OpenFile(zr.File[0], tt.limits)
+			if err == nil {
+				var got []byte
+				got, err = io.ReadAll(rc)
+				rc.Close()
+				if err == nil && !bytes.Equal(got, text) {
+					t.Errorf("read %d bytes, want %d", len(got), len(text))
+				}
+			}
+			if ok := err == nil; ok != tt.ok {
+				t.Errorf("reading entry: error = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestOpenFileInvalidPath(t *testing.T) {
+	data := buildZip(t, entry{name: "../stops.txt", data: []byte("stop_id\n")})
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(zr.File[0], Limits{}); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("OpenFile() error = %v, want %v", err, ErrInvalidPath)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	text := []byte(strings.Repeat("route_id,route_short_name\n", 100))
+	dir := t.TempDir()
+
+	write := func(name string, entries ...entry) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, buildZip(t, entries...), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	r, err := Open(write("ok.zip", entry{name: "routes.txt", data: text}), DefaultLimits())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer r.Close()
+
+	// Reads through fs.FS are limited like OpenFile
+	got, err := readFile(t, r, "routes.txt")
+	if err != nil || !bytes.Equal(got, text) {
+		t.Errorf("reading routes.txt: %d bytes, error %v", len(got), err)
+	}
+
+	lying, err := Open(write("lying.zip", entry{name: "routes.txt", data: text, declared: 10}), DefaultLimits())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer lying.Close()
+	if _, err := readFile(t, lying, "routes.txt"); err == nil {
+		t.Error("reading an entry larger than declared succeeded")
+	}
+
+	if _, err := Open(write("bad.zip", entry{name: "../routes.txt", data: text}), DefaultLimits()); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Open() error = %v, want %v", err, ErrInvalidPath)
+	}
+}
+
+// readFile reads a file through the archive's fs.FS, failing the test if it
+// can't be opened
+func readFile(t *testing.T, r *Reader, name string) ([]byte, error) {
+	t.Helper()
+	f, err := r.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func TestValidPath(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"stops.txt", true},
+		{"gtfs/stops.txt", true},
+		{"gtfs/", true},
+		{"", false},
+		{"./stops.txt", false},
+		{"..", false},
+		{"../stops.txt", false},
+		{"gtfs/../../stops.txt", false},
+		{"/stops.txt", false},
+		{"/etc/passwd", false},
+		{"gtfs//stops.txt", false},
+		{"C:/stops.txt", false},
+		{`gtfs\stops.txt`, false},
+		{"stops\x00.txt", false},
+		{"stops\n.txt", false},
+		{"stops\xff.txt", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidPath(tt.name)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("ValidPath(%q) = %v, want valid %v", tt.name, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("ValidPath(%q) = %v, want %v", tt.name, err, ErrInvalidPath)
+		}
+	}
+}
+
+func TestOpenNested(t *testing.T) {
+	inner := buildZip(t, entry{name: "stops.txt", data: []byte(strings.Repeat("stop_id,stop_name\n", 50))})
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("%d/google_transit.zip", method), Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(inner)
+	}
+	// A stored entry whose CRC doesn't match its data
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "corrupt/google_transit.zip",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(inner) + 1,
+		CompressedSize64:   uint64(len(inner)),
+		UncompressedSize64: uint64(len(inner)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(inner)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	master, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), DefaultLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for i, file := range master.File[:2] {
+		t.Run(file.Name, func(t *testing.T) {
+			tempPath := filepath.Join(dir, fmt.Sprintf("source_%d.zip", i))
+			var seen bytes.Buffer
+			nested, err := master.OpenNested(file, tempPath, &seen)
+			if err != nil {
+				t.Fatalf("OpenNested() error = %v", err)
+			}
+			defer nested.Close()
+
+			if !bytes.Equal(seen.Bytes(), inner) {
+				t.Errorf("wrote %d bytes to w, want the %d of the nested zip", seen.Len(), len(inner))
+			}
+			got := make([]byte, nested.Size)
+			if _, err := nested.ReadAt(got, 0); err != nil || !bytes.Equal(got, inner) {
+				t.Errorf("reading nested zip: %v", err)
+			}
+			if _, err := NewReader(nested, nested.Size, DefaultLimits()); err != nil {
+				t.Errorf("opening nested zip: %v", err)
+			}
+
+			// Only a compressed entry needs a temp file
+			_, statErr := os.Stat(tempPath)
+			if extracted := statErr == nil; extracted != (file.Method == zip.Deflate) {
+				t.Errorf("temp file written = %v for method %d", extracted, file.Method)
+			}
+		})
+	}
+
+	if _, err := master.OpenNested(master.File[2], filepath.Join(dir, "corrupt.zip"), nil); err == nil {
+		t.Error("OpenNested() succeeded for an entry with a bad CRC")
+	}
+}
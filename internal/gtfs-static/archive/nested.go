@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+)
+
+// Nested is a zip stored as an entry of another archive, readable with
+// NewReader or anything else that takes an io.ReaderAt
+type Nested struct {
+	io.ReaderAt
+	Size int64
+	temp *os.File // the file a compressed entry was inflated to
+}
+
+// Close closes the temp file, if OpenNested needed one. The caller removes it.
+func (n *Nested) Close() error {
+	if n.temp == nil {
+		return nil
+	}
+	return n.temp.Close()
+}
+
+// OpenNested makes the zip in entry file readable. One stored uncompressed,
+// as PTV packages its per-source feeds, is read in place from the archive
+// once a pass through OpenFile has checked its CRC and the limits. A
+// compressed one is inflated to tempPath. Either way the entry's bytes are
+// also written to w unless it is nil, e.g. to hash them.
+func (r *Reader) OpenNested(file *zip.File, tempPath string, w io.Writer) (*Nested, error) {
+	nested := &Nested{Size: int64(file.UncompressedSize64)}
+	if w == nil {
+		w = io.Discard
+	}
+
+	rc, err := r.OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	if file.Method == zip.Store && file.CompressedSize64 == file.UncompressedSize64 {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(w, rc); err != nil {
+			return nil, err
+		}
+		nested.ReaderAt = io.NewSectionReader(r.src, offset, nested.Size)
+		return nested, nil
+	}
+
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.MultiWriter(f, w), rc); err != nil {
+		f.Close()
+		return nil, err
+	}
+	nested.ReaderAt, nested.temp = f, f
+	return nested, nil
+}
@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/common/logger"
+)
+
+// Options controls the scope of a diff
+type Options struct {
+	// SourceID limits the diff to one transport source; 0 compares all
+	SourceID int
+	// MaxChanges caps the entities listed per kind and change type; 0 lists
+	// them all. Counts are always exact.
+	MaxChanges int
+	// ServiceDate is the first day compared for service-level changes
+	ServiceDate time.Time
+	// ServiceDays is the number of days compared from ServiceDate
+	ServiceDays int
+}
+
+// DefaultOptions returns sensible defaults: all sources, a week of service from today
+func DefaultOptions() Options {
+	return Options{
+		MaxChanges:  1000,
+		ServiceDate: time.Now(),
+		ServiceDays: 7,
+	}
+}
+
+// keep reports whether a list already holding n changes takes another
+func (o Options) keep(n int) bool {
+	return o.MaxChanges <= 0 || n < o.MaxChanges
+}
+
+// FieldChange is one column that differs between versions
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// EntityChange identifies a stop, route, trip or shape that was added,
+// removed or changed
+type EntityChange struct {
+	SourceID int           `json:"source_id"`
+	ID       string        `json:"id"`
+	Fields   []FieldChange `json:"fields,omitempty"`
+}
+
+// EntityDiff lists the changes for one kind of entity
+type EntityDiff struct {
+	AddedCount   int            `json:"added_count"`
+	RemovedCount int            `json:"removed_count"`
+	ChangedCount int            `json:"changed_count"`
+	Added        []EntityChange `json:"added"`
+	Removed      []EntityChange `json:"removed"`
+	Changed      []EntityChange `json:"changed"`
+}
+
+// Result is the full comparison of two versions
+type Result struct {
+	FromVersion int                    `json:"from_version"`
+	ToVersion   int                    `json:"to_version"`
+	SourceID    int                    `json:"source_id,omitempty"`
+	Entities    map[string]*EntityDiff `json:"entities"`
+	Service     []ServiceChange        `json:"service"`
+}
+
+// Differ compares two GTFS versions stored in the database
+type Differ struct {
+	db     *db.DB
+	logger logger.Logger
+}
+
+func New(database *db.DB, logger logger.Logger) *Differ {
+	return &Differ{db: database, logger: logger}
+}
+
+// Diff compares fromVersion with toVersion
+func (d *Differ) Diff(ctx context.Context, fromVersion, toVersion int, opts Options) (*Result, error) {
+	result := &Result{
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		SourceID:    opts.SourceID,
+		Entities:    make(map[string]*EntityDiff),
+	}
+
+	for _, spec := range entitySpecs {
+		start := time.Now()
+		entityDiff, err := d.diffEntities(ctx, spec, fromVersion, toVersion, opts)
+		if err != nil {
+			return nil, fmt.Errorf("comparing %s: %w", spec.name, err)
+		}
+		result.Entities[spec.name] = entityDiff
+
+		d.logger.Info("Compared entities",
+			"kind", spec.name,
+			"added", entityDiff.AddedCount,
+			"removed", entityDiff.RemovedCount,
+			"changed", entityDiff.ChangedCount,
+			"duration", time.Since(start))
+	}
+
+	service, err := d.diffService(ctx, fromVersion, toVersion, opts)
+	if err != nil {
+		return nil, fmt.Errorf("comparing service levels: %w", err)
+	}
+	result.Service = service
+
+	return result, nil
+}
@@ -0,0 +1,149 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// entitySpec describes how one kind of entity is compared. query returns a
+// subquery yielding id, source_id and then the compared columns as text for
+// the version bound to the given placeholder.
+type entitySpec struct {
+	name    string
+	columns []string
+	opaque  map[string]bool // columns holding digests rather than readable values
+	query   func(versionParam string) string
+}
+
+var entitySpecs = []entitySpec{
+	{
+		name:    "stops",
+		columns: []string{"stop_name", "stop_lat", "stop_lon", "location_type", "parent_station", "wheelchair_boarding", "level_id"},
+		query: func(v string) string {
+			return `SELECT stop_id AS id, source_id, stop_name, stop_lat::text AS stop_lat, stop_lon::text AS stop_lon,
+				location_type::text AS location_type, parent_station, wheelchair_boarding::text AS wheelchair_boarding, level_id
+				FROM gtfs.stops WHERE version_id = ` + v + ` AND ($3 = 0 OR source_id = $3)`
+		},
+	},
+	{
+		name:    "routes",
+		columns: []string{"agency_id", "route_short_name", "route_long_name", "route_type", "route_color", "route_text_color"},
+		query: func(v string) string {
+			return `SELECT route_id AS id, source_id, agency_id, route_short_name, route_long_name,
+				route_type::text AS route_type, route_color, route_text_color
+				FROM gtfs.routes WHERE version_id = ` + v + ` AND ($3 = 0 OR source_id = $3)`
+		},
+	},
+	{
+		// Trips also compare a digest of their stop_times, so retimed or
+		// rerouted trips show up even when the trip row itself is unchanged
+		name:    "trips",
+		columns: []string{"route_id", "service_id", "shape_id", "trip_headsign", "direction_id", "stop_count", "stop_times"},
+		opaque:  map[string]bool{"stop_times": true},
+		query: func(v string) string {
+			return `SELECT t.trip_id AS id, t.source_id, t.route_id, t.service_id, t.shape_id, t.trip_headsign,
+				t.direction_id::text AS direction_id, st.stop_count, st.stop_times
+				FROM gtfs.trips t
+				LEFT JOIN (
+					SELECT trip_id, source_id, COUNT(*)::text AS stop_count,
+						md5(string_agg(stop_id || '@' || COALESCE(arrival_time_seconds::text, '') || '/' ||
+							COALESCE(departure_time_seconds::text, ''), ';' ORDER BY stop_sequence)) AS stop_times
+					FROM gtfs.stop_times
+					WHERE version_id = ` + v + ` AND ($3 = 0 OR source_id = $3)
+					GROUP BY trip_id, source_id
+				) st ON st.trip_id = t.trip_id AND st.source_id = t.source_id
+				WHERE t.version_id = ` + v + ` AND ($3 = 0 OR t.source_id = $3)`
+		},
+	},
+	{
+		name:    "shapes",
+		columns: []string{"points", "geometry"},
+		opaque:  map[string]bool{"geometry": true},
+		query: func(v string) string {
+			return `SELECT shape_id AS id, source_id, COUNT(*)::text AS points,
+				md5(string_agg(shape_pt_lat::text || ',' || shape_pt_lon::text, ';' ORDER BY shape_pt_sequence)) AS geometry
+				FROM gtfs.shapes WHERE version_id = ` + v + ` AND ($3 = 0 OR source_id = $3)
+				GROUP BY shape_id, source_id`
+		},
+	},
+}
+
+func (d *Differ) diffEntities(ctx context.Context, spec entitySpec, fromVersion, toVersion int, opts Options) (*EntityDiff, error) {
+	var selectCols, aCols, bCols []string
+	for _, col := range spec.columns {
+		selectCols = append(selectCols, "a."+col, "b."+col)
+		aCols = append(aCols, "a."+col)
+		bCols = append(bCols, "b."+col)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT COALESCE(a.source_id, b.source_id), COALESCE(a.id, b.id), a.id IS NULL, b.id IS NULL, %s
+		FROM (%s) a
+		FULL OUTER JOIN (%s) b ON a.id = b.id AND a.source_id = b.source_id
+		WHERE a.id IS NULL OR b.id IS NULL OR ROW(%s) IS DISTINCT FROM ROW(%s)
+		ORDER BY 1, 2`,
+		strings.Join(selectCols, ", "),
+		spec.query("$1"),
+		spec.query("$2"),
+		strings.Join(aCols, ", "),
+		strings.Join(bCols, ", "))
+
+	rows, err := d.db.DB().QueryContext(ctx, query, fromVersion, toVersion, opts.SourceID)
+	if err != nil {
+		return nil, fmt.Errorf("querying differences: %w", err)
+	}
+	defer rows.Close()
+
+	result := &EntityDiff{
+		Added:   []EntityChange{},
+		Removed: []EntityChange{},
+		Changed: []EntityChange{},
+	}
+
+	values := make([]sql.NullString, len(spec.columns)*2)
+	for rows.Next() {
+		var change EntityChange
+		var added, removed bool
+		dest := []interface{}{&change.SourceID, &change.ID, &added, &removed}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scanning difference: %w", err)
+		}
+
+		switch {
+		case added:
+			result.AddedCount++
+			if opts.keep(len(result.Added)) {
+				result.Added = append(result.Added, change)
+			}
+		case removed:
+			result.RemovedCount++
+			if opts.keep(len(result.Removed)) {
+				result.Removed = append(result.Removed, change)
+			}
+		default:
+			result.ChangedCount++
+			if !opts.keep(len(result.Changed)) {
+				continue
+			}
+			for i, col := range spec.columns {
+				from, to := values[i*2], values[i*2+1]
+				if from == to {
+					continue
+				}
+				fc := FieldChange{Field: col}
+				if !spec.opaque[col] {
+					fc.From, fc.To = from.String, to.String
+				}
+				change.Fields = append(change.Fields, fc)
+			}
+			result.Changed = append(result.Changed, change)
+		}
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,154 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RouteService summarises one route's service on one day in one version.
+// Departures are seconds since midnight and may exceed 86400.
+type RouteService struct {
+	Trips          int `json:"trips"`
+	FirstDeparture int `json:"first_departure,omitempty"`
+	LastDeparture  int `json:"last_departure,omitempty"`
+}
+
+// ServiceChange is a route whose service on a given day differs between versions
+type ServiceChange struct {
+	Date           string       `json:"date"`
+	SourceID       int          `json:"source_id"`
+	RouteID        string       `json:"route_id"`
+	RouteShortName string       `json:"route_short_name,omitempty"`
+	From           RouteService `json:"from"`
+	To             RouteService `json:"to"`
+}
+
+type routeKey struct {
+	sourceID int
+	routeID  string
+}
+
+type routeDay struct {
+	shortName string
+	service   RouteService
+}
+
+// routeServiceQuery counts each route's trips on a date and the first and
+// last trip start times, using the version's expanded service dates
+const routeServiceQuery = `
+	WITH active_services AS (
+		SELECT service_id, source_id
+		FROM gtfs.service_dates
+		WHERE version_id = $1 AND date = $2::date AND ($3 = 0 OR source_id = $3)
+	),
+	trip_starts AS (
+		SELECT t.source_id, t.route_id, t.trip_id, MIN(st.departure_time_seconds) AS start_seconds
+		FROM gtfs.trips t
+		JOIN active_services a ON a.service_id = t.service_id AND a.source_id = t.source_id
+		JOIN gtfs.stop_times st ON st.trip_id = t.trip_id AND st.source_id = t.source_id AND st.version_id = t.version_id
+		WHERE t.version_id = $1
+		GROUP BY t.source_id, t.route_id, t.trip_id
+	)
+	SELECT ts.source_id, ts.route_id, r.route_short_name, COUNT(*), MIN(ts.start_seconds), MAX(ts.start_seconds)
+	FROM trip_starts ts
+	LEFT JOIN gtfs.routes r ON r.route_id = ts.route_id AND r.source_id = ts.source_id AND r.version_id = $1
+	GROUP BY ts.source_id, ts.route_id, r.route_short_name
+`
+
+func (d *Differ) diffService(ctx context.Context, fromVersion, toVersion int, opts Options) ([]ServiceChange, error) {
+	changes := []ServiceChange{}
+
+	days := opts.ServiceDays
+	if days <= 0 {
+		days = 1
+	}
+
+	for i := 0; i < days; i++ {
+		date := opts.ServiceDate.AddDate(0, 0, i).Format("2006-01-02")
+
+		from, err := d.routeService(ctx, fromVersion, date, opts.SourceID)
+		if err != nil {
+			return nil, fmt.Errorf("loading service for version %d on %s: %w", fromVersion, date, err)
+		}
+		to, err := d.routeService(ctx, toVersion, date, opts.SourceID)
+		if err != nil {
+			return nil, fmt.Errorf("loading service for version %d on %s: %w", toVersion, date, err)
+		}
+
+		keys := make(map[routeKey]bool)
+		for key := range from {
+			keys[key] = true
+		}
+		for key := range to {
+			keys[key] = true
+		}
+
+		var dayChanges []ServiceChange
+		for key := range keys {
+			f, t := from[key], to[key]
+			if f.service == t.service {
+				continue
+			}
+			shortName := t.shortName
+			if shortName == "" {
+				shortName = f.shortName
+			}
+			dayChanges = append(dayChanges, ServiceChange{
+				Date:           date,
+				SourceID:       key.sourceID,
+				RouteID:        key.routeID,
+				RouteShortName: shortName,
+				From:           f.service,
+				To:             t.service,
+			})
+		}
+
+		sort.Slice(dayChanges, func(i, j int) bool {
+			if dayChanges[i].SourceID != dayChanges[j].SourceID {
+				return dayChanges[i].SourceID < dayChanges[j].SourceID
+			}
+			return dayChanges[i].RouteID < dayChanges[j].RouteID
+		})
+		changes = append(changes, dayChanges...)
+	}
+
+	return changes, nil
+}
+
+func (d *Differ) routeService(ctx context.Context, versionID int, date string, sourceID int) (map[routeKey]routeDay, error) {
+	start := time.Now()
+	rows, err := d.db.DB().QueryContext(ctx, routeServiceQuery, versionID, date, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	service := make(map[routeKey]routeDay)
+	for rows.Next() {
+		var key routeKey
+		var shortName sql.NullString
+		var first, last sql.NullInt64
+		var day routeDay
+		if err := rows.Scan(&key.sourceID, &key.routeID, &shortName, &day.service.Trips, &first, &last); err != nil {
+			return nil, fmt.Errorf("scanning route service: %w", err)
+		}
+		day.shortName = shortName.String
+		day.service.FirstDeparture = int(first.Int64)
+		day.service.LastDeparture = int(last.Int64)
+		service[key] = day
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	d.logger.Debug("Loaded route service",
+		"version_id", versionID,
+		"date", date,
+		"routes", len(service),
+		"duration", time.Since(start))
+
+	return service, nil
+}
@@ -0,0 +1,357 @@
+package gates
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/common/logger"
+)
+
+// Gate names, as reported in results and notifications
+const (
+	GateRowCounts        = "row_counts"
+	GateRequiredSources  = "required_sources"
+	GateCalendarCoverage = "calendar_coverage"
+	GateValidationErrors = "validation_errors"
+)
+
+// Config controls which gates run and their thresholds
+type Config struct {
+	// MaxRowChangePercent fails the version if any table's row count for a
+	// source differs from the active version by more than this. 0 disables.
+	MaxRowChangePercent float64
+
+	// RequiredSources must each have trips in the new version. When empty,
+	// every source with trips in the active version is required.
+	RequiredSources []int
+
+	// MinCalendarDays is how many days ahead every source's calendar must
+	// reach. 0 disables.
+	MinCalendarDays int
+
+	// MaxValidationErrors is the most validation errors allowed across all
+	// sources. Negative disables.
+	MaxValidationErrors int
+}
+
+// DefaultConfig returns conservative thresholds that only catch broken feeds
+func DefaultConfig() Config {
+	return Config{
+		MaxRowChangePercent: 50,
+		MinCalendarDays:     7,
+		MaxValidationErrors: -1,
+	}
+}
+
+// Input describes the candidate version being checked
+type Input struct {
+	VersionID        int
+	ActiveVersionID  int // 0 when there is no active version
+	ValidationErrors int
+	Validated        bool      // false when validation is off and ValidationErrors means nothing
+	Today            time.Time // service date the calendar gate counts from
+}
+
+// GateResult is the outcome of one gate
+type GateResult struct {
+	Name    string
+	Passed  bool
+	Skipped bool
+	Details []string
+}
+
+// Result holds every gate's outcome for a version
+type Result struct {
+	VersionID       int
+	ActiveVersionID int
+	Gates           []GateResult
+}
+
+// Passed reports whether no gate failed
+func (r *Result) Passed() bool {
+	return len(r.Failed()) == 0
+}
+
+// Failed returns the gates that failed
+func (r *Result) Failed() []GateResult {
+	var failed []GateResult
+	for _, g := range r.Gates {
+		if !g.Passed && !g.Skipped {
+			failed = append(failed, g)
+		}
+	}
+	return failed
+}
+
+// Error summarises the failed gates, or returns nil if all passed
+func (r *Result) Error() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	names := make([]string, len(failed))
+	for i, g := range failed {
+		names[i] = g.Name
+	}
+	return fmt.Errorf("version %d failed activation gates: %s", r.VersionID, strings.Join(names, ", "))
+}
+
+// countedTables are compared per source by the row count gate
+var countedTables = []string{"stops", "routes", "trips", "stop_times", "calendar"}
+
+// Checker runs the activation gates against the database
+type Checker struct {
+	db     *db.DB
+	logger logger.Logger
+	config Config
+}
+
+func New(database *db.DB, logger logger.Logger, config Config) *Checker {
+	return &Checker{
+		db:     database,
+		logger: logger,
+		config: config,
+	}
+}
+
+// Check runs every gate. An error means a gate could not be evaluated, not
+// that it failed.
+func (c *Checker) Check(ctx context.Context, in Input) (*Result, error) {
+	result := &Result{VersionID: in.VersionID, ActiveVersionID: in.ActiveVersionID}
+
+	checks := []func(context.Context, Input) (GateResult, error){
+		c.checkRowCounts,
+		c.checkRequiredSources,
+		c.checkCalendarCoverage,
+		c.checkValidationErrors,
+	}
+	for _, check := range checks {
+		gate, err := check(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", gate.Name, err)
+		}
+		result.Gates = append(result.Gates, gate)
+	}
+
+	var skipped []string
+	for _, g := range result.Gates {
+		if g.Skipped {
+			skipped = append(skipped, g.Name)
+		}
+	}
+	c.logger.Info("Activation gates checked",
+		"version_id", in.VersionID,
+		"active_version_id", in.ActiveVersionID,
+		"passed", result.Passed(),
+		"skipped", strings.Join(skipped, ","))
+
+	return result, nil
+}
+
+func (c *Checker) checkRowCounts(ctx context.Context, in Input) (GateResult, error) {
+	gate := GateResult{Name: GateRowCounts, Passed: true}
+	if c.config.MaxRowChangePercent <= 0 || in.ActiveVersionID == 0 {
+		gate.Skipped = true
+		return gate, nil
+	}
+
+	for _, table := range countedTables {
+		active, err := c.sourceCounts(ctx, table, in.ActiveVersionID)
+		if err != nil {
+			return gate, err
+		}
+		candidate, err := c.sourceCounts(ctx, table, in.VersionID)
+		if err != nil {
+			return gate, err
+		}
+
+		for _, sourceID := range sortedKeys(active) {
+			before, after := active[sourceID], candidate[sourceID]
+			if before == 0 {
+				continue
+			}
+			change := float64(after-before) / float64(before) * 100
+			if change > c.config.MaxRowChangePercent || -change > c.config.MaxRowChangePercent {
+				gate.Passed = false
+				gate.Details = append(gate.Details, fmt.Sprintf("source %d %s: %d -> %d (%+.1f%%)",
+					sourceID, table, before, after, change))
+			}
+		}
+	}
+
+	return gate, nil
+}
+
+func (c *Checker) checkRequiredSources(ctx context.Context, in Input) (GateResult, error) {
+	gate := GateResult{Name: GateRequiredSources, Passed: true}
+
+	required, err := c.requiredSources(ctx, in)
+	if err != nil {
+		return gate, err
+	}
+	if required == nil {
+		gate.Skipped = true
+		return gate, nil
+	}
+
+	candidate, err := c.sourceCounts(ctx, "trips", in.VersionID)
+	if err != nil {
+		return gate, err
+	}
+
+	for _, sourceID := range required {
+		if candidate[sourceID] == 0 {
+			gate.Passed = false
+			gate.Details = append(gate.Details, fmt.Sprintf("source %d has no trips", sourceID))
+		}
+	}
+
+	return gate, nil
+}
+
+// requiredSources returns the configured required sources, or else those
+// with trips in the active version. Nil means there is nothing to require.
+func (c *Checker) requiredSources(ctx context.Context, in Input) ([]int, error) {
+	if len(c.config.RequiredSources) > 0 {
+		return c.config.RequiredSources, nil
+	}
+	if in.ActiveVersionID == 0 {
+		return nil, nil
+	}
+	active, err := c.sourceCounts(ctx, "trips", in.ActiveVersionID)
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(active), nil
+}
+
+func (c *Checker) checkCalendarCoverage(ctx context.Context, in Input) (GateResult, error) {
+	gate := GateResult{Name: GateCalendarCoverage, Passed: true}
+	if c.config.MinCalendarDays <= 0 {
+		gate.Skipped = true
+		return gate, nil
+	}
+
+	// Every required source and every source with trips must have service;
+	// one without any service dates has no row below, so look for them all
+	required, err := c.requiredSources(ctx, in)
+	if err != nil {
+		return gate, err
+	}
+	candidate, err := c.sourceCounts(ctx, "trips", in.VersionID)
+	if err != nil {
+		return gate, err
+	}
+	expected := make(map[int]int64, len(required)+len(candidate))
+	for _, sourceID := range required {
+		expected[sourceID] = 0
+	}
+	for sourceID, trips := range candidate {
+		expected[sourceID] = trips
+	}
+
+	lastDates, err := c.lastServiceDates(ctx, in.VersionID)
+	if err != nil {
+		return gate, err
+	}
+
+	need := in.Today.AddDate(0, 0, c.config.MinCalendarDays).Format("2006-01-02")
+	for _, sourceID := range sortedKeys(expected) {
+		lastDate, ok := lastDates[sourceID]
+		if !ok {
+			gate.Passed = false
+			gate.Details = append(gate.Details, fmt.Sprintf("source %d has no service dates, need %s", sourceID, need))
+			continue
+		}
+		if last := lastDate.Format("2006-01-02"); last < need {
+			gate.Passed = false
+			gate.Details = append(gate.Details, fmt.Sprintf("source %d service ends %s, need %s", sourceID, last, need))
+		}
+	}
+
+	return gate, nil
+}
+
+// lastServiceDates returns the last date each source of a version has any
+// service
+func (c *Checker) lastServiceDates(ctx context.Context, versionID int) (map[int]time.Time, error) {
+	rows, err := c.db.DB().QueryContext(ctx, `
+		SELECT source_id, MAX(date)
+		FROM gtfs.service_dates
+		WHERE version_id = $1
+		GROUP BY source_id
+	`, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("querying calendar coverage: %w", err)
+	}
+	defer rows.Close()
+
+	lastDates := make(map[int]time.Time)
+	for rows.Next() {
+		var sourceID int
+		var lastDate time.Time
+		if err := rows.Scan(&sourceID, &lastDate); err != nil {
+			return nil, fmt.Errorf("scanning calendar coverage: %w", err)
+		}
+		lastDates[sourceID] = lastDate
+	}
+
+	return lastDates, rows.Err()
+}
+
+func (c *Checker) checkValidationErrors(ctx context.Context, in Input) (GateResult, error) {
+	gate := GateResult{Name: GateValidationErrors, Passed: true}
+	if c.config.MaxValidationErrors < 0 {
+		gate.Skipped = true
+		return gate, nil
+	}
+	if !in.Validated {
+		gate.Skipped = true
+		gate.Details = append(gate.Details, "validation is off, no errors were counted")
+		return gate, nil
+	}
+
+	if in.ValidationErrors > c.config.MaxValidationErrors {
+		gate.Passed = false
+		gate.Details = append(gate.Details, fmt.Sprintf("%d validation errors, at most %d allowed",
+			in.ValidationErrors, c.config.MaxValidationErrors))
+	}
+
+	return gate, nil
+}
+
+// sourceCounts returns a table's row count per source for a version
+func (c *Checker) sourceCounts(ctx context.Context, table string, versionID int) (map[int]int64, error) {
+	rows, err := c.db.DB().QueryContext(ctx,
+		fmt.Sprintf("SELECT source_id, COUNT(*) FROM gtfs.%s WHERE version_id = $1 GROUP BY source_id", table),
+		versionID)
+	if err != nil {
+		return nil, fmt.Errorf("counting %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int64)
+	for rows.Next() {
+		var sourceID int
+		var count int64
+		if err := rows.Scan(&sourceID, &count); err != nil {
+			return nil, fmt.Errorf("scanning %s count: %w", table, err)
+		}
+		counts[sourceID] = count
+	}
+
+	return counts, rows.Err()
+}
+
+func sortedKeys(m map[int]int64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -3,13 +3,18 @@ package importer
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"strconv"
+	"io"
+	"io/fs"
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/gtfs-static/archive"
 	"github.com/ptvtracker-data/internal/gtfs-static/parser"
+	"github.com/ptvtracker-data/pkg/gtfs-static/gtfstime"
 	"github.com/ptvtracker-data/pkg/gtfs-static/models"
 )
 
@@ -17,33 +22,188 @@ type Importer struct {
 	db        *db.DB
 	sourceID  int
 	versionID int
-	batchSize int
+	opts      Options
 }
 
-func NewImporter(database *db.DB, sourceID, versionID int) *Importer {
+// Options tunes how rows are streamed into PostgreSQL
+type Options struct {
+	// BatchSize is the number of rows sent in a single COPY before it is
+	// completed and a new one started. It bounds how much work is lost to a
+	// failed COPY and how long the server buffers an in-flight statement.
+	BatchSize int
+
+	// OnFileComplete, if set, runs inside the import transaction after each
+	// file has been loaded
+	OnFileComplete func(tx *sql.Tx, fileName string, rows int) error
+
+	// BeforeCommit, if set, runs inside the import transaction just before it
+	// commits, so anything it writes is committed atomically with the data
+	BeforeCommit func(tx *sql.Tx) error
+
+	// MaterializeDays, if positive, writes absolute departure instants to
+	// gtfs.departure_instants for this many service days from MaterializeFrom
+	MaterializeDays int
+	MaterializeFrom time.Time
+
+	// ShapeTolerances lists the tolerances, in metres, at which each shape is
+	// also stored as a simplified line in gtfs.shape_geometries; 0 keeps
+	// every point. Empty skips building geometries.
+	ShapeTolerances []float64
+
+	// StopClusterRadius is the distance in metres within which stations and
+	// standalone stops share a cluster in gtfs.stop_hierarchy; 0 puts every
+	// station in its own cluster
+	StopClusterRadius float64
+
+	// Files, if set, limits the import to these GTFS files. Files they
+	// reference through foreign keys are loaded too (see WithDependencies),
+	// so the deferred constraints hold when the transaction commits.
+	Files []string
+}
+
+// Files lists the GTFS files the importer loads, in load order
+var Files = []string{
+	"agency.txt",
+	"levels.txt",
+	"stops.txt",
+	"routes.txt",
+	"calendar.txt",
+	"calendar_dates.txt",
+	"shapes.txt",
+	"trips.txt",
+	"stop_times.txt",
+	"pathways.txt",
+	"transfers.txt",
+}
+
+// fileDependencies lists the files whose rows each file's foreign keys point at
+var fileDependencies = map[string][]string{
+	"stops.txt":      {"levels.txt"},
+	"routes.txt":     {"agency.txt"},
+	"trips.txt":      {"routes.txt"},
+	"stop_times.txt": {"trips.txt", "stops.txt"},
+	"pathways.txt":   {"stops.txt"},
+	"transfers.txt":  {"stops.txt", "routes.txt", "trips.txt"},
+}
+
+func isImportedFile(name string) bool {
+	for _, file := range Files {
+		if file == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithDependencies returns files plus every file they depend on through
+// foreign keys, in load order. Unknown file names are an error.
+func WithDependencies(files []string) ([]string, error) {
+	selected := make(map[string]bool)
+	var add func(name string)
+	add = func(name string) {
+		if selected[name] {
+			return
+		}
+		selected[name] = true
+		for _, dep := range fileDependencies[name] {
+			add(dep)
+		}
+	}
+
+	for _, name := range files {
+		if !isImportedFile(name) {
+			return nil, fmt.Errorf("unknown GTFS file %q", name)
+		}
+		add(name)
+	}
+
+	var resolved []string
+	for _, name := range Files {
+		if selected[name] {
+			resolved = append(resolved, name)
+		}
+	}
+	return resolved, nil
+}
+
+// DefaultOptions returns sensible defaults
+func DefaultOptions() Options {
+	return Options{
+		BatchSize:         50000,
+		ShapeTolerances:   DefaultShapeTolerances,
+		StopClusterRadius: DefaultStopClusterRadius,
+	}
+}
+
+func NewImporter(database *db.DB, sourceID, versionID int, opts Options) *Importer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultOptions().BatchSize
+	}
 	return &Importer{
 		db:        database,
 		sourceID:  sourceID,
 		versionID: versionID,
-		batchSize: 1000,
+		opts:      opts,
 	}
 }
 
+// Import loads the GTFS zip at zipPath
 func (i *Importer) Import(ctx context.Context, zipPath string) error {
+	reader, err := archive.Open(zipPath, archive.DefaultLimits())
+	if err != nil {
+		return fmt.Errorf("parsing zip: %w", err)
+	}
+	defer reader.Close()
+
+	return i.ImportFS(ctx, reader)
+}
+
+// ImportReader loads a GTFS zip of the given size read from r, such as a
+// nested zip read in place from the master archive
+func (i *Importer) ImportReader(ctx context.Context, r io.ReaderAt, size int64) error {
+	reader, err := archive.NewReader(r, size, archive.DefaultLimits())
+	if err != nil {
+		return fmt.Errorf("parsing zip: %w", err)
+	}
+
+	return i.ImportFS(ctx, reader)
+}
+
+// ImportFS loads the GTFS files at the root of fsys
+func (i *Importer) ImportFS(ctx context.Context, fsys fs.FS) error {
+	if i.opts.Files != nil {
+		files, err := WithDependencies(i.opts.Files)
+		if err != nil {
+			return fmt.Errorf("selecting files: %w", err)
+		}
+		i.opts.Files = files
+	}
+
 	p := parser.New(i.db.Logger())
 
-	// Create batch inserters
-	agencyBatch := i.newBatchInserter("agency", 8)
-	stopBatch := i.newBatchInserter("stops", 10)
-	routeBatch := i.newBatchInserter("routes", 9)
-	calendarBatch := i.newBatchInserter("calendar", 12)
-	calendarDateBatch := i.newBatchInserter("calendar_dates", 5)
-	shapeBatch := i.newBatchInserter("shapes", 7)
-	tripBatch := i.newBatchInserter("trips", 10)
-	stopTimeBatch := i.newBatchInserter("stop_times", 11)
-	levelBatch := i.newBatchInserter("levels", 5)
-	pathwayBatch := i.newBatchInserter("pathways", 8)
-	transferBatch := i.newBatchInserter("transfers", 10)
+	// Create COPY inserters, one per table
+	agencyBatch := i.newCopyInserter("agency")
+	stopBatch := i.newCopyInserter("stops")
+	routeBatch := i.newCopyInserter("routes")
+	calendarBatch := i.newCopyInserter("calendar")
+	calendarDateBatch := i.newCopyInserter("calendar_dates")
+	shapeBatch := i.newCopyInserter("shapes")
+	tripBatch := i.newCopyInserter("trips")
+	stopTimeBatch := i.newCopyInserter("stop_times")
+	levelBatch := i.newCopyInserter("levels")
+	pathwayBatch := i.newCopyInserter("pathways")
+	transferBatch := i.newCopyInserter("transfers")
+	shapeGeometryBatch := i.newCopyInserter("shape_geometries")
+	stopHierarchyBatch := i.newCopyInserter("stop_hierarchy")
+
+	// Shape points are also gathered in memory to build whole-line geometries
+	var shapes *shapeCollector
+	if len(i.opts.ShapeTolerances) > 0 {
+		shapes = newShapeCollector()
+	}
+
+	// Stops too, to resolve stations and nearby clusters
+	stops := newStopCollector()
 
 	// Begin transaction
 	tx, err := i.db.BeginTx(ctx)
@@ -57,17 +217,33 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 		return fmt.Errorf("setting deferred constraints: %w", err)
 	}
 
-	// Set transaction for all batch inserters
-	batches := []*batchInserter{
+	// Set transaction for all inserters
+	batches := []*copyInserter{
 		agencyBatch, levelBatch, stopBatch, routeBatch, calendarBatch,
 		calendarDateBatch, shapeBatch, tripBatch, stopTimeBatch,
-		pathwayBatch, transferBatch,
+		pathwayBatch, transferBatch, shapeGeometryBatch, stopHierarchyBatch,
 	}
 
 	for _, batch := range batches {
 		batch.tx = tx
 	}
 
+	// Only one COPY can be in progress on a connection, so each file's
+	// inserter is flushed before the parser moves on to the next file
+	fileBatches := map[string]*copyInserter{
+		"agency.txt":         agencyBatch,
+		"levels.txt":         levelBatch,
+		"stops.txt":          stopBatch,
+		"routes.txt":         routeBatch,
+		"calendar.txt":       calendarBatch,
+		"calendar_dates.txt": calendarDateBatch,
+		"shapes.txt":         shapeBatch,
+		"trips.txt":          tripBatch,
+		"stop_times.txt":     stopTimeBatch,
+		"pathways.txt":       pathwayBatch,
+		"transfers.txt":      transferBatch,
+	}
+
 	callbacks := parser.ParseCallbacks{
 		OnAgency: func(agency *models.Agency) error {
 			return agencyBatch.Add(
@@ -79,9 +255,11 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				agency.AgencyTimezone,
 				sql.NullString{String: agency.AgencyLang, Valid: agency.AgencyLang != ""},
 				sql.NullString{String: agency.AgencyFareURL, Valid: agency.AgencyFareURL != ""},
+				extraJSON(agency.Extra),
 			)
 		},
 		OnStop: func(stop *models.Stop) error {
+			stops.add(stop)
 			return stopBatch.Add(
 				stop.StopID,
 				i.sourceID,
@@ -93,6 +271,7 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				sql.NullString{String: stop.ParentStation, Valid: stop.ParentStation != ""},
 				stop.WheelchairBoarding,
 				sql.NullString{String: stop.LevelID, Valid: stop.LevelID != ""},
+				extraJSON(stop.Extra),
 			)
 		},
 		OnRoute: func(route *models.Route) error {
@@ -106,6 +285,7 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				route.RouteType,
 				sql.NullString{String: route.RouteColor, Valid: route.RouteColor != ""},
 				sql.NullString{String: route.RouteTextColor, Valid: route.RouteTextColor != ""},
+				extraJSON(route.Extra),
 			)
 		},
 		OnCalendar: func(calendar *models.Calendar) error {
@@ -122,6 +302,7 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				calendar.Sunday,
 				calendar.StartDate,
 				calendar.EndDate,
+				extraJSON(calendar.Extra),
 			)
 		},
 		OnCalendarDate: func(calendarDate *models.CalendarDate) error {
@@ -131,9 +312,13 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				i.versionID,
 				calendarDate.Date,
 				calendarDate.ExceptionType,
+				extraJSON(calendarDate.Extra),
 			)
 		},
 		OnShape: func(shape *models.Shape) error {
+			if shapes != nil {
+				shapes.add(shape)
+			}
 			return shapeBatch.Add(
 				shape.ShapeID,
 				i.sourceID,
@@ -142,6 +327,7 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				shape.ShapePtLon,
 				shape.ShapePtSequence,
 				sql.NullFloat64{Float64: shape.ShapeDistTraveled, Valid: shape.ShapeDistTraveled != 0},
+				extraJSON(shape.Extra),
 			)
 		},
 		OnTrip: func(trip *models.Trip) error {
@@ -156,18 +342,19 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				sql.NullInt64{Int64: int64(trip.DirectionID), Valid: true},
 				sql.NullString{String: trip.BlockID, Valid: trip.BlockID != ""},
 				trip.WheelchairAccessible,
+				extraJSON(trip.Extra),
 			)
 		},
 		OnStopTime: func(stopTime *models.StopTime) error {
-			// Convert time strings to seconds since midnight
+			// Convert time strings to seconds since the start of the service day
 			var arrivalSec, departureSec sql.NullInt64
 			if stopTime.ArrivalTime != "" {
-				if s, err := parseGTFSTimeToSeconds(stopTime.ArrivalTime); err == nil {
+				if s, err := gtfstime.ParseTime(stopTime.ArrivalTime); err == nil {
 					arrivalSec = sql.NullInt64{Int64: int64(s), Valid: true}
 				}
 			}
 			if stopTime.DepartureTime != "" {
-				if s, err := parseGTFSTimeToSeconds(stopTime.DepartureTime); err == nil {
+				if s, err := gtfstime.ParseTime(stopTime.DepartureTime); err == nil {
 					departureSec = sql.NullInt64{Int64: int64(s), Valid: true}
 				}
 			}
@@ -184,6 +371,7 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				stopTime.PickupType,
 				stopTime.DropOffType,
 				sql.NullFloat64{Float64: stopTime.ShapeDistTraveled, Valid: stopTime.ShapeDistTraveled != 0},
+				extraJSON(stopTime.Extra),
 			)
 		},
 		OnLevel: func(level *models.Level) error {
@@ -193,6 +381,7 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				i.versionID,
 				sql.NullFloat64{Float64: level.LevelIndex, Valid: level.LevelIndex != 0},
 				sql.NullString{String: level.LevelName, Valid: level.LevelName != ""},
+				extraJSON(level.Extra),
 			)
 		},
 		OnPathway: func(pathway *models.Pathway) error {
@@ -205,6 +394,7 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				pathway.PathwayMode,
 				sql.NullInt64{Int64: int64(pathway.IsBidirectional), Valid: true},
 				sql.NullInt64{Int64: int64(pathway.TraversalTime), Valid: pathway.TraversalTime != 0},
+				extraJSON(pathway.Extra),
 			)
 		},
 		OnTransfer: func(transfer *models.Transfer) error {
@@ -228,23 +418,78 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 				toTripID,
 				transfer.TransferType,
 				sql.NullInt64{Int64: int64(transfer.MinTransferTime), Valid: transfer.MinTransferTime != 0},
+				extraJSON(transfer.Extra),
 			)
 		},
 		OnFileComplete: func(fileName string) error {
-			i.db.Logger().Debug("Finished processing file", "file", fileName)
+			if batch, ok := fileBatches[fileName]; ok {
+				if err := batch.Flush(); err != nil {
+					return fmt.Errorf("flushing %s: %w", batch.tableName, err)
+				}
+				i.db.Logger().Debug("Finished processing file",
+					"file", fileName,
+					"rows", batch.totalCount,
+					"duration", time.Since(batch.startedAt))
+
+				if i.opts.OnFileComplete != nil {
+					if err := i.opts.OnFileComplete(tx, fileName, batch.totalCount); err != nil {
+						return err
+					}
+				}
+			}
 			return nil
 		},
 	}
 
-	// Parse the zip file
-	if err := p.ParseZip(ctx, zipPath, callbacks); err != nil {
+	if i.opts.Files != nil {
+		i.selectFiles(&callbacks)
+	}
+
+	// Parse the feed
+	if err := p.ParseFS(ctx, fsys, callbacks); err != nil {
 		return fmt.Errorf("parsing zip: %w", err)
 	}
 
-	// Flush all remaining batches
+	// Flush anything still open
 	for _, batch := range batches {
 		if err := batch.Flush(); err != nil {
-			return fmt.Errorf("flushing %s batch: %w", batch.tableName, err)
+			return fmt.Errorf("flushing %s: %w", batch.tableName, err)
+		}
+	}
+
+	if shapes != nil {
+		if err := i.writeShapeGeometries(shapeGeometryBatch, shapes); err != nil {
+			return err
+		}
+	}
+
+	if len(stops.stops) > 0 {
+		if err := i.writeStopHierarchy(stopHierarchyBatch, stops); err != nil {
+			return err
+		}
+	}
+
+	// Expand calendar and calendar_dates now that both are loaded
+	if err := i.expandServiceDates(ctx, tx); err != nil {
+		return err
+	}
+
+	// Group trips into route patterns once trips and their stop times are in
+	if i.loads("trips.txt") && i.loads("stop_times.txt") {
+		if err := i.buildRoutePatterns(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	if i.opts.MaterializeDays > 0 {
+		if err := i.materializeDepartures(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	if i.opts.BeforeCommit != nil {
+		if err := i.opts.BeforeCommit(tx); err != nil {
+			return fmt.Errorf("running pre-commit hook: %w", err)
 		}
 	}
 
@@ -260,135 +505,231 @@ func (i *Importer) Import(ctx context.Context, zipPath string) error {
 	return nil
 }
 
-type batchInserter struct {
-	tableName  string
-	columns    []string
-	values     []interface{}
-	valueCount int
-	totalCount int // Track total records processed
-	batchSize  int
-	tx         *sql.Tx
-	fieldCount int
+// loads reports whether the import includes a file
+func (i *Importer) loads(fileName string) bool {
+	if i.opts.Files == nil {
+		return true
+	}
+	for _, name := range i.opts.Files {
+		if name == fileName {
+			return true
+		}
+	}
+	return false
 }
 
-func (i *Importer) newBatchInserter(tableName string, fieldCount int) *batchInserter {
-	columns := getColumnsForTable(tableName)
-	return &batchInserter{
-		tableName:  tableName,
-		columns:    columns,
-		values:     make([]interface{}, 0, i.batchSize*fieldCount),
-		batchSize:  i.batchSize,
-		fieldCount: fieldCount,
+// selectFiles drops the callbacks of files not in opts.Files, so the parser
+// skips them entirely
+func (i *Importer) selectFiles(callbacks *parser.ParseCallbacks) {
+	load := make(map[string]bool, len(i.opts.Files))
+	for _, name := range i.opts.Files {
+		load[name] = true
+	}
+
+	if !load["agency.txt"] {
+		callbacks.OnAgency = nil
+	}
+	if !load["levels.txt"] {
+		callbacks.OnLevel = nil
+	}
+	if !load["stops.txt"] {
+		callbacks.OnStop = nil
+	}
+	if !load["routes.txt"] {
+		callbacks.OnRoute = nil
+	}
+	if !load["calendar.txt"] {
+		callbacks.OnCalendar = nil
+	}
+	if !load["calendar_dates.txt"] {
+		callbacks.OnCalendarDate = nil
+	}
+	if !load["shapes.txt"] {
+		callbacks.OnShape = nil
+	}
+	if !load["trips.txt"] {
+		callbacks.OnTrip = nil
+	}
+	if !load["stop_times.txt"] {
+		callbacks.OnStopTime = nil
+	}
+	if !load["pathways.txt"] {
+		callbacks.OnPathway = nil
+	}
+	if !load["transfers.txt"] {
+		callbacks.OnTransfer = nil
 	}
 }
 
-func (b *batchInserter) Add(values ...interface{}) error {
-	b.values = append(b.values, values...)
-	b.valueCount++
-	b.totalCount++
+// expandServiceDates fills gtfs.service_dates for this source from the
+// calendar rows loaded in tx
+func (i *Importer) expandServiceDates(ctx context.Context, tx *sql.Tx) error {
+	start := time.Now()
 
-	if b.valueCount >= b.batchSize {
-		return b.Flush()
+	var rows int64
+	if err := tx.QueryRowContext(ctx, "SELECT gtfs.expand_service_dates($1, $2)", i.versionID, i.sourceID).Scan(&rows); err != nil {
+		return fmt.Errorf("expanding service dates: %w", err)
 	}
 
+	i.db.Logger().Debug("Expanded service dates",
+		"source_id", i.sourceID,
+		"rows", rows,
+		"duration", time.Since(start))
+
 	return nil
 }
 
-func (b *batchInserter) Flush() error {
-	if b.valueCount == 0 {
-		return nil
+// buildRoutePatterns groups this source's trips by route, direction and
+// ordered stops into gtfs.route_patterns
+func (i *Importer) buildRoutePatterns(ctx context.Context, tx *sql.Tx) error {
+	start := time.Now()
+
+	var patterns int64
+	if err := tx.QueryRowContext(ctx, "SELECT gtfs.build_route_patterns($1, $2)", i.versionID, i.sourceID).Scan(&patterns); err != nil {
+		return fmt.Errorf("building route patterns: %w", err)
 	}
 
-	query := b.buildInsertQuery()
-	_, err := b.tx.Exec(query, b.values...)
+	i.db.Logger().Debug("Built route patterns",
+		"source_id", i.sourceID,
+		"patterns", patterns,
+		"duration", time.Since(start))
+
+	return nil
+}
+
+// materializeDepartures resolves this source's stop times on the configured
+// service days to absolute instants; it needs the service dates expanded first
+func (i *Importer) materializeDepartures(ctx context.Context, tx *sql.Tx) error {
+	start := time.Now()
+	from := i.opts.MaterializeFrom.Format("2006-01-02")
+
+	var rows int64
+	err := tx.QueryRowContext(ctx, "SELECT gtfs.materialize_departures($1, $2, $3::date, $4)",
+		i.versionID, i.sourceID, from, i.opts.MaterializeDays).Scan(&rows)
 	if err != nil {
-		return fmt.Errorf("executing batch insert: %w", err)
+		return fmt.Errorf("materialising departures: %w", err)
 	}
 
-	// Reset
-	b.values = b.values[:0]
-	b.valueCount = 0
+	i.db.Logger().Debug("Materialised departure instants",
+		"source_id", i.sourceID,
+		"from", from,
+		"days", i.opts.MaterializeDays,
+		"rows", rows,
+		"duration", time.Since(start))
 
 	return nil
 }
 
-func (b *batchInserter) buildInsertQuery() string {
-	var sb strings.Builder
+// copyInserter streams rows into a gtfs table with COPY FROM STDIN. The COPY
+// is opened lazily on the first row and completed every batchSize rows.
+type copyInserter struct {
+	tableName  string
+	columns    []string
+	tx         *sql.Tx
+	stmt       *sql.Stmt
+	pending    int // rows in the open COPY
+	totalCount int // Track total records processed
+	batchSize  int
+	startedAt  time.Time
+}
 
-	sb.WriteString(fmt.Sprintf("INSERT INTO gtfs.%s (%s) VALUES ",
-		b.tableName,
-		strings.Join(b.columns, ", ")))
+func (i *Importer) newCopyInserter(tableName string) *copyInserter {
+	return &copyInserter{
+		tableName: tableName,
+		columns:   getColumnsForTable(tableName),
+		batchSize: i.opts.BatchSize,
+	}
+}
 
-	for i := 0; i < b.valueCount; i++ {
-		if i > 0 {
-			sb.WriteString(", ")
+func (b *copyInserter) Add(values ...interface{}) error {
+	if b.stmt == nil {
+		stmt, err := b.tx.Prepare(pq.CopyInSchema("gtfs", b.tableName, b.columns...))
+		if err != nil {
+			return fmt.Errorf("starting copy into %s: %w", b.tableName, err)
 		}
-		sb.WriteString("(")
-		for j := 0; j < b.fieldCount; j++ {
-			if j > 0 {
-				sb.WriteString(", ")
-			}
-			sb.WriteString(fmt.Sprintf("$%d", i*b.fieldCount+j+1))
+		b.stmt = stmt
+		if b.totalCount == 0 {
+			b.startedAt = time.Now()
 		}
-		sb.WriteString(")")
 	}
 
-	return sb.String()
+	if _, err := b.stmt.Exec(values...); err != nil {
+		return fmt.Errorf("copying row into %s: %w", b.tableName, err)
+	}
+	b.pending++
+	b.totalCount++
+
+	if b.pending >= b.batchSize {
+		return b.Flush()
+	}
+
+	return nil
+}
+
+// Flush completes the open COPY, if any
+func (b *copyInserter) Flush() error {
+	if b.stmt == nil {
+		return nil
+	}
+
+	stmt := b.stmt
+	b.stmt = nil
+	b.pending = 0
+
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return fmt.Errorf("completing copy: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("closing copy: %w", err)
+	}
+
+	return nil
 }
 
 func getColumnsForTable(tableName string) []string {
 	switch tableName {
 	case "agency":
-		return []string{"agency_id", "source_id", "version_id", "agency_name", "agency_url", "agency_timezone", "agency_lang", "agency_fare_url"}
+		return []string{"agency_id", "source_id", "version_id", "agency_name", "agency_url", "agency_timezone", "agency_lang", "agency_fare_url", "extra"}
 	case "stops":
-		return []string{"stop_id", "source_id", "version_id", "stop_name", "stop_lat", "stop_lon", "location_type", "parent_station", "wheelchair_boarding", "level_id"}
+		return []string{"stop_id", "source_id", "version_id", "stop_name", "stop_lat", "stop_lon", "location_type", "parent_station", "wheelchair_boarding", "level_id", "extra"}
 	case "routes":
-		return []string{"route_id", "source_id", "version_id", "agency_id", "route_short_name", "route_long_name", "route_type", "route_color", "route_text_color"}
+		return []string{"route_id", "source_id", "version_id", "agency_id", "route_short_name", "route_long_name", "route_type", "route_color", "route_text_color", "extra"}
 	case "calendar":
-		return []string{"service_id", "source_id", "version_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"}
+		return []string{"service_id", "source_id", "version_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date", "extra"}
 	case "calendar_dates":
-		return []string{"service_id", "source_id", "version_id", "date", "exception_type"}
+		return []string{"service_id", "source_id", "version_id", "date", "exception_type", "extra"}
 	case "shapes":
-		return []string{"shape_id", "source_id", "version_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"}
+		return []string{"shape_id", "source_id", "version_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled", "extra"}
 	case "trips":
-		return []string{"trip_id", "source_id", "version_id", "route_id", "service_id", "shape_id", "trip_headsign", "direction_id", "block_id", "wheelchair_accessible"}
+		return []string{"trip_id", "source_id", "version_id", "route_id", "service_id", "shape_id", "trip_headsign", "direction_id", "block_id", "wheelchair_accessible", "extra"}
 	case "stop_times":
-		return []string{"trip_id", "source_id", "version_id", "stop_id", "stop_sequence", "arrival_time_seconds", "departure_time_seconds", "stop_headsign", "pickup_type", "drop_off_type", "shape_dist_traveled"}
+		return []string{"trip_id", "source_id", "version_id", "stop_id", "stop_sequence", "arrival_time_seconds", "departure_time_seconds", "stop_headsign", "pickup_type", "drop_off_type", "shape_dist_traveled", "extra"}
 	case "levels":
-		return []string{"level_id", "source_id", "version_id", "level_index", "level_name"}
+		return []string{"level_id", "source_id", "version_id", "level_index", "level_name", "extra"}
 	case "pathways":
-		return []string{"pathway_id", "source_id", "version_id", "from_stop_id", "to_stop_id", "pathway_mode", "is_bidirectional", "traversal_time"}
+		return []string{"pathway_id", "source_id", "version_id", "from_stop_id", "to_stop_id", "pathway_mode", "is_bidirectional", "traversal_time", "extra"}
 	case "transfers":
-		return []string{"from_stop_id", "to_stop_id", "source_id", "version_id", "from_route_id", "to_route_id", "from_trip_id", "to_trip_id", "transfer_type", "min_transfer_time"}
+		return []string{"from_stop_id", "to_stop_id", "source_id", "version_id", "from_route_id", "to_route_id", "from_trip_id", "to_trip_id", "transfer_type", "min_transfer_time", "extra"}
+	case "stop_hierarchy":
+		return []string{"stop_id", "source_id", "version_id", "station_id", "parent_id", "location_type", "depth", "cluster_id"}
+	case "shape_geometries":
+		return []string{"shape_id", "source_id", "version_id", "tolerance_m", "point_count", "length_m", "min_lat", "min_lon", "max_lat", "max_lon", "polyline", "geojson"}
 	default:
 		return nil
 	}
 }
 
-func parseGTFSTimeToSeconds(timeStr string) (int, error) {
-	// GTFS times can be in format HH:MM:SS and can exceed 24:00:00
-	parts := strings.Split(timeStr, ":")
-	if len(parts) != 3 {
-		return 0, fmt.Errorf("invalid time format: %s", timeStr)
-	}
-
-	// Parse hours, minutes, seconds
-	h, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return 0, fmt.Errorf("invalid hours in time format: %s", timeStr)
-	}
-	m, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return 0, fmt.Errorf("invalid minutes in time format: %s", timeStr)
+// extraJSON encodes non-standard GTFS columns for the JSONB extra column
+func extraJSON(extra map[string]string) sql.NullString {
+	if len(extra) == 0 {
+		return sql.NullString{}
 	}
-	s, err := strconv.Atoi(parts[2])
+	encoded, err := json.Marshal(extra)
 	if err != nil {
-		return 0, fmt.Errorf("invalid seconds in time format: %s", timeStr)
+		return sql.NullString{}
 	}
-
-	// Convert to seconds since midnight
-	// This correctly handles times > 24:00:00 for next-day services
-	return h*3600 + m*60 + s, nil
+	return sql.NullString{String: string(encoded), Valid: true}
 }
 
 func parseGTFSTime(timeStr string) (time.Time, error) {
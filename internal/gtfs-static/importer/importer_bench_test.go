@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/common/logger"
+)
+
+// benchStopTimes is about the size of a large source's stop_times.txt
+const benchStopTimes = 1_000_000
+
+// BenchmarkCopyInserter streams generated stop_times rows through COPY at a
+// few batch sizes. Each run is rolled back, so it needs a migrated database
+// in DATABASE_URL but leaves it unchanged.
+func BenchmarkCopyInserter(b *testing.B) {
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		b.Skip("DATABASE_URL not set")
+	}
+	database, err := db.New(connStr, logger.New(io.Discard))
+	if err != nil {
+		b.Fatalf("connecting: %v", err)
+	}
+	defer database.Close()
+
+	for _, batchSize := range []int{10_000, 50_000, 250_000} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			// Version -1 never exists, and its rows are rolled back before
+			// the deferred foreign keys are checked
+			imp := NewImporter(database, -1, -1, Options{BatchSize: batchSize})
+
+			for n := 0; n < b.N; n++ {
+				if err := copyStopTimes(imp, benchStopTimes); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(benchStopTimes)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+// copyStopTimes copies that many generated stop_times rows into a transaction
+// that is then rolled back
+func copyStopTimes(imp *Importer, rows int) error {
+	tx, err := imp.db.BeginTx(context.Background())
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SET CONSTRAINTS ALL DEFERRED"); err != nil {
+		return fmt.Errorf("setting deferred constraints: %w", err)
+	}
+
+	batch := imp.newCopyInserter("stop_times")
+	batch.tx = tx
+
+	// Trips of 40 stops, two minutes apart, like a typical tram or bus run
+	const stopsPerTrip = 40
+	for row := 0; row < rows; row++ {
+		trip, seq := row/stopsPerTrip, row%stopsPerTrip+1
+		departure := 5*3600 + trip%1200*60 + seq*120
+		if err := batch.Add(
+			fmt.Sprintf("trip-%d", trip),
+			imp.sourceID,
+			imp.versionID,
+			fmt.Sprintf("stop-%d", (trip*7+seq)%20000),
+			seq,
+			departure,
+			departure,
+			sql.NullString{},
+			0,
+			0,
+			sql.NullFloat64{Float64: float64(seq) * 412.5, Valid: true},
+			extraJSON(nil),
+		); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
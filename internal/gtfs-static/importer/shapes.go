@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ptvtracker-data/pkg/gtfs-static/geo"
+	"github.com/ptvtracker-data/pkg/gtfs-static/models"
+)
+
+// DefaultShapeTolerances are the simplification tolerances, in metres, each
+// shape's geometry is stored at; 0 keeps every point
+var DefaultShapeTolerances = []float64{0, 5, 20, 100}
+
+type shapePoint struct {
+	sequence int
+	point    geo.Point
+}
+
+// shapeCollector gathers shape points while shapes.txt is parsed, since the
+// file isn't guaranteed to be grouped by shape or ordered by sequence
+type shapeCollector struct {
+	shapes map[string][]shapePoint
+}
+
+func newShapeCollector() *shapeCollector {
+	return &shapeCollector{shapes: make(map[string][]shapePoint)}
+}
+
+func (c *shapeCollector) add(shape *models.Shape) {
+	c.shapes[shape.ShapeID] = append(c.shapes[shape.ShapeID], shapePoint{
+		sequence: shape.ShapePtSequence,
+		point:    geo.Point{Lat: shape.ShapePtLat, Lon: shape.ShapePtLon},
+	})
+}
+
+// writeShapeGeometries stores every collected shape as a line at each of the
+// configured tolerances
+func (i *Importer) writeShapeGeometries(batch *copyInserter, shapes *shapeCollector) error {
+	start := time.Now()
+
+	ids := make([]string, 0, len(shapes.shapes))
+	for id := range shapes.shapes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		raw := shapes.shapes[id]
+		sort.Slice(raw, func(a, b int) bool { return raw[a].sequence < raw[b].sequence })
+		points := make([]geo.Point, len(raw))
+		for n, p := range raw {
+			points[n] = p.point
+		}
+		delete(shapes.shapes, id) // release the points as we go
+
+		length := geo.Length(points)
+		sw, ne := geo.Bounds(points)
+
+		for _, tolerance := range i.opts.ShapeTolerances {
+			line := geo.Simplify(points, tolerance)
+			geojson, err := geo.GeoJSON(line)
+			if err != nil {
+				return fmt.Errorf("encoding shape %s: %w", id, err)
+			}
+
+			err = batch.Add(
+				id,
+				i.sourceID,
+				i.versionID,
+				tolerance,
+				len(line),
+				length,
+				sw.Lat,
+				sw.Lon,
+				ne.Lat,
+				ne.Lon,
+				geo.EncodePolyline(line),
+				string(geojson),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := batch.Flush(); err != nil {
+		return fmt.Errorf("flushing %s: %w", batch.tableName, err)
+	}
+
+	i.db.Logger().Debug("Wrote shape geometries",
+		"source_id", i.sourceID,
+		"shapes", len(ids),
+		"rows", batch.totalCount,
+		"duration", time.Since(start))
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ptvtracker-data/pkg/gtfs-static/geo"
+	"github.com/ptvtracker-data/pkg/gtfs-static/models"
+)
+
+// DefaultStopClusterRadius is the distance in metres within which stations
+// and standalone stops are grouped as one physical stop, e.g. the stops on
+// either side of a road
+const DefaultStopClusterRadius = 40
+
+// maxStopDepth bounds parent_station chains; GTFS allows at most three levels
+// (boarding area, platform, station), so anything deeper is a cycle
+const maxStopDepth = 8
+
+type stopNode struct {
+	id           string
+	parent       string
+	locationType int
+	point        geo.Point
+}
+
+// stopHierarchy is one row of gtfs.stop_hierarchy
+type stopHierarchy struct {
+	stationID string
+	parentID  string
+	depth     int
+	clusterID string
+}
+
+// stopCollector gathers stops while stops.txt is parsed, since parents may
+// come after their children in the file
+type stopCollector struct {
+	stops map[string]*stopNode
+}
+
+func newStopCollector() *stopCollector {
+	return &stopCollector{stops: make(map[string]*stopNode)}
+}
+
+func (c *stopCollector) add(stop *models.Stop) {
+	c.stops[stop.StopID] = &stopNode{
+		id:           stop.StopID,
+		parent:       stop.ParentStation,
+		locationType: stop.LocationType,
+		point:        geo.Point{Lat: stop.StopLat, Lon: stop.StopLon},
+	}
+}
+
+// build resolves every stop's station, the root of its parent_station
+// chain, and the cluster of nearby stations its station belongs to
+func (c *stopCollector) build(radius float64) map[string]stopHierarchy {
+	result := make(map[string]stopHierarchy, len(c.stops))
+	var roots []*stopNode
+
+	for id, stop := range c.stops {
+		root, depth := stop, 0
+		for root.parent != "" && depth < maxStopDepth {
+			parent, ok := c.stops[root.parent]
+			if !ok {
+				break // dangling parent; the foreign key check reports it
+			}
+			root, depth = parent, depth+1
+		}
+
+		result[id] = stopHierarchy{stationID: root.id, parentID: stop.parent, depth: depth}
+		if root == stop {
+			roots = append(roots, stop)
+		}
+	}
+
+	clusters := clusterStops(roots, radius)
+	for id, h := range result {
+		h.clusterID = clusters[h.stationID]
+		if h.clusterID == "" {
+			h.clusterID = h.stationID // parent_station cycle, no real root
+		}
+		result[id] = h
+	}
+	return result
+}
+
+// clusterStops groups roots that are within radius metres of each other,
+// directly or through a chain of neighbours. Each cluster is named after its
+// smallest stop_id so the result doesn't depend on map order.
+func clusterStops(roots []*stopNode, radius float64) map[string]string {
+	parent := make(map[string]string, len(roots))
+	var find func(id string) string
+	find = func(id string) string {
+		for parent[id] != id {
+			parent[id] = parent[parent[id]]
+			id = parent[id]
+		}
+		return id
+	}
+	union := func(a, b string) {
+		ra, rb := find(a), find(b)
+		if ra == rb {
+			return
+		}
+		if rb < ra {
+			ra, rb = rb, ra
+		}
+		parent[rb] = ra
+	}
+
+	for _, stop := range roots {
+		parent[stop.id] = stop.id
+	}
+
+	if radius > 0 {
+		// Bucket stops into cells at least radius wide so only neighbouring
+		// cells need comparing. Cells are sized for the stop furthest from
+		// the equator, where a degree of longitude is shortest.
+		var maxLat float64
+		for _, stop := range roots {
+			maxLat = math.Max(maxLat, math.Abs(stop.point.Lat))
+		}
+		cellLat := radius / 111320
+		cellLon := cellLat / math.Max(math.Cos(maxLat*math.Pi/180), 0.01)
+
+		type cell struct{ x, y int }
+		grid := make(map[cell][]*stopNode)
+		cellOf := func(p geo.Point) cell {
+			return cell{int(math.Floor(p.Lon / cellLon)), int(math.Floor(p.Lat / cellLat))}
+		}
+
+		sort.Slice(roots, func(i, j int) bool { return roots[i].id < roots[j].id })
+		for _, stop := range roots {
+			if stop.point.Lat == 0 && stop.point.Lon == 0 {
+				continue // no coordinates
+			}
+			c := cellOf(stop.point)
+			for dx := -1; dx <= 1; dx++ {
+				for dy := -1; dy <= 1; dy++ {
+					for _, other := range grid[cell{c.x + dx, c.y + dy}] {
+						if geo.Distance(stop.point, other.point) <= radius {
+							union(stop.id, other.id)
+						}
+					}
+				}
+			}
+			grid[c] = append(grid[c], stop)
+		}
+	}
+
+	clusters := make(map[string]string, len(roots))
+	for _, stop := range roots {
+		clusters[stop.id] = find(stop.id)
+	}
+	return clusters
+}
+
+// writeStopHierarchy stores each stop's station, parent, depth and cluster
+func (i *Importer) writeStopHierarchy(batch *copyInserter, stops *stopCollector) error {
+	start := time.Now()
+	hierarchy := stops.build(i.opts.StopClusterRadius)
+
+	ids := make([]string, 0, len(hierarchy))
+	for id := range hierarchy {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	stations := make(map[string]bool)
+	clusters := make(map[string]bool)
+	for _, id := range ids {
+		h := hierarchy[id]
+		stations[h.stationID] = true
+		clusters[h.clusterID] = true
+
+		err := batch.Add(
+			id,
+			i.sourceID,
+			i.versionID,
+			h.stationID,
+			sql.NullString{String: h.parentID, Valid: h.parentID != ""},
+			stops.stops[id].locationType,
+			h.depth,
+			h.clusterID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := batch.Flush(); err != nil {
+		return fmt.Errorf("flushing %s: %w", batch.tableName, err)
+	}
+
+	i.db.Logger().Debug("Built stop hierarchy",
+		"source_id", i.sourceID,
+		"stops", len(ids),
+		"stations", len(stations),
+		"clusters", len(clusters),
+		"duration", time.Since(start))
+
+	return nil
+}
@@ -1,16 +1,18 @@
 package parser
 
 import (
-	"archive/zip"
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ptvtracker-data/internal/common/logger"
+	"github.com/ptvtracker-data/internal/gtfs-static/archive"
 	"github.com/ptvtracker-data/pkg/gtfs-static/models"
 )
 
@@ -37,20 +39,65 @@ type ParseCallbacks struct {
 	OnFileComplete func(fileName string) error
 }
 
+// handles reports whether a record callback is set for the file, so files
+// nobody consumes aren't read at all
+func (c *ParseCallbacks) handles(fileName string) bool {
+	switch fileName {
+	case "agency.txt":
+		return c.OnAgency != nil
+	case "stops.txt":
+		return c.OnStop != nil
+	case "routes.txt":
+		return c.OnRoute != nil
+	case "trips.txt":
+		return c.OnTrip != nil
+	case "stop_times.txt":
+		return c.OnStopTime != nil
+	case "calendar.txt":
+		return c.OnCalendar != nil
+	case "calendar_dates.txt":
+		return c.OnCalendarDate != nil
+	case "shapes.txt":
+		return c.OnShape != nil
+	case "levels.txt":
+		return c.OnLevel != nil
+	case "pathways.txt":
+		return c.OnPathway != nil
+	case "transfers.txt":
+		return c.OnTransfer != nil
+	}
+	return false
+}
+
+// ParseZip parses the GTFS zip at zipPath
 func (p *Parser) ParseZip(ctx context.Context, zipPath string, callbacks ParseCallbacks) error {
-	reader, err := zip.OpenReader(zipPath)
+	reader, err := archive.Open(zipPath, archive.DefaultLimits())
 	if err != nil {
-		return fmt.Errorf("opening zip file: %w", err)
+		return err
 	}
 	defer reader.Close()
 
 	p.logger.Info("Parsing GTFS zip file", "path", zipPath, "files", len(reader.File))
 
-	// Standard GTFS parsing
-	return p.parseStandardGTFS(ctx, &reader.Reader, callbacks)
+	return p.ParseFS(ctx, reader, callbacks)
 }
 
-func (p *Parser) parseStandardGTFS(ctx context.Context, reader *zip.Reader, callbacks ParseCallbacks) error {
+// ParseReader parses a GTFS zip of the given size read from r, e.g. a nested
+// zip inside another archive or one held in memory
+func (p *Parser) ParseReader(ctx context.Context, r io.ReaderAt, size int64, callbacks ParseCallbacks) error {
+	reader, err := archive.NewReader(r, size, archive.DefaultLimits())
+	if err != nil {
+		return err
+	}
+
+	p.logger.Info("Parsing GTFS zip", "size", size, "files", len(reader.File))
+
+	return p.ParseFS(ctx, reader, callbacks)
+}
+
+// ParseFS parses the GTFS files at the root of fsys, such as an opened zip,
+// an extracted feed directory (os.DirFS) or an in-memory fstest.MapFS
+func (p *Parser) ParseFS(ctx context.Context, fsys fs.FS, callbacks ParseCallbacks) error {
 	// Define parsing order for referential integrity
 	parseOrder := []string{
 		"agency.txt",
@@ -66,25 +113,32 @@ func (p *Parser) parseStandardGTFS(ctx context.Context, reader *zip.Reader, call
 		"transfers.txt",
 	}
 
-	// Create a map for quick file lookup
-	fileMap := make(map[string]*zip.File)
-	for _, file := range reader.File {
-		fileMap[file.Name] = file
-	}
-
 	// Parse files in order
 	for _, fileName := range parseOrder {
-		if file, exists := fileMap[fileName]; exists {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-				if err := p.parseFile(file, callbacks); err != nil {
-					return fmt.Errorf("parsing %s: %w", fileName, err)
-				}
-			}
-		} else {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if !callbacks.handles(fileName) {
+			p.logger.Debug("No callback for file, skipping", "file", fileName)
+			continue
+		}
+
+		file, err := fsys.Open(fileName)
+		if errors.Is(err, fs.ErrNotExist) {
 			p.logger.Debug("File not found in archive", "file", fileName)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", fileName, err)
+		}
+
+		err = p.parseFile(fileName, file, callbacks)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", fileName, err)
 		}
 	}
 
@@ -92,16 +146,12 @@ func (p *Parser) parseStandardGTFS(ctx context.Context, reader *zip.Reader, call
 	return nil
 }
 
-func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
-	p.logger.Debug("Parsing file", "name", file.Name, "size", file.UncompressedSize64)
-
-	rc, err := file.Open()
-	if err != nil {
-		return fmt.Errorf("opening file: %w", err)
+func (p *Parser) parseFile(name string, file fs.File, callbacks ParseCallbacks) error {
+	if info, err := file.Stat(); err == nil {
+		p.logger.Debug("Parsing file", "name", name, "size", info.Size())
 	}
-	defer rc.Close()
 
-	reader := csv.NewReader(rc)
+	reader := csv.NewReader(file)
 	reader.FieldsPerRecord = -1 // Variable number of fields
 	reader.TrimLeadingSpace = true
 
@@ -123,6 +173,12 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		headerMap[strings.TrimSpace(h)] = i
 	}
 
+	// Columns the parser doesn't map are carried through as extras
+	extraCols := p.extraColumns(name, headerMap)
+	if len(extraCols) > 0 {
+		p.logger.Debug("Preserving non-standard columns", "file", name, "columns", len(extraCols))
+	}
+
 	// Parse records
 	count := 0
 	for {
@@ -134,10 +190,13 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 			return fmt.Errorf("reading record: %w", err)
 		}
 
-		switch file.Name {
+		extra := p.getExtra(record, extraCols)
+
+		switch name {
 		case "agency.txt":
 			if callbacks.OnAgency != nil {
 				agency := p.parseAgency(record, headerMap)
+				agency.Extra = extra
 				if err := callbacks.OnAgency(agency); err != nil {
 					return err
 				}
@@ -145,6 +204,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		case "stops.txt":
 			if callbacks.OnStop != nil {
 				stop := p.parseStop(record, headerMap)
+				stop.Extra = extra
 				if err := callbacks.OnStop(stop); err != nil {
 					return err
 				}
@@ -152,6 +212,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		case "routes.txt":
 			if callbacks.OnRoute != nil {
 				route := p.parseRoute(record, headerMap)
+				route.Extra = extra
 				if err := callbacks.OnRoute(route); err != nil {
 					return err
 				}
@@ -159,6 +220,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		case "trips.txt":
 			if callbacks.OnTrip != nil {
 				trip := p.parseTrip(record, headerMap)
+				trip.Extra = extra
 				if err := callbacks.OnTrip(trip); err != nil {
 					return err
 				}
@@ -166,6 +228,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		case "stop_times.txt":
 			if callbacks.OnStopTime != nil {
 				stopTime := p.parseStopTime(record, headerMap)
+				stopTime.Extra = extra
 				if err := callbacks.OnStopTime(stopTime); err != nil {
 					return err
 				}
@@ -177,6 +240,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 					p.logger.Warn("Failed to parse calendar record", "error", err)
 					continue
 				}
+				calendar.Extra = extra
 				if err := callbacks.OnCalendar(calendar); err != nil {
 					return err
 				}
@@ -188,6 +252,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 					p.logger.Warn("Failed to parse calendar_date record", "error", err)
 					continue
 				}
+				calendarDate.Extra = extra
 				if err := callbacks.OnCalendarDate(calendarDate); err != nil {
 					return err
 				}
@@ -195,6 +260,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		case "shapes.txt":
 			if callbacks.OnShape != nil {
 				shape := p.parseShape(record, headerMap)
+				shape.Extra = extra
 				if err := callbacks.OnShape(shape); err != nil {
 					return err
 				}
@@ -202,6 +268,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		case "levels.txt":
 			if callbacks.OnLevel != nil {
 				level := p.parseLevel(record, headerMap)
+				level.Extra = extra
 				if err := callbacks.OnLevel(level); err != nil {
 					return err
 				}
@@ -209,6 +276,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		case "pathways.txt":
 			if callbacks.OnPathway != nil {
 				pathway := p.parsePathway(record, headerMap)
+				pathway.Extra = extra
 				if err := callbacks.OnPathway(pathway); err != nil {
 					return err
 				}
@@ -216,6 +284,7 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 		case "transfers.txt":
 			if callbacks.OnTransfer != nil {
 				transfer := p.parseTransfer(record, headerMap)
+				transfer.Extra = extra
 				if err := callbacks.OnTransfer(transfer); err != nil {
 					return err
 				}
@@ -224,15 +293,15 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 
 		count++
 		if count%10000 == 0 {
-			p.logger.Debug("Progress", "file", file.Name, "records", count)
+			p.logger.Debug("Progress", "file", name, "records", count)
 		}
 	}
 
-	p.logger.Info("File parsed", "name", file.Name, "records", count)
+	p.logger.Info("File parsed", "name", name, "records", count)
 
 	// Call file complete callback if provided
 	if callbacks.OnFileComplete != nil {
-		if err := callbacks.OnFileComplete(file.Name); err != nil {
+		if err := callbacks.OnFileComplete(name); err != nil {
 			return fmt.Errorf("file complete callback: %w", err)
 		}
 	}
@@ -240,6 +309,63 @@ func (p *Parser) parseFile(file *zip.File, callbacks ParseCallbacks) error {
 	return nil
 }
 
+// knownColumns lists the columns mapped onto model fields for each file.
+// Anything else in the header is preserved in the record's Extra map.
+var knownColumns = map[string][]string{
+	"agency.txt":         {"agency_id", "agency_name", "agency_url", "agency_timezone", "agency_lang", "agency_fare_url"},
+	"stops.txt":          {"stop_id", "stop_name", "stop_lat", "stop_lon", "location_type", "parent_station", "wheelchair_boarding", "level_id"},
+	"routes.txt":         {"route_id", "agency_id", "route_short_name", "route_long_name", "route_type", "route_color", "route_text_color"},
+	"trips.txt":          {"trip_id", "route_id", "service_id", "shape_id", "trip_headsign", "direction_id", "block_id", "wheelchair_accessible"},
+	"stop_times.txt":     {"trip_id", "stop_id", "stop_sequence", "arrival_time", "departure_time", "stop_headsign", "pickup_type", "drop_off_type", "shape_dist_traveled"},
+	"calendar.txt":       {"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"},
+	"calendar_dates.txt": {"service_id", "date", "exception_type"},
+	"shapes.txt":         {"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"},
+	"levels.txt":         {"level_id", "level_index", "level_name"},
+	"pathways.txt":       {"pathway_id", "from_stop_id", "to_stop_id", "pathway_mode", "is_bidirectional", "traversal_time"},
+	"transfers.txt":      {"from_stop_id", "to_stop_id", "from_route_id", "to_route_id", "from_trip_id", "to_trip_id", "transfer_type", "min_transfer_time"},
+}
+
+type extraColumn struct {
+	name  string
+	index int
+}
+
+// extraColumns returns the header columns of a file that aren't mapped onto model fields
+func (p *Parser) extraColumns(fileName string, headerMap map[string]int) []extraColumn {
+	known := make(map[string]bool)
+	for _, c := range knownColumns[fileName] {
+		known[c] = true
+	}
+
+	var cols []extraColumn
+	for name, idx := range headerMap {
+		if name == "" || known[name] {
+			continue
+		}
+		cols = append(cols, extraColumn{name: name, index: idx})
+	}
+	return cols
+}
+
+// getExtra collects the non-empty values of extra columns, or nil if there are none
+func (p *Parser) getExtra(record []string, cols []extraColumn) map[string]string {
+	var extra map[string]string
+	for _, c := range cols {
+		if c.index >= len(record) {
+			continue
+		}
+		value := strings.TrimSpace(record[c.index])
+		if value == "" {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]string, len(cols))
+		}
+		extra[c.name] = value
+	}
+	return extra
+}
+
 // Helper functions to safely get values from CSV records
 func (p *Parser) getString(record []string, headerMap map[string]int, field string) string {
 	if idx, ok := headerMap[field]; ok && idx < len(record) {
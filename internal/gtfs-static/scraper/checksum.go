@@ -0,0 +1,41 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// checksum is a running SHA-256, so a file can be hashed while it is written
+// rather than read back afterwards
+type checksum struct {
+	hash.Hash
+}
+
+func newChecksum() checksum {
+	return checksum{Hash: sha256.New()}
+}
+
+// String returns the hex digest of everything written so far
+func (c checksum) String() string {
+	return hex.EncodeToString(c.Sum(nil))
+}
+
+// hashFile returns the checksum of a file's contents, which can go on to
+// hash bytes appended to the file later
+func hashFile(path string) (checksum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return checksum{}, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	c := newChecksum()
+	if _, err := io.Copy(c, f); err != nil {
+		return checksum{}, fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return c, nil
+}
@@ -2,93 +2,274 @@ package scraper
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
-	
+
 	"github.com/ptvtracker-data/internal/common/logger"
 )
 
+// DownloaderConfig controls retries and limits for HTTPDownloader
+type DownloaderConfig struct {
+	MaxAttempts    int           // attempts per download, including the first
+	InitialBackoff time.Duration // wait before the first retry, doubled each time
+	MaxBackoff     time.Duration
+	IdleTimeout    time.Duration // abort an attempt when no data arrives for this long
+	MaxSize        int64         // bytes, 0 for no limit
+	ContentTypes   []string      // accepted media types, empty accepts any
+}
+
+// DefaultDownloaderConfig returns limits suited to the PTV master archive
+func DefaultDownloaderConfig() DownloaderConfig {
+	return DownloaderConfig{
+		MaxAttempts:    5,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     time.Minute,
+		IdleTimeout:    2 * time.Minute,
+		MaxSize:        2 << 30, // 2 GiB
+		ContentTypes: []string{
+			"application/zip",
+			"application/x-zip",
+			"application/x-zip-compressed",
+			"application/octet-stream",
+			"binary/octet-stream",
+		},
+	}
+}
+
+// DownloadResult describes a completed download
+type DownloadResult struct {
+	Size   int64
+	SHA256 string // hex
+}
+
+// HTTPDownloader fetches large files with retries. A failed attempt keeps its
+// partial file (destPath + ".part") and the next attempt resumes it with a
+// Range request, guarded by If-Range so a changed file starts over.
 type HTTPDownloader struct {
 	client *http.Client
+	config DownloaderConfig
 	logger logger.Logger
 }
 
 func NewHTTPDownloader(logger logger.Logger) *HTTPDownloader {
+	return NewHTTPDownloaderWithConfig(DefaultDownloaderConfig(), logger)
+}
+
+func NewHTTPDownloaderWithConfig(config DownloaderConfig, logger logger.Logger) *HTTPDownloader {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 1
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = httpTimeout
+
 	return &HTTPDownloader{
-		client: &http.Client{
-			Timeout: 5 * time.Minute, // Large files may take time
-		},
+		// No overall timeout: large files may take a long time, stalls are
+		// caught by the idle timeout instead
+		client: &http.Client{Transport: transport},
+		config: config,
 		logger: logger,
 	}
 }
 
-func (d *HTTPDownloader) Download(ctx context.Context, url string, destPath string) error {
+// permanentError marks a download failure that retrying won't fix
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(format string, args ...any) error {
+	return &permanentError{err: fmt.Errorf(format, args...)}
+}
+
+// Download fetches url to destPath. When expectedSHA256 is set the file must
+// match it; a mismatch discards the download.
+func (d *HTTPDownloader) Download(ctx context.Context, url string, destPath string, expectedSHA256 string) (*DownloadResult, error) {
 	// Ensure destination directory exists
 	destDir := filepath.Dir(destPath)
 	if err := os.MkdirAll(destDir, 0755); err != nil {
-		return fmt.Errorf("creating destination directory: %w", err)
-	}
-	
-	// Create temporary file
-	tempFile, err := os.CreateTemp(destDir, "gtfs_download_*.tmp")
-	if err != nil {
-		return fmt.Errorf("creating temp file: %w", err)
+		return nil, fmt.Errorf("creating destination directory: %w", err)
 	}
-	tempPath := tempFile.Name()
-	defer os.Remove(tempPath) // Clean up temp file on any error
-	
+
+	partPath := destPath + ".part"
 	d.logger.Info("Starting download", "url", url, "dest", destPath)
-	
-	// Create request
+
+	var result *DownloadResult
+	backoff := d.config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		var err error
+		result, err = d.attempt(ctx, url, partPath)
+		if err == nil {
+			break
+		}
+
+		var perm *permanentError
+		if errors.As(err, &perm) || ctx.Err() != nil || attempt >= d.config.MaxAttempts {
+			return nil, fmt.Errorf("downloading %s (attempt %d of %d): %w", url, attempt, d.config.MaxAttempts, err)
+		}
+
+		d.logger.Warn("Download attempt failed, retrying",
+			"url", url,
+			"attempt", attempt,
+			"backoff", backoff,
+			"error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff = min(backoff*2, d.config.MaxBackoff)
+	}
+
+	if expectedSHA256 != "" && !strings.EqualFold(result.SHA256, expectedSHA256) {
+		removePartial(partPath)
+		return nil, fmt.Errorf("checksum mismatch for %s: got %s, expected %s", url, result.SHA256, expectedSHA256)
+	}
+
+	// Move the completed file to its final destination
+	if err := os.Rename(partPath, destPath); err != nil {
+		return nil, fmt.Errorf("moving file to destination: %w", err)
+	}
+	os.Remove(validatorPath(partPath))
+
+	d.logger.Info("Download completed",
+		"url", url,
+		"dest", destPath,
+		"size_bytes", result.Size,
+		"sha256", result.SHA256)
+
+	return result, nil
+}
+
+// attempt downloads into partPath, resuming from its current size when the
+// server still has the same file
+func (d *HTTPDownloader) attempt(ctx context.Context, url, partPath string) (*DownloadResult, error) {
+	offset, validator := partialState(partPath)
+
+	// Cancel the request if the body stalls for longer than the idle timeout
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var idle *time.Timer
+	if d.config.IdleTimeout > 0 {
+		idle = time.AfterFunc(d.config.IdleTimeout, cancel)
+		defer idle.Stop()
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		tempFile.Close()
-		return fmt.Errorf("creating request: %w", err)
+		return nil, permanent("creating request: %w", err)
 	}
-	
-	// Execute request
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+
 	resp, err := d.client.Do(req)
 	if err != nil {
-		tempFile.Close()
-		return fmt.Errorf("executing request: %w", err)
+		return nil, fmt.Errorf("executing request: %w", err)
 	}
 	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		tempFile.Close()
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-	
-	// Download with progress tracking
-	written, err := d.copyWithProgress(tempFile, resp.Body, resp.ContentLength)
-	tempFile.Close()
-	
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			removePartial(partPath)
+			return nil, fmt.Errorf("server resumed at the wrong offset (%q), restarting", resp.Header.Get("Content-Range"))
+		}
+		d.logger.Info("Resuming partial download", "url", url, "offset", offset)
+	case resp.StatusCode == http.StatusOK:
+		// Full body: the server ignored the range or the file changed
+		offset = 0
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		removePartial(partPath)
+		return nil, fmt.Errorf("partial download no longer matches the server, restarting")
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	default:
+		return nil, permanent("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		return nil, err
+	}
+
+	totalSize := int64(-1)
+	if resp.ContentLength >= 0 {
+		totalSize = offset + resp.ContentLength
+	}
+	if d.config.MaxSize > 0 && totalSize > d.config.MaxSize {
+		return nil, permanent("file is %d bytes, larger than the %d byte limit", totalSize, d.config.MaxSize)
+	}
+
+	file, h, err := openPartial(partPath, offset)
 	if err != nil {
-		return fmt.Errorf("downloading file: %w", err)
+		return nil, err
 	}
-	
-	// Move temp file to final destination
-	if err := os.Rename(tempPath, destPath); err != nil {
-		return fmt.Errorf("moving file to destination: %w", err)
+	if offset == 0 {
+		saveValidator(partPath, resp.Header)
 	}
-	
-	d.logger.Info("Download completed", 
-		"url", url,
-		"dest", destPath,
-		"size_bytes", written)
-	
-	return nil
+
+	body := io.Reader(resp.Body)
+	if idle != nil {
+		body = &idleReader{r: body, timer: idle, timeout: d.config.IdleTimeout}
+	}
+	if d.config.MaxSize > 0 {
+		// One byte over the limit is enough to know it was exceeded
+		body = io.LimitReader(body, d.config.MaxSize-offset+1)
+	}
+
+	// Download with progress tracking, hashing as the bytes are written
+	written, err := d.copyWithProgress(io.MultiWriter(file, h), body, offset, totalSize)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("downloading file: %w", err)
+	}
+
+	size := offset + written
+	if d.config.MaxSize > 0 && size > d.config.MaxSize {
+		removePartial(partPath)
+		return nil, permanent("download exceeded the %d byte limit", d.config.MaxSize)
+	}
+	if totalSize >= 0 && size != totalSize {
+		return nil, fmt.Errorf("download ended at %d of %d bytes", size, totalSize)
+	}
+
+	return &DownloadResult{Size: size, SHA256: h.String()}, nil
+}
+
+func (d *HTTPDownloader) checkContentType(contentType string) error {
+	if len(d.config.ContentTypes) == 0 || contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return permanent("invalid Content-Type %q: %w", contentType, err)
+	}
+	for _, allowed := range d.config.ContentTypes {
+		if strings.EqualFold(mediaType, allowed) {
+			return nil
+		}
+	}
+	return permanent("unexpected Content-Type %q", mediaType)
 }
 
-func (d *HTTPDownloader) copyWithProgress(dst io.Writer, src io.Reader, totalSize int64) (int64, error) {
+func (d *HTTPDownloader) copyWithProgress(dst io.Writer, src io.Reader, offset, totalSize int64) (int64, error) {
 	buf := make([]byte, 32*1024) // 32KB buffer
 	var written int64
 	lastLog := time.Now()
-	
+
 	for {
 		nr, err := src.Read(buf)
 		if nr > 0 {
@@ -100,13 +281,13 @@ func (d *HTTPDownloader) copyWithProgress(dst io.Writer, src io.Reader, totalSiz
 				return written, io.ErrShortWrite
 			}
 			written += int64(nw)
-			
+
 			// Log progress every 5 seconds
 			if time.Since(lastLog) > 5*time.Second && totalSize > 0 {
-				progress := float64(written) / float64(totalSize) * 100
+				progress := float64(offset+written) / float64(totalSize) * 100
 				d.logger.Debug("Download progress",
 					"progress_percent", fmt.Sprintf("%.1f", progress),
-					"bytes_downloaded", written,
+					"bytes_downloaded", offset+written,
 					"total_bytes", totalSize)
 				lastLog = time.Now()
 			}
@@ -118,6 +299,97 @@ func (d *HTTPDownloader) copyWithProgress(dst io.Writer, src io.Reader, totalSiz
 			return written, err
 		}
 	}
-	
+
 	return written, nil
-}
\ No newline at end of file
+}
+
+// idleReader pushes back the idle timer every time data arrives
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// partialState returns the size of a resumable partial download and the
+// If-Range validator it was started with, or 0 when there is nothing to resume
+func partialState(partPath string) (int64, string) {
+	info, err := os.Stat(partPath)
+	if err != nil || info.Size() == 0 {
+		return 0, ""
+	}
+	validator, err := os.ReadFile(validatorPath(partPath))
+	if err != nil || len(validator) == 0 {
+		return 0, ""
+	}
+	return info.Size(), string(validator)
+}
+
+// openPartial opens partPath for writing at offset and returns a checksum of
+// the bytes already in it
+func openPartial(partPath string, offset int64) (*os.File, checksum, error) {
+	if offset == 0 {
+		file, err := os.Create(partPath)
+		if err != nil {
+			return nil, checksum{}, fmt.Errorf("creating partial file: %w", err)
+		}
+		return file, newChecksum(), nil
+	}
+
+	if err := os.Truncate(partPath, offset); err != nil {
+		return nil, checksum{}, fmt.Errorf("truncating partial file: %w", err)
+	}
+	h, err := hashFile(partPath)
+	if err != nil {
+		return nil, checksum{}, err
+	}
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, checksum{}, fmt.Errorf("opening partial file: %w", err)
+	}
+	return file, h, nil
+}
+
+// saveValidator records what If-Range should send when resuming: a strong
+// ETag if there is one, otherwise Last-Modified. Without either the download
+// can't be resumed safely.
+func saveValidator(partPath string, header http.Header) {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		os.Remove(validatorPath(partPath))
+		return
+	}
+	os.WriteFile(validatorPath(partPath), []byte(validator), 0644)
+}
+
+func validatorPath(partPath string) string {
+	return partPath + ".validator"
+}
+
+func removePartial(partPath string) {
+	os.Remove(partPath)
+	os.Remove(validatorPath(partPath))
+}
+
+// contentRangeStart parses the first byte position of "bytes start-end/size"
+func contentRangeStart(header string) (int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return strconv.ParseInt(start, 10, 64)
+}
@@ -0,0 +1,82 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/common/discord"
+	"github.com/ptvtracker-data/internal/gtfs-static/gates"
+)
+
+// checkActivationGates runs the gates for a fully imported version against
+// activeVersionID, which is 0 when nothing is active yet
+func (s *GTFSScheduler) checkActivationGates(ctx context.Context, job *db.ImportJob, activeVersionID int) (*gates.Result, error) {
+	validationErrors, err := s.importJobs.ValidationErrors(ctx, job.JobID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.gates.Check(ctx, gates.Input{
+		VersionID:        job.VersionID,
+		ActiveVersionID:  activeVersionID,
+		ValidationErrors: validationErrors,
+		Validated:        s.validating(),
+		Today:            serviceToday(),
+	})
+}
+
+// notifyGateFailure posts the failed gates to Discord. It is a no-op when no
+// webhook is configured.
+func (s *GTFSScheduler) notifyGateFailure(job *db.ImportJob, result *gates.Result) {
+	if s.notifier == nil {
+		return
+	}
+
+	fields := []discord.Field{
+		{Name: "Dataset", Value: s.config.Dataset, Inline: true},
+		{Name: "Version", Value: fmt.Sprintf("%d", result.VersionID), Inline: true},
+		{Name: "Active version", Value: fmt.Sprintf("%d", result.ActiveVersionID), Inline: true},
+		{Name: "Dataset modified", Value: job.LastModified.Format(time.RFC3339), Inline: true},
+	}
+	for _, g := range result.Failed() {
+		fields = append(fields, discord.Field{
+			Name:  g.Name,
+			Value: truncateField(strings.Join(g.Details, "\n")),
+		})
+	}
+
+	msg := discord.WebhookMessage{
+		Embeds: []discord.Embed{{
+			Title:       "GTFS version rejected by activation gates",
+			Description: fmt.Sprintf("Version %d was imported but left inactive. Activate it with `ptvtracker versions activate -force %d` if the change is expected.", result.VersionID, result.VersionID),
+			Color:       0xFF0000,
+			Timestamp:   time.Now(),
+			Fields:      fields,
+		}},
+	}
+
+	if err := s.notifier.SendMessage(msg); err != nil {
+		s.logger.Warn("Failed to send gate failure notification", "version_id", result.VersionID, "error", err)
+	}
+}
+
+// truncateField keeps a value within Discord's 1024 character field limit
+func truncateField(value string) string {
+	const limit = 1024
+	if len(value) <= limit {
+		return value
+	}
+	return value[:limit-4] + "\n..."
+}
+
+// serviceToday returns today's date in Melbourne, where the feed's service days are defined
+func serviceToday() time.Time {
+	loc, err := time.LoadLocation("Australia/Melbourne")
+	if err != nil {
+		loc = time.UTC
+	}
+	return time.Now().In(loc)
+}
@@ -13,11 +13,11 @@ type MetadataFetcher interface {
 
 type VersionChecker interface {
 	GetActiveVersion(ctx context.Context) (*models.VersionInfo, error)
-	HasNewerVersion(ctx context.Context, lastModified time.Time) (bool, error)
+	HasNewRelease(ctx context.Context, lastModified time.Time) (bool, error)
 }
 
 type Downloader interface {
-	Download(ctx context.Context, url string, destPath string) error
+	Download(ctx context.Context, url string, destPath string, expectedSHA256 string) (*DownloadResult, error)
 }
 
 type Importer interface {
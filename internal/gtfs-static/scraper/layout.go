@@ -0,0 +1,185 @@
+package scraper
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/discord"
+)
+
+// DefaultNestedPattern matches the per-source zips inside the PTV master
+// archive, e.g. "1/google_transit.zip"
+const DefaultNestedPattern = `^(\d+)/google_transit\.zip$`
+
+// Layout describes where the per-source zips sit in a master archive and
+// which transport source each one is loaded as
+type Layout struct {
+	// Pattern matches a nested zip's path; its first capture group is the
+	// folder key
+	Pattern *regexp.Regexp
+
+	// Sources maps folder keys to a source ID or source_name. A key without
+	// a mapping is used as the source ID if it is numeric.
+	Sources map[string]string
+
+	// Include, when set, lists the only folder keys imported; Exclude lists
+	// keys that are skipped
+	Include []string
+	Exclude []string
+}
+
+// DefaultLayout returns the PTV layout, with folder numbers as source IDs
+func DefaultLayout() Layout {
+	return Layout{Pattern: regexp.MustCompile(DefaultNestedPattern)}
+}
+
+// NewLayout builds a layout from its configured pattern, which must have a
+// capture group for the folder key
+func NewLayout(pattern string, sources map[string]string, include, exclude []string) (Layout, error) {
+	if pattern == "" {
+		pattern = DefaultNestedPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Layout{}, fmt.Errorf("invalid nested zip pattern %q: %w", pattern, err)
+	}
+	if re.NumSubexp() < 1 {
+		return Layout{}, fmt.Errorf("nested zip pattern %q needs a capture group for the folder key", pattern)
+	}
+
+	return Layout{Pattern: re, Sources: sources, Include: include, Exclude: exclude}, nil
+}
+
+// NestedEntry is a nested zip the layout resolved to a source
+type NestedEntry struct {
+	File     *zip.File
+	Key      string
+	SourceID int
+}
+
+// Resolve finds the nested zips in files. knownSources maps source names to
+// IDs and lists every registered source ID; with nil, every numeric key is
+// accepted. Unknown lists the zips that could not be mapped to a registered
+// source, including zips that don't match the pattern at all and a second
+// zip mapped to the same source.
+func (l Layout) Resolve(files []*zip.File, knownSources map[string]int) (entries []NestedEntry, unknown []string) {
+	if l.Pattern == nil {
+		l = DefaultLayout()
+	}
+
+	seen := make(map[int]bool)
+	registered := make(map[int]bool, len(knownSources))
+	for _, id := range knownSources {
+		registered[id] = true
+	}
+
+	for _, file := range files {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		key, matched := l.Match(file.Name)
+		if !matched {
+			if strings.EqualFold(path.Ext(file.Name), ".zip") {
+				unknown = append(unknown, file.Name)
+			}
+			continue
+		}
+		if !l.included(key) {
+			continue
+		}
+
+		sourceID, ok := l.sourceID(key, knownSources)
+		if !ok || (knownSources != nil && !registered[sourceID]) || seen[sourceID] {
+			unknown = append(unknown, file.Name)
+			continue
+		}
+		seen[sourceID] = true
+
+		entries = append(entries, NestedEntry{File: file, Key: key, SourceID: sourceID})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SourceID < entries[j].SourceID })
+	return entries, unknown
+}
+
+// Match returns the folder key of a nested zip path, whether or not the key
+// is included
+func (l Layout) Match(name string) (string, bool) {
+	if l.Pattern == nil {
+		l = DefaultLayout()
+	}
+	matches := l.Pattern.FindStringSubmatch(name)
+	if len(matches) < 2 {
+		return "", false
+	}
+	return matches[1], true
+}
+
+func (l Layout) included(key string) bool {
+	for _, k := range l.Exclude {
+		if k == key {
+			return false
+		}
+	}
+	if len(l.Include) == 0 {
+		return true
+	}
+	for _, k := range l.Include {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceID maps a folder key to a source ID through Sources, falling back to
+// the key itself when it is numeric
+func (l Layout) sourceID(key string, knownSources map[string]int) (int, bool) {
+	target, mapped := l.Sources[key]
+	if !mapped {
+		target = key
+	}
+
+	if id, err := strconv.Atoi(target); err == nil {
+		return id, true
+	}
+	if !mapped {
+		return 0, false
+	}
+	id, ok := knownSources[target]
+	return id, ok
+}
+
+// notifyUnknownSources posts nested zips that weren't imported because the
+// layout doesn't map them, so a new mode in the feed isn't missed. It is a
+// no-op when no webhook is configured.
+func (s *GTFSScheduler) notifyUnknownSources(release *Release, unknown []string) {
+	if s.notifier == nil || len(unknown) == 0 {
+		return
+	}
+
+	msg := discord.WebhookMessage{
+		Embeds: []discord.Embed{{
+			Title:       "Unknown sources in GTFS archive",
+			Description: "These nested zips were not imported because the archive layout doesn't map them to a transport source. Register the source and map its folder with GTFS_STATIC_NESTED_SOURCES, or exclude it.",
+			Color:       0xFFA500,
+			Timestamp:   time.Now(),
+			Fields: []discord.Field{
+				{Name: "Dataset", Value: s.config.Dataset, Inline: true},
+				{Name: "Dataset modified", Value: release.LastModified.Format(time.RFC3339), Inline: true},
+				{Name: "Files", Value: truncateField(strings.Join(unknown, "\n"))},
+			},
+		}},
+	}
+
+	if err := s.notifier.SendMessage(msg); err != nil {
+		s.logger.Warn("Failed to send unknown source notification", "error", err)
+	}
+}
@@ -1,28 +1,29 @@
 package scraper
 
 import (
-	"archive/zip"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"sync"
 	"time"
 
 	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/common/discord"
 	"github.com/ptvtracker-data/internal/common/logger"
 	"github.com/ptvtracker-data/internal/common/maintenance"
-	"github.com/ptvtracker-data/internal/gtfs-static/importer"
+	"github.com/ptvtracker-data/internal/gtfs-static/archive"
+	"github.com/ptvtracker-data/internal/gtfs-static/gates"
 )
 
 type GTFSScheduler struct {
 	config          Config
-	metadataFetcher MetadataFetcher
+	source          StaticSource
 	versionChecker  *db.VersionChecker
-	downloader      Downloader
+	importJobs      *db.ImportJobTracker
+	gates           *gates.Checker
+	notifier        *discord.Client
 	database        *db.DB
 	logger          logger.Logger
 	maintenance     *maintenance.Maintenance
@@ -33,26 +34,46 @@ type GTFSScheduler struct {
 	running bool
 }
 
-// Config for GTFSScheduler: single-source, URL-based
+// Config for GTFSScheduler; the dataset location comes from the StaticSource
 type Config struct {
-	URL           string
-	CheckInterval time.Duration
-	DownloadDir   string
+	Dataset             string // named dataset the versions belong to, db.DefaultDataset when empty
+	CheckInterval       time.Duration
+	DownloadDir         string
+	Validation          ValidationMode
+	ValidationReportDir string
+	CopyBatchSize       int
+	ImportParallelism   int
+	MaterializeDays     int
+	ShapeTolerances     []float64 // metres; see importer.Options, empty skips shape geometries
+	StopClusterRadius   float64   // metres; see importer.Options
+	Layout              Layout // where the per-source zips are in the master archive, DefaultLayout when unset
+	Gates               gates.Config
+	NotifyWebhookURL    string // Discord webhook for rejected versions, optional
 }
 
 func NewScheduler(
 	config Config,
 	database *db.DB,
 	logger logger.Logger,
-	metadataFetcher MetadataFetcher, // Use the interface
-	downloader Downloader, // Use the interface
+	source StaticSource,
 	cleanupScheduler *maintenance.CleanupScheduler, // Optional cleanup coordination
 ) *GTFSScheduler {
+	if config.Dataset == "" {
+		config.Dataset = db.DefaultDataset
+	}
+
+	var notifier *discord.Client
+	if config.NotifyWebhookURL != "" {
+		notifier = discord.NewClient(config.NotifyWebhookURL)
+	}
+
 	return &GTFSScheduler{
 		config:           config,
-		metadataFetcher:  metadataFetcher,
-		versionChecker:   db.NewVersionChecker(database),
-		downloader:       downloader,
+		source:           source,
+		versionChecker:   db.NewDatasetVersionChecker(database, config.Dataset),
+		importJobs:       db.NewImportJobTracker(database),
+		gates:            gates.New(database, logger, config.Gates),
+		notifier:         notifier,
 		database:         database,
 		logger:           logger,
 		maintenance:      maintenance.New(database, logger),
@@ -73,7 +94,8 @@ func (s *GTFSScheduler) Start(ctx context.Context) error {
 	s.mu.Unlock()
 
 	s.logger.Info("Starting GTFS scheduler",
-		"url", s.config.URL,
+		"dataset", s.config.Dataset,
+		"source", s.source,
 		"check_interval", s.config.CheckInterval)
 
 	// Initial check
@@ -115,138 +137,265 @@ func (s *GTFSScheduler) Stop() error {
 }
 
 func (s *GTFSScheduler) checkAndUpdate(ctx context.Context) error {
-	s.logger.Debug("Checking for GTFS updates", "url", s.config.URL)
+	s.logger.Debug("Checking for GTFS updates", "dataset", s.config.Dataset, "source", s.source)
 
-	// Fetch metadata using URL
-	metadata, err := s.metadataFetcher.FetchMetadata(ctx, s.config.URL)
+	release, err := s.source.Check(ctx)
 	if err != nil {
-		return fmt.Errorf("fetching metadata: %w", err)
+		return fmt.Errorf("checking source: %w", err)
+	}
+
+	// A pinned active version holds the current data in place until unpinned
+	activeVersion, err := s.versionChecker.GetActiveVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("getting active version: %w", err)
+	}
+	if activeVersion != nil && activeVersion.IsPinned {
+		s.logger.Info("Active version is pinned, skipping automatic update",
+			"version_id", activeVersion.VersionID,
+			"dataset_modified", release.LastModified)
+		return nil
 	}
 
 	// Check if we have a newer version
-	hasNewer, err := s.versionChecker.HasNewerVersion(ctx, metadata.LastModified.Time)
+	hasNew, err := s.versionChecker.HasNewRelease(ctx, release.LastModified)
 	if err != nil {
 		return fmt.Errorf("checking version: %w", err)
 	}
 
-	if !hasNewer {
+	if !hasNew {
 		s.logger.Debug("No new version available")
 		return nil
 	}
 
+	// A dataset rejected by the activation gates stays rejected until a newer one is published
+	rejected, err := s.importJobs.IsRejected(ctx, release.URL, release.LastModified)
+	if err != nil {
+		return err
+	}
+	if rejected {
+		s.logger.Debug("Dataset was rejected by activation gates, waiting for a newer one",
+			"last_modified", release.LastModified)
+		return nil
+	}
+
 	s.logger.Info("New version detected, starting import process",
-		"last_modified", metadata.LastModified.Time)
+		"dataset", s.config.Dataset,
+		"last_modified", release.LastModified)
 
 	// Download the file
 	downloadPath := filepath.Join(
 		s.config.DownloadDir,
 		fmt.Sprintf("gtfs_%s.zip",
-			metadata.LastModified.Time.Format("20060102_150405")),
+			release.LastModified.Format("20060102_150405")),
 	)
 
-	if err := s.downloader.Download(ctx, metadata.URL, downloadPath); err != nil {
-		return fmt.Errorf("downloading file: %w", err)
+	// Partial downloads of superseded releases will never be resumed
+	removeStalePartials(s.config.DownloadDir, downloadPath)
+
+	download, err := s.source.Fetch(ctx, release, downloadPath)
+	if err != nil {
+		return fmt.Errorf("fetching release: %w", err)
 	}
 	defer os.Remove(downloadPath) // Clean up after import
 
-	// Create new version
+	// A re-upload of the active version's content only moves its release timestamp
+	archiveSum := download.SHA256
+	if activeVersion != nil {
+		activeSum, err := s.versionChecker.ArchiveChecksum(ctx, activeVersion.VersionID)
+		if err != nil {
+			return err
+		}
+		if activeSum == archiveSum {
+			s.logger.Info("Dataset content unchanged, skipping import",
+				"version_id", activeVersion.VersionID,
+				"last_modified", release.LastModified,
+				"sha256", archiveSum)
+			return s.versionChecker.RecordUnchangedRelease(ctx, activeVersion.VersionID, release.URL, release.LastModified)
+		}
+	}
+
+	// Resume an unfinished import of this dataset, or create a new version for it
 	versionName := fmt.Sprintf("gtfs_%s",
-		metadata.LastModified.Time.Format("2006-01-02_15:04:05"))
+		release.LastModified.Format("2006-01-02_15:04:05"))
+	if s.config.Dataset != db.DefaultDataset {
+		versionName = s.config.Dataset + "_" + versionName
+	}
 
-	versionID, err := s.versionChecker.CreateNewVersion(
-		ctx,
-		versionName,
-		metadata.URL,
-		metadata.LastModified.Time,
-	)
+	job, completedSources, err := s.prepareImportJob(ctx, versionName, release.URL, release.LastModified)
 	if err != nil {
-		return fmt.Errorf("creating version: %w", err)
+		return err
 	}
+	versionID := job.VersionID
 
-	// Create a temporary directory for nested zips
+	if err := s.versionChecker.SetArchiveChecksum(ctx, versionID, archiveSum); err != nil {
+		return err
+	}
+
+	// Create a temporary directory for nested zips that can't be read in place
 	tempExtractDir, err := os.MkdirTemp(s.config.DownloadDir, "gtfs-extract-*")
 	if err != nil {
 		return fmt.Errorf("creating temp extraction directory: %w", err)
 	}
 	defer os.RemoveAll(tempExtractDir)
 
-	s.logger.Info("Extracting and importing from master zip", "path", downloadPath)
+	s.logger.Info("Reading and importing from master zip", "path", downloadPath)
 
-	// Open the master zip file
-	zipReader, err := zip.OpenReader(downloadPath)
+	// Open the master zip file, rejecting it if it breaks the archive limits
+	zipReader, err := archive.Open(downloadPath, archive.DefaultLimits())
 	if err != nil {
-		return fmt.Errorf("opening master zip file: %w", err)
+		err = fmt.Errorf("opening master zip file: %w", err)
+		s.finishImportJob(job, db.JobFailed, err)
+		return err
 	}
 	defer zipReader.Close()
 
-	// Regex to find nested zips, e.g., "1/google_transit.zip"
-	nestedZipRegex := regexp.MustCompile(`^(\d+)/google_transit\.zip$`)
-
-	// Loop through all files in the master zip to find and import sources
-	for _, file := range zipReader.File {
-		if file.FileInfo().IsDir() {
-			continue
-		}
+	// Map the nested zips to transport sources; anything unmapped is skipped and reported
+	knownSources, err := s.versionChecker.TransportSources(ctx)
+	if err != nil {
+		return err
+	}
+	entries, unknown := s.config.Layout.Resolve(zipReader.File, knownSources)
+	if len(unknown) > 0 {
+		s.logger.Warn("Skipping nested zips not mapped to a transport source",
+			"dataset", s.config.Dataset,
+			"files", unknown)
+		s.notifyUnknownSources(release, unknown)
+	}
 
-		matches := nestedZipRegex.FindStringSubmatch(file.Name)
-		if len(matches) < 2 {
-			continue
+	// Open every source first; the imports then run concurrently
+	var sources []nestedSource
+	var tempFiles []io.Closer
+	defer func() {
+		for _, f := range tempFiles {
+			f.Close()
 		}
+	}()
+	for _, entry := range entries {
+		s.logger.Info("Found source to import", "source_id", entry.SourceID, "folder", entry.Key, "file", entry.File.Name)
 
-		sourceID, err := strconv.Atoi(matches[1])
+		src, err := openNestedSource(zipReader, entry.File, entry.SourceID, tempExtractDir)
 		if err != nil {
-			s.logger.Warn("Could not parse source ID from path, skipping", "path", file.Name, "error", err)
-			continue
+			return err
 		}
+		sources = append(sources, src)
+		tempFiles = append(tempFiles, src.closer)
+	}
 
-		s.logger.Info("Found source to import", "source_id", sourceID, "file", file.Name)
-
-		// Extract the nested zip to a temporary file
-		nestedZipPath := filepath.Join(tempExtractDir, fmt.Sprintf("source_%d.zip", sourceID))
-
-		nestedZipFile, err := os.Create(nestedZipPath)
-		if err != nil {
-			return fmt.Errorf("creating temp file for source %d: %w", sourceID, err)
+	// A source registered to another dataset would be imported into the wrong
+	// dataset's version and left out of its realtime lookups
+	datasetSources, err := s.versionChecker.DatasetSources(ctx)
+	if err != nil {
+		return err
+	}
+	for _, src := range sources {
+		if !datasetSources[src.sourceID] {
+			err := fmt.Errorf("source %d is not registered to dataset %s", src.sourceID, s.config.Dataset)
+			s.finishImportJob(job, db.JobFailed, err)
+			return err
 		}
+	}
 
-		rc, err := file.Open()
-		if err != nil {
-			nestedZipFile.Close()
-			return fmt.Errorf("opening nested zip for source %d: %w", sourceID, err)
+	// Sources checkpointed by an earlier attempt are already in the version
+	if len(completedSources) > 0 {
+		remaining := sources[:0]
+		for _, src := range sources {
+			if completedSources[src.sourceID] {
+				s.logger.Info("Skipping source completed by earlier attempt", "source_id", src.sourceID, "version_id", versionID)
+				continue
+			}
+			remaining = append(remaining, src)
 		}
+		sources = remaining
+	}
 
-		_, err = io.Copy(nestedZipFile, rc)
-		rc.Close()
-		nestedZipFile.Close()
+	// Sources whose zip matches the active version's are copied rather than re-imported
+	if activeVersion != nil {
+		activeSums, err := s.versionChecker.SourceChecksums(ctx, activeVersion.VersionID)
 		if err != nil {
-			return fmt.Errorf("extracting nested zip for source %d: %w", sourceID, err)
+			return err
 		}
-
-		// Lock cleanup operations during import
-		if s.cleanupScheduler != nil {
-			s.cleanupScheduler.LockForImport()
-			defer s.cleanupScheduler.UnlockAfterImport()
+		for i := range sources {
+			if sum, ok := activeSums[sources[i].sourceID]; ok && sum == sources[i].sha256 {
+				sources[i].copyFrom = activeVersion.VersionID
+				s.logger.Info("Source unchanged, copying from active version",
+					"source_id", sources[i].sourceID,
+					"active_version_id", activeVersion.VersionID)
+			}
 		}
+	}
 
-		// Import the data for this source
-		s.logger.Info("Starting import for source", "source_id", sourceID, "version_id", versionID)
-		imp := importer.NewImporter(s.database, sourceID, versionID)
-		if err := imp.Import(ctx, nestedZipPath); err != nil {
-			s.logger.Error("Import failed for source, version will remain inactive",
-				"source_id", sourceID,
+	// Lock cleanup operations once for the whole import
+	if s.cleanupScheduler != nil {
+		s.cleanupScheduler.LockForImport()
+		defer s.cleanupScheduler.UnlockAfterImport()
+	}
+
+	s.logger.Info("Importing sources",
+		"version_id", versionID,
+		"sources", len(sources),
+		"parallelism", s.config.ImportParallelism)
+
+	results := s.importSources(ctx, job, sources)
+	for _, r := range results {
+		s.logger.Info("Source import result",
+			"source_id", r.SourceID,
+			"status", r.Status,
+			"duration", r.Duration)
+	}
+	if err := sourceErrors(results); err != nil {
+		err = fmt.Errorf("importing sources for version %d: %w", versionID, err)
+		s.finishImportJob(job, db.JobFailed, err)
+		return err
+	}
+
+	// The active version may have been pinned while the import ran. Leaving
+	// the job resumable means it activates without reloading once unpinned.
+	current, err := s.versionChecker.GetActiveVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("getting active version: %w", err)
+	}
+	if current != nil && current.IsPinned {
+		err := fmt.Errorf("active version %d is pinned", current.VersionID)
+		s.logger.Warn("Imported version left inactive because the active version is pinned",
+			"version_id", versionID,
+			"pinned_version_id", current.VersionID)
+		s.finishImportJob(job, db.JobFailed, err)
+		return nil
+	}
+
+	// Sanity-check the new version against the active one before switching over
+	activeID := 0
+	if current != nil {
+		activeID = current.VersionID
+	}
+	gateResult, err := s.checkActivationGates(ctx, job, activeID)
+	if err != nil {
+		err = fmt.Errorf("checking activation gates: %w", err)
+		s.finishImportJob(job, db.JobFailed, err)
+		return err
+	}
+	if gateErr := gateResult.Error(); gateErr != nil {
+		for _, g := range gateResult.Failed() {
+			s.logger.Warn("Activation gate failed",
 				"version_id", versionID,
-				"error", err)
-			return fmt.Errorf("importing data for source %d: %w", sourceID, err)
+				"gate", g.Name,
+				"details", g.Details)
 		}
-		s.logger.Info("Successfully imported source", "source_id", sourceID)
+		s.finishImportJob(job, db.JobRejected, gateErr)
+		s.notifyGateFailure(job, gateResult)
+		return gateErr
 	}
 
 	// Activate the new version
 	if err := s.versionChecker.ActivateVersion(ctx, versionID); err != nil {
-		return fmt.Errorf("activating version: %w", err)
+		err = fmt.Errorf("activating version: %w", err)
+		s.finishImportJob(job, db.JobFailed, err)
+		return err
 	}
+	s.finishImportJob(job, db.JobCompleted, nil)
 
 	s.logger.Info("Successfully imported and activated new GTFS data",
+		"dataset", s.config.Dataset,
 		"version_id", versionID,
 		"version_name", versionName)
 
@@ -260,3 +409,13 @@ func (s *GTFSScheduler) checkAndUpdate(ctx context.Context) error {
 
 	return nil
 }
+
+// removeStalePartials deletes partial downloads in dir other than keep's
+func removeStalePartials(dir, keep string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.part*"))
+	for _, path := range matches {
+		if path != keep+".part" && path != validatorPath(keep+".part") {
+			os.Remove(path)
+		}
+	}
+}
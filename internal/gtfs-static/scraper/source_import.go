@@ -0,0 +1,277 @@
+package scraper
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/gtfs-static/archive"
+	"github.com/ptvtracker-data/internal/gtfs-static/importer"
+)
+
+// SourceStatus is the import state of one source within a version
+type SourceStatus string
+
+const (
+	SourcePending   SourceStatus = "pending"
+	SourceRunning   SourceStatus = "running"
+	SourceSucceeded SourceStatus = "succeeded"
+	SourceFailed    SourceStatus = "failed"
+)
+
+// nestedSource is a per-source zip inside the master archive
+type nestedSource struct {
+	sourceID int
+	data     io.ReaderAt // the nested zip, in place in the master file or in a temp file
+	size     int64
+	closer   io.Closer // closes the temp file, if one was needed
+	sha256   string
+	copyFrom int // version to copy the unchanged source from, 0 to import it
+}
+
+// openNestedSource opens a source's nested zip in place where possible (see
+// archive.Reader.OpenNested), hashing it on the way to spot sources unchanged
+// since the active version
+func openNestedSource(master *archive.Reader, file *zip.File, sourceID int, tempDir string) (nestedSource, error) {
+	h := newChecksum()
+	nested, err := master.OpenNested(file, filepath.Join(tempDir, fmt.Sprintf("source_%d.zip", sourceID)), h)
+	if err != nil {
+		return nestedSource{}, fmt.Errorf("opening nested zip for source %d: %w", sourceID, err)
+	}
+
+	return nestedSource{
+		sourceID: sourceID,
+		data:     nested,
+		size:     nested.Size,
+		closer:   nested,
+		sha256:   h.String(),
+	}, nil
+}
+
+// SourceResult records how a single source import went
+type SourceResult struct {
+	SourceID int
+	Status   SourceStatus
+	Err      error
+	Duration time.Duration
+}
+
+// importSources validates and imports each source into versionID, running up
+// to ImportParallelism sources at once. Every source runs to completion so
+// each gets its own status; the version is only usable if all succeeded.
+func (s *GTFSScheduler) importSources(ctx context.Context, job *db.ImportJob, sources []nestedSource) []*SourceResult {
+	versionID := job.VersionID
+
+	parallelism := s.config.ImportParallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	results := make([]*SourceResult, len(sources))
+	for idx, src := range sources {
+		results[idx] = &SourceResult{SourceID: src.sourceID, Status: SourcePending}
+	}
+
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+
+	for idx, src := range sources {
+		wg.Add(1)
+		go func(result *SourceResult, src nestedSource) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				result.Status = SourceFailed
+				result.Err = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			result.Status = SourceRunning
+			start := time.Now()
+			err := s.importSource(ctx, job, src)
+			result.Duration = time.Since(start)
+
+			if err != nil {
+				result.Status = SourceFailed
+				result.Err = err
+				s.logger.Error("Import failed for source, version will remain inactive",
+					"source_id", src.sourceID,
+					"version_id", versionID,
+					"duration", result.Duration,
+					"error", err)
+				return
+			}
+
+			result.Status = SourceSucceeded
+			s.logger.Info("Successfully imported source",
+				"source_id", src.sourceID,
+				"duration", result.Duration)
+		}(results[idx], src)
+	}
+
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].SourceID < results[j].SourceID })
+	return results
+}
+
+func (s *GTFSScheduler) importSource(ctx context.Context, job *db.ImportJob, src nestedSource) (err error) {
+	versionID := job.VersionID
+
+	if err := s.importJobs.StartSource(ctx, job.JobID, src.sourceID); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if ferr := s.importJobs.FailSource(context.Background(), job.JobID, src.sourceID, err); ferr != nil {
+				s.logger.Warn("Failed to record source failure", "source_id", src.sourceID, "error", ferr)
+			}
+		}
+	}()
+
+	if src.copyFrom != 0 {
+		return s.copySource(ctx, job, src)
+	}
+
+	// Validate before touching the database; strict mode leaves the version inactive
+	if err := s.validateSource(ctx, job, src); err != nil {
+		return err
+	}
+
+	s.logger.Info("Starting import for source", "source_id", src.sourceID, "version_id", versionID)
+	imp := importer.NewImporter(s.database, src.sourceID, versionID, importer.Options{
+		BatchSize:         s.config.CopyBatchSize,
+		MaterializeDays:   s.config.MaterializeDays,
+		MaterializeFrom:   serviceToday(),
+		ShapeTolerances:   s.config.ShapeTolerances,
+		StopClusterRadius: s.config.StopClusterRadius,
+		OnFileComplete: func(tx *sql.Tx, fileName string, rows int) error {
+			return s.importJobs.RecordFile(ctx, tx, job.JobID, src.sourceID, fileName, rows)
+		},
+		// The checkpoint commits with the data, so a restart never re-imports a loaded source
+		BeforeCommit: func(tx *sql.Tx) error {
+			if err := s.versionChecker.RecordSourceChecksum(ctx, tx, versionID, src.sourceID, src.sha256, 0); err != nil {
+				return err
+			}
+			return s.importJobs.CompleteSource(ctx, tx, job.JobID, src.sourceID)
+		},
+	})
+	if err := imp.ImportReader(ctx, src.data, src.size); err != nil {
+		return fmt.Errorf("importing data for source %d: %w", src.sourceID, err)
+	}
+	return nil
+}
+
+// copySource loads a source whose zip is unchanged by copying its rows from
+// an earlier version. The copy skips validation, since the content already
+// passed it, and re-materialises departure instants from today.
+func (s *GTFSScheduler) copySource(ctx context.Context, job *db.ImportJob, src nestedSource) error {
+	tx, err := s.database.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := s.versionChecker.CopySource(ctx, tx, src.copyFrom, job.VersionID, src.sourceID)
+	if err != nil {
+		return err
+	}
+
+	if s.config.MaterializeDays > 0 {
+		var instants int64
+		err := tx.QueryRowContext(ctx, "SELECT gtfs.materialize_departures($1, $2, $3::date, $4)",
+			job.VersionID, src.sourceID, serviceToday().Format("2006-01-02"), s.config.MaterializeDays).Scan(&instants)
+		if err != nil {
+			return fmt.Errorf("materialising departures for source %d: %w", src.sourceID, err)
+		}
+	}
+
+	if err := s.versionChecker.RecordSourceChecksum(ctx, tx, job.VersionID, src.sourceID, src.sha256, src.copyFrom); err != nil {
+		return err
+	}
+	if err := s.importJobs.CompleteSource(ctx, tx, job.JobID, src.sourceID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing copy of source %d: %w", src.sourceID, err)
+	}
+
+	s.logger.Info("Copied unchanged source",
+		"source_id", src.sourceID,
+		"from_version_id", src.copyFrom,
+		"version_id", job.VersionID,
+		"rows", rows)
+	return nil
+}
+
+// prepareImportJob resumes the latest unfinished job for the dataset, or
+// creates a new version and job. It returns the sources already completed.
+func (s *GTFSScheduler) prepareImportJob(ctx context.Context, versionName, sourceURL string, lastModified time.Time) (*db.ImportJob, map[int]bool, error) {
+	job, err := s.importJobs.FindResumableJob(ctx, sourceURL, lastModified)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if job != nil {
+		completed, err := s.importJobs.CompletedSources(ctx, job.JobID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := s.importJobs.ResumeJob(ctx, job.JobID); err != nil {
+			return nil, nil, err
+		}
+
+		s.logger.Info("Resuming unfinished import",
+			"job_id", job.JobID,
+			"version_id", job.VersionID,
+			"previous_status", job.Status,
+			"completed_sources", len(completed))
+		return job, completed, nil
+	}
+
+	versionID, err := s.versionChecker.CreateNewVersion(ctx, versionName, sourceURL, lastModified)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating version: %w", err)
+	}
+
+	job, err = s.importJobs.CreateJob(ctx, versionID, sourceURL, lastModified)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return job, nil, nil
+}
+
+// finishImportJob records the job outcome; it uses a fresh context so the
+// status is still written when the import was cancelled
+func (s *GTFSScheduler) finishImportJob(job *db.ImportJob, status string, jobErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.importJobs.FinishJob(ctx, job.JobID, status, jobErr); err != nil {
+		s.logger.Warn("Failed to record import job status", "job_id", job.JobID, "status", status, "error", err)
+	}
+}
+
+// sourceErrors joins the errors of failed sources, or returns nil if all succeeded
+func sourceErrors(results []*SourceResult) error {
+	var errs []error
+	for _, r := range results {
+		if r.Status == SourceFailed {
+			errs = append(errs, fmt.Errorf("source %d: %w", r.SourceID, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,320 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/logger"
+)
+
+// StaticSourceType selects where the scheduler gets GTFS archives from
+type StaticSourceType string
+
+const (
+	SourceCKAN      StaticSourceType = "ckan"      // data.vic CKAN resource page
+	SourceHTTP      StaticSourceType = "http"      // plain URL, versioned by Last-Modified/ETag
+	SourceFile      StaticSourceType = "file"      // a single local zip
+	SourceDirectory StaticSourceType = "directory" // newest zip in a local directory
+)
+
+// Release identifies one published version of a dataset. URL and
+// LastModified together identify the dataset for versions and import jobs.
+type Release struct {
+	URL          string
+	LastModified time.Time
+	ETag         string
+	SHA256       string // expected archive checksum, when the source publishes one
+}
+
+// StaticSource finds the current release of a dataset and fetches it
+type StaticSource interface {
+	// Check returns the release currently published by the source
+	Check(ctx context.Context) (*Release, error)
+	// Fetch writes the release's archive to destPath; the scheduler deletes
+	// destPath after importing, so local sources must copy
+	Fetch(ctx context.Context, release *Release, destPath string) (*DownloadResult, error)
+	// String describes the source for logs
+	String() string
+}
+
+// NewStaticSource builds the source for kind, reading from location (a URL or
+// path); downloads for remote sources use downloads
+func NewStaticSource(kind StaticSourceType, location string, downloads DownloaderConfig, logger logger.Logger) (StaticSource, error) {
+	switch kind {
+	case SourceCKAN, "":
+		return NewCKANSource(location, NewHTTPMetadataFetcher(logger), NewHTTPDownloaderWithConfig(downloads, logger)), nil
+	case SourceHTTP:
+		return NewHTTPSource(location, NewHTTPDownloaderWithConfig(downloads, logger), logger), nil
+	case SourceFile:
+		return NewFileSource(location), nil
+	case SourceDirectory:
+		return NewDirectorySource(location, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown static source type %q", kind)
+	}
+}
+
+// CKANSource reads release metadata from the CKAN resource_show API
+type CKANSource struct {
+	resourceURL     string
+	metadataFetcher MetadataFetcher
+	downloader      Downloader
+}
+
+func NewCKANSource(resourceURL string, metadataFetcher MetadataFetcher, downloader Downloader) *CKANSource {
+	return &CKANSource{
+		resourceURL:     resourceURL,
+		metadataFetcher: metadataFetcher,
+		downloader:      downloader,
+	}
+}
+
+func (s *CKANSource) Check(ctx context.Context) (*Release, error) {
+	metadata, err := s.metadataFetcher.FetchMetadata(ctx, s.resourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching metadata: %w", err)
+	}
+	return &Release{
+		URL:          metadata.URL,
+		LastModified: metadata.LastModified.Time,
+		SHA256:       metadata.SHA256(),
+	}, nil
+}
+
+func (s *CKANSource) Fetch(ctx context.Context, release *Release, destPath string) (*DownloadResult, error) {
+	return s.downloader.Download(ctx, release.URL, destPath, release.SHA256)
+}
+
+func (s *CKANSource) String() string {
+	return "ckan:" + s.resourceURL
+}
+
+// HTTPSource polls a plain URL with conditional HEAD requests. A server that
+// sends an ETag but no Last-Modified is treated as changed when the ETag
+// changes, and on the first check after a restart.
+type HTTPSource struct {
+	url        string
+	client     *http.Client
+	downloader Downloader
+	logger     logger.Logger
+
+	mu   sync.Mutex
+	last *Release
+}
+
+func NewHTTPSource(url string, downloader Downloader, logger logger.Logger) *HTTPSource {
+	return &HTTPSource{
+		url:        url,
+		client:     &http.Client{Timeout: httpTimeout},
+		downloader: downloader,
+		logger:     logger,
+	}
+}
+
+func (s *HTTPSource) Check(ctx context.Context) (*Release, error) {
+	s.mu.Lock()
+	last := s.last
+	s.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if last != nil {
+		if last.ETag != "" {
+			req.Header.Set("If-None-Match", last.ETag)
+		}
+		req.Header.Set("If-Modified-Since", last.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("checking %s: %w", s.url, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && last != nil {
+		return last, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("checking %s: unexpected status code %d", s.url, resp.StatusCode)
+	}
+
+	release := &Release{URL: s.url, ETag: resp.Header.Get("ETag")}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		t, err := http.ParseTime(lm)
+		if err != nil {
+			return nil, fmt.Errorf("parsing Last-Modified %q: %w", lm, err)
+		}
+		release.LastModified = t
+	} else {
+		if release.ETag == "" {
+			return nil, fmt.Errorf("%s sends neither Last-Modified nor ETag", s.url)
+		}
+		if last != nil && last.ETag == release.ETag {
+			return last, nil
+		}
+		release.LastModified = time.Now().UTC().Truncate(time.Second)
+	}
+
+	s.logger.Debug("Checked HTTP source",
+		"url", s.url,
+		"last_modified", release.LastModified,
+		"etag", release.ETag)
+
+	s.mu.Lock()
+	s.last = release
+	s.mu.Unlock()
+
+	return release, nil
+}
+
+func (s *HTTPSource) Fetch(ctx context.Context, release *Release, destPath string) (*DownloadResult, error) {
+	return s.downloader.Download(ctx, release.URL, destPath, release.SHA256)
+}
+
+func (s *HTTPSource) String() string {
+	return "http:" + s.url
+}
+
+// FileSource reads a single local zip, versioned by its modification time
+type FileSource struct {
+	path string
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+func (s *FileSource) Check(ctx context.Context) (*Release, error) {
+	return fileRelease(s.path)
+}
+
+func (s *FileSource) Fetch(ctx context.Context, release *Release, destPath string) (*DownloadResult, error) {
+	return copyLocalFile(ctx, strings.TrimPrefix(release.URL, "file://"), destPath)
+}
+
+func (s *FileSource) String() string {
+	return "file:" + s.path
+}
+
+// directorySettleTime is how long a file must go unmodified before it is
+// picked up, so a zip still being copied into the directory is skipped
+const directorySettleTime = 30 * time.Second
+
+// DirectorySource imports the most recently modified zip in a directory. The
+// directory is re-scanned on every check, so dropping in a new file
+// publishes a new release.
+type DirectorySource struct {
+	dir    string
+	logger logger.Logger
+}
+
+func NewDirectorySource(dir string, logger logger.Logger) *DirectorySource {
+	return &DirectorySource{dir: dir, logger: logger}
+}
+
+func (s *DirectorySource) Check(ctx context.Context) (*Release, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.zip"))
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", s.dir, err)
+	}
+
+	var newest string
+	var newestMod time.Time
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if time.Since(info.ModTime()) < directorySettleTime {
+			s.logger.Debug("Skipping recently modified file", "path", path)
+			continue
+		}
+		if info.ModTime().After(newestMod) {
+			newest, newestMod = path, info.ModTime()
+		}
+	}
+
+	if newest == "" {
+		return nil, fmt.Errorf("no zip files found in %s", s.dir)
+	}
+
+	return fileRelease(newest)
+}
+
+func (s *DirectorySource) Fetch(ctx context.Context, release *Release, destPath string) (*DownloadResult, error) {
+	return copyLocalFile(ctx, strings.TrimPrefix(release.URL, "file://"), destPath)
+}
+
+func (s *DirectorySource) String() string {
+	return "directory:" + s.dir
+}
+
+func fileRelease(path string) (*Release, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", path, err)
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, fmt.Errorf("checking %s: %w", abs, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", abs)
+	}
+
+	return &Release{
+		URL:          "file://" + abs,
+		LastModified: info.ModTime().UTC().Truncate(time.Second),
+	}, nil
+}
+
+// copyLocalFile copies src to destPath via a temp file, hashing it on the way
+// like HTTPDownloader
+func copyLocalFile(ctx context.Context, src, destPath string) (*DownloadResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", src, err)
+	}
+	defer in.Close()
+
+	destDir := filepath.Dir(destPath)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating destination directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(destDir, "gtfs_copy_*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	h := newChecksum()
+	size, err := io.Copy(io.MultiWriter(tmp, h), in)
+	if err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("copying %s: %w", src, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("closing temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return nil, fmt.Errorf("moving file to destination: %w", err)
+	}
+	return &DownloadResult{Size: size, SHA256: h.String()}, nil
+}
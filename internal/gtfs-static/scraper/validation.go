@@ -0,0 +1,88 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ptvtracker-data/internal/common/db"
+	"github.com/ptvtracker-data/internal/gtfs-static/validator"
+)
+
+// ValidationMode controls how validation results affect an import
+type ValidationMode string
+
+const (
+	ValidationOff    ValidationMode = "off"    // skip validation entirely
+	ValidationReport ValidationMode = "report" // log issues, always import
+	ValidationStrict ValidationMode = "strict" // refuse to import sources with errors
+)
+
+// validating reports whether sources are validated before they are imported
+func (s *GTFSScheduler) validating() bool {
+	return s.config.Validation != ValidationOff && s.config.Validation != ""
+}
+
+// validateSource validates a nested source zip and records its issue counts
+// on the job for the activation gates. It returns an error only in strict
+// mode when the report contains errors.
+func (s *GTFSScheduler) validateSource(ctx context.Context, job *db.ImportJob, src nestedSource) error {
+	versionID := job.VersionID
+	sourceID := src.sourceID
+	if !s.validating() {
+		return nil
+	}
+
+	v := validator.New(s.logger, validator.DefaultOptions())
+	report, err := v.ValidateReader(ctx, src.data, src.size)
+	if err != nil {
+		return fmt.Errorf("validating source %d: %w", sourceID, err)
+	}
+
+	if err := s.importJobs.RecordValidation(ctx, job.JobID, sourceID, report.ErrorCount, report.WarningCount); err != nil {
+		s.logger.Warn("Failed to record validation counts", "source_id", sourceID, "error", err)
+	}
+
+	if s.config.ValidationReportDir != "" {
+		if err := s.writeValidationReport(report, sourceID, versionID); err != nil {
+			s.logger.Warn("Failed to write validation report", "source_id", sourceID, "error", err)
+		}
+	}
+
+	if report.ErrorCount > 0 || report.WarningCount > 0 {
+		s.logger.Warn("Validation found issues in source",
+			"source_id", sourceID,
+			"version_id", versionID,
+			"errors", report.ErrorCount,
+			"warnings", report.WarningCount)
+	}
+
+	if s.config.Validation == ValidationStrict && report.HasErrors() {
+		return fmt.Errorf("source %d failed strict validation with %d errors", sourceID, report.ErrorCount)
+	}
+
+	return nil
+}
+
+func (s *GTFSScheduler) writeValidationReport(report *validator.Report, sourceID, versionID int) error {
+	if err := os.MkdirAll(s.config.ValidationReportDir, 0755); err != nil {
+		return fmt.Errorf("creating report directory: %w", err)
+	}
+
+	path := filepath.Join(s.config.ValidationReportDir,
+		fmt.Sprintf("validation_v%d_source_%d.json", versionID, sourceID))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating report file: %w", err)
+	}
+	defer f.Close()
+
+	if err := report.WriteJSON(f); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	s.logger.Info("Wrote validation report", "source_id", sourceID, "path", path)
+	return nil
+}
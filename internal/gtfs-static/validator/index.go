@@ -0,0 +1,38 @@
+package validator
+
+// sequenceIndex tracks (id, sequence) keys for stop_times and shapes without
+// holding every row in memory. Feeds group these files by id, so sequences
+// are only compared within the current contiguous run of an id; every id seen
+// is remembered for reference checks.
+type sequenceIndex struct {
+	ids       map[string]struct{}
+	currentID string
+	current   map[int]int // sequence -> line within the current run
+}
+
+func newSequenceIndex() *sequenceIndex {
+	return &sequenceIndex{
+		ids:     make(map[string]struct{}),
+		current: make(map[int]int),
+	}
+}
+
+// add records a key and returns the line of an earlier duplicate, or 0
+func (s *sequenceIndex) add(id string, seq, line int) int {
+	if id != s.currentID {
+		s.currentID = id
+		s.current = make(map[int]int)
+	}
+	s.ids[id] = struct{}{}
+
+	if prev, ok := s.current[seq]; ok {
+		return prev
+	}
+	s.current[seq] = line
+	return 0
+}
+
+func (s *sequenceIndex) has(id string) bool {
+	_, ok := s.ids[id]
+	return ok
+}
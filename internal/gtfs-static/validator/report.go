@@ -0,0 +1,80 @@
+package validator
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Severity of a validation issue
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a single problem found in a GTFS file
+type Issue struct {
+	Severity Severity `json:"severity"`
+	File     string   `json:"file"`
+	Line     int      `json:"line,omitempty"`
+	Field    string   `json:"field,omitempty"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+}
+
+// FileSummary holds per-file record and issue counts
+type FileSummary struct {
+	Records  int `json:"records"`
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+}
+
+// Report is the structured result of validating one GTFS archive.
+// Counts are always exact; Issues is capped per file and code (see Options).
+type Report struct {
+	Files            map[string]*FileSummary `json:"files"`
+	Issues           []Issue                 `json:"issues"`
+	ErrorCount       int                     `json:"error_count"`
+	WarningCount     int                     `json:"warning_count"`
+	SuppressedIssues int                     `json:"suppressed_issues"`
+}
+
+func newReport() *Report {
+	return &Report{
+		Files:  make(map[string]*FileSummary),
+		Issues: []Issue{},
+	}
+}
+
+// HasErrors reports whether any error-level issue was found
+func (r *Report) HasErrors() bool {
+	return r.ErrorCount > 0
+}
+
+// FileNames returns the summarised file names in sorted order
+func (r *Report) FileNames() []string {
+	names := make([]string, 0, len(r.Files))
+	for name := range r.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) file(name string) *FileSummary {
+	summary, ok := r.Files[name]
+	if !ok {
+		summary = &FileSummary{}
+		r.Files[name] = summary
+	}
+	return summary
+}
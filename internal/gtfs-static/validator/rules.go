@@ -0,0 +1,174 @@
+package validator
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindInt
+	kindFloat
+	kindLatitude
+	kindLongitude
+	kindTime
+	kindDate
+	kindColor
+	kindURL
+	kindTimezone
+)
+
+// fieldRule describes how a single column is checked. Length limits mirror
+// the column sizes in sql/migrations/gtfs_static/001_tables.sql so values
+// that would fail the import are caught up front.
+type fieldRule struct {
+	name     string
+	kind     fieldKind
+	required bool
+	maxLen   int
+	enum     []int
+	min      *int
+}
+
+type fileSpec struct {
+	name     string
+	required bool
+	fields   []fieldRule
+}
+
+var zero = 0
+var one = 1
+
+// fileSpecs is ordered so referenced files are validated before the files referencing them
+var fileSpecs = []fileSpec{
+	{
+		name:     "agency.txt",
+		required: true,
+		fields: []fieldRule{
+			{name: "agency_id", maxLen: 50},
+			{name: "agency_name", required: true, maxLen: 255},
+			{name: "agency_url", kind: kindURL, required: true, maxLen: 500},
+			{name: "agency_timezone", kind: kindTimezone, required: true, maxLen: 50},
+			{name: "agency_lang", maxLen: 10},
+			{name: "agency_fare_url", kind: kindURL, maxLen: 500},
+		},
+	},
+	{
+		name: "levels.txt",
+		fields: []fieldRule{
+			{name: "level_id", required: true, maxLen: 50},
+			{name: "level_index", kind: kindFloat, required: true},
+			{name: "level_name", maxLen: 255},
+		},
+	},
+	{
+		name:     "stops.txt",
+		required: true,
+		fields: []fieldRule{
+			{name: "stop_id", required: true, maxLen: 50},
+			{name: "stop_name", maxLen: 255},
+			{name: "stop_lat", kind: kindLatitude},
+			{name: "stop_lon", kind: kindLongitude},
+			{name: "location_type", kind: kindInt, enum: []int{0, 1, 2, 3, 4}},
+			{name: "parent_station", maxLen: 50},
+			{name: "wheelchair_boarding", kind: kindInt, enum: []int{0, 1, 2}},
+			{name: "level_id", maxLen: 50},
+		},
+	},
+	{
+		name:     "routes.txt",
+		required: true,
+		fields: []fieldRule{
+			{name: "route_id", required: true, maxLen: 50},
+			{name: "agency_id", maxLen: 50},
+			{name: "route_short_name", maxLen: 50},
+			{name: "route_long_name", maxLen: 255},
+			{name: "route_type", kind: kindInt, required: true},
+			{name: "route_color", kind: kindColor},
+			{name: "route_text_color", kind: kindColor},
+		},
+	},
+	{
+		name: "calendar.txt",
+		fields: []fieldRule{
+			{name: "service_id", required: true, maxLen: 50},
+			{name: "monday", kind: kindInt, required: true, enum: []int{0, 1}},
+			{name: "tuesday", kind: kindInt, required: true, enum: []int{0, 1}},
+			{name: "wednesday", kind: kindInt, required: true, enum: []int{0, 1}},
+			{name: "thursday", kind: kindInt, required: true, enum: []int{0, 1}},
+			{name: "friday", kind: kindInt, required: true, enum: []int{0, 1}},
+			{name: "saturday", kind: kindInt, required: true, enum: []int{0, 1}},
+			{name: "sunday", kind: kindInt, required: true, enum: []int{0, 1}},
+			{name: "start_date", kind: kindDate, required: true},
+			{name: "end_date", kind: kindDate, required: true},
+		},
+	},
+	{
+		name: "calendar_dates.txt",
+		fields: []fieldRule{
+			{name: "service_id", required: true, maxLen: 50},
+			{name: "date", kind: kindDate, required: true},
+			{name: "exception_type", kind: kindInt, required: true, enum: []int{1, 2}},
+		},
+	},
+	{
+		name: "shapes.txt",
+		fields: []fieldRule{
+			{name: "shape_id", required: true, maxLen: 50},
+			{name: "shape_pt_lat", kind: kindLatitude, required: true},
+			{name: "shape_pt_lon", kind: kindLongitude, required: true},
+			{name: "shape_pt_sequence", kind: kindInt, required: true, min: &zero},
+			{name: "shape_dist_traveled", kind: kindFloat},
+		},
+	},
+	{
+		name:     "trips.txt",
+		required: true,
+		fields: []fieldRule{
+			{name: "route_id", required: true, maxLen: 50},
+			{name: "service_id", required: true, maxLen: 50},
+			{name: "trip_id", required: true, maxLen: 100},
+			{name: "trip_headsign", maxLen: 255},
+			{name: "direction_id", kind: kindInt, enum: []int{0, 1}},
+			{name: "block_id", maxLen: 50},
+			{name: "shape_id", maxLen: 50},
+			{name: "wheelchair_accessible", kind: kindInt, enum: []int{0, 1, 2}},
+		},
+	},
+	{
+		name:     "stop_times.txt",
+		required: true,
+		fields: []fieldRule{
+			{name: "trip_id", required: true, maxLen: 100},
+			{name: "arrival_time", kind: kindTime},
+			{name: "departure_time", kind: kindTime},
+			{name: "stop_id", required: true, maxLen: 50},
+			{name: "stop_sequence", kind: kindInt, required: true, min: &zero},
+			{name: "stop_headsign", maxLen: 255},
+			{name: "pickup_type", kind: kindInt, enum: []int{0, 1, 2, 3}},
+			{name: "drop_off_type", kind: kindInt, enum: []int{0, 1, 2, 3}},
+			{name: "shape_dist_traveled", kind: kindFloat},
+		},
+	},
+	{
+		name: "pathways.txt",
+		fields: []fieldRule{
+			{name: "pathway_id", required: true, maxLen: 50},
+			{name: "from_stop_id", required: true, maxLen: 50},
+			{name: "to_stop_id", required: true, maxLen: 50},
+			{name: "pathway_mode", kind: kindInt, required: true, enum: []int{1, 2, 3, 4, 5, 6, 7}},
+			{name: "is_bidirectional", kind: kindInt, required: true, enum: []int{0, 1}},
+			{name: "traversal_time", kind: kindInt, min: &one},
+		},
+	},
+	{
+		name: "transfers.txt",
+		fields: []fieldRule{
+			{name: "from_stop_id", maxLen: 50},
+			{name: "to_stop_id", maxLen: 50},
+			{name: "from_route_id", maxLen: 50},
+			{name: "to_route_id", maxLen: 50},
+			{name: "from_trip_id", maxLen: 100},
+			{name: "to_trip_id", maxLen: 100},
+			{name: "transfer_type", kind: kindInt, enum: []int{0, 1, 2, 3, 4}},
+			{name: "min_transfer_time", kind: kindInt, min: &zero},
+		},
+	},
+}
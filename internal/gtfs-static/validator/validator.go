@@ -0,0 +1,653 @@
+package validator
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ptvtracker-data/internal/common/logger"
+	"github.com/ptvtracker-data/internal/gtfs-static/archive"
+)
+
+var (
+	gtfsTimeRegex  = regexp.MustCompile(`^(\d{1,3}):([0-5]\d):([0-5]\d)$`)
+	gtfsColorRegex = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+)
+
+// Options controls how much detail a report keeps
+type Options struct {
+	// MaxIssuesPerCode caps the issues stored per file and code. Counts in
+	// the summaries stay exact; the rest are tallied as suppressed.
+	MaxIssuesPerCode int
+}
+
+// DefaultOptions returns sensible defaults
+func DefaultOptions() Options {
+	return Options{
+		MaxIssuesPerCode: 100,
+	}
+}
+
+// Validator checks GTFS archives against the spec and the database schema
+type Validator struct {
+	logger logger.Logger
+	opts   Options
+}
+
+func New(logger logger.Logger, opts Options) *Validator {
+	return &Validator{logger: logger, opts: opts}
+}
+
+// ValidateZip validates the GTFS archive at zipPath
+func (v *Validator) ValidateZip(ctx context.Context, zipPath string) (*Report, error) {
+	reader, err := archive.Open(zipPath, archive.DefaultLimits())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return v.Validate(ctx, reader.Reader)
+}
+
+// ValidateReader validates a GTFS zip of the given size read from r
+func (v *Validator) ValidateReader(ctx context.Context, r io.ReaderAt, size int64) (*Report, error) {
+	reader, err := archive.NewReader(r, size, archive.DefaultLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Validate(ctx, reader.Reader)
+}
+
+// Validate validates an opened GTFS archive. Errors are returned only when
+// the archive can't be read at all; everything else ends up in the report.
+func (v *Validator) Validate(ctx context.Context, reader *zip.Reader) (*Report, error) {
+	r := newRun(v.opts)
+
+	fileMap := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		fileMap[file.Name] = file
+	}
+
+	for _, spec := range fileSpecs {
+		file, exists := fileMap[spec.name]
+		if !exists {
+			if spec.required {
+				r.addIssue(SeverityError, spec.name, 0, "", "missing_required_file", "required file is missing from the archive")
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		if err := r.validateFile(file, spec); err != nil {
+			return nil, fmt.Errorf("validating %s: %w", spec.name, err)
+		}
+	}
+
+	if _, ok := fileMap["calendar.txt"]; !ok {
+		if _, ok := fileMap["calendar_dates.txt"]; !ok {
+			r.addIssue(SeverityError, "calendar.txt", 0, "", "missing_required_file", "either calendar.txt or calendar_dates.txt is required")
+		}
+	}
+
+	r.finish()
+
+	v.logger.Info("GTFS validation completed",
+		"errors", r.report.ErrorCount,
+		"warnings", r.report.WarningCount,
+		"files", len(r.report.Files))
+
+	return r.report, nil
+}
+
+// run holds the state of a single validation pass
+type run struct {
+	opts        Options
+	report      *Report
+	issueCounts map[string]int
+
+	agencies      map[string]int // key -> first line
+	levels        map[string]int
+	stops         map[string]int
+	stopTypes     map[string]int
+	routes        map[string]int
+	services      map[string]bool
+	calendarDates map[string]int
+	shapes        *sequenceIndex
+	trips         map[string]int
+	stopTimes     *sequenceIndex
+	pathways      map[string]int
+	transfers     map[string]int
+
+	pendingParents []pendingRef
+}
+
+type pendingRef struct {
+	line  int
+	id    string
+	ref   string
+	field string
+}
+
+func newRun(opts Options) *run {
+	return &run{
+		opts:          opts,
+		report:        newReport(),
+		issueCounts:   make(map[string]int),
+		agencies:      make(map[string]int),
+		levels:        make(map[string]int),
+		stops:         make(map[string]int),
+		stopTypes:     make(map[string]int),
+		routes:        make(map[string]int),
+		services:      make(map[string]bool),
+		calendarDates: make(map[string]int),
+		shapes:        newSequenceIndex(),
+		trips:         make(map[string]int),
+		stopTimes:     newSequenceIndex(),
+		pathways:      make(map[string]int),
+		transfers:     make(map[string]int),
+	}
+}
+
+func (r *run) addIssue(severity Severity, file string, line int, field, code, message string) {
+	summary := r.report.file(file)
+	if severity == SeverityError {
+		summary.Errors++
+		r.report.ErrorCount++
+	} else {
+		summary.Warnings++
+		r.report.WarningCount++
+	}
+
+	countKey := file + "|" + code
+	if r.opts.MaxIssuesPerCode > 0 && r.issueCounts[countKey] >= r.opts.MaxIssuesPerCode {
+		r.report.SuppressedIssues++
+		return
+	}
+	r.issueCounts[countKey]++
+
+	r.report.Issues = append(r.report.Issues, Issue{
+		Severity: severity,
+		File:     file,
+		Line:     line,
+		Field:    field,
+		Code:     code,
+		Message:  message,
+	})
+}
+
+// row gives named access to a CSV record
+type row struct {
+	header map[string]int
+	record []string
+}
+
+func (r row) get(field string) string {
+	if idx, ok := r.header[field]; ok && idx < len(r.record) {
+		return strings.TrimSpace(r.record[idx])
+	}
+	return ""
+}
+
+func (r row) getInt(field string, defaultVal int) int {
+	val, err := strconv.Atoi(r.get(field))
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+func (r *run) validateFile(file *zip.File, spec fileSpec) error {
+	rc, err := archive.OpenFile(file, archive.DefaultLimits())
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer rc.Close()
+
+	reader := csv.NewReader(rc)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	summary := r.report.file(spec.name)
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		r.addIssue(SeverityError, spec.name, 1, "", "empty_file", "file has no header row")
+		return nil
+	}
+	if err != nil {
+		r.addIssue(SeverityError, spec.name, 1, "", "invalid_csv", err.Error())
+		return nil
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	headerMap := make(map[string]int)
+	for i, h := range header {
+		name := strings.TrimSpace(h)
+		if _, dup := headerMap[name]; dup {
+			r.addIssue(SeverityError, spec.name, 1, name, "duplicate_column", "column appears more than once in the header")
+			continue
+		}
+		headerMap[name] = i
+	}
+
+	// A missing required column is reported once rather than on every row
+	missingColumns := make(map[string]bool)
+	for _, rule := range spec.fields {
+		if _, ok := headerMap[rule.name]; !ok && rule.required {
+			missingColumns[rule.name] = true
+			r.addIssue(SeverityError, spec.name, 1, rule.name, "missing_required_column", "required column is missing from the header")
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			r.addIssue(SeverityError, spec.name, line, "", "invalid_csv", err.Error())
+			if _, ok := err.(*csv.ParseError); ok {
+				continue
+			}
+			return nil
+		}
+
+		summary.Records++
+
+		if len(record) != len(header) {
+			r.addIssue(SeverityWarning, spec.name, line, "", "column_count_mismatch",
+				fmt.Sprintf("row has %d values but the header has %d columns", len(record), len(header)))
+		}
+
+		rw := row{header: headerMap, record: record}
+		for _, rule := range spec.fields {
+			if missingColumns[rule.name] {
+				continue
+			}
+			r.checkField(spec.name, line, rw, rule)
+		}
+
+		r.checkRecord(spec, line, rw)
+	}
+
+	if spec.name == "stops.txt" {
+		r.checkParentStations()
+	}
+
+	return nil
+}
+
+func (r *run) checkField(file string, line int, rw row, rule fieldRule) {
+	value := rw.get(rule.name)
+	if value == "" {
+		if rule.required {
+			r.addIssue(SeverityError, file, line, rule.name, "missing_required_field", "required value is empty")
+		}
+		return
+	}
+
+	if rule.maxLen > 0 && len(value) > rule.maxLen {
+		r.addIssue(SeverityError, file, line, rule.name, "value_too_long",
+			fmt.Sprintf("value is %d characters, the database allows %d", len(value), rule.maxLen))
+	}
+
+	switch rule.kind {
+	case kindInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			r.addIssue(SeverityError, file, line, rule.name, "invalid_integer", fmt.Sprintf("%q is not an integer", value))
+			return
+		}
+		if len(rule.enum) > 0 && !containsInt(rule.enum, n) {
+			r.addIssue(SeverityError, file, line, rule.name, "invalid_enum_value",
+				fmt.Sprintf("%d is not one of %v", n, rule.enum))
+		}
+		if rule.min != nil && n < *rule.min {
+			r.addIssue(SeverityError, file, line, rule.name, "value_out_of_range",
+				fmt.Sprintf("%d is below the minimum of %d", n, *rule.min))
+		}
+	case kindFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			r.addIssue(SeverityError, file, line, rule.name, "invalid_float", fmt.Sprintf("%q is not a number", value))
+		}
+	case kindLatitude, kindLongitude:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			r.addIssue(SeverityError, file, line, rule.name, "invalid_float", fmt.Sprintf("%q is not a number", value))
+			return
+		}
+		limit := 90.0
+		if rule.kind == kindLongitude {
+			limit = 180.0
+		}
+		if f < -limit || f > limit {
+			r.addIssue(SeverityError, file, line, rule.name, "coordinate_out_of_range",
+				fmt.Sprintf("%v is outside [-%v, %v]", f, limit, limit))
+		}
+	case kindTime:
+		if _, err := parseTime(value); err != nil {
+			r.addIssue(SeverityError, file, line, rule.name, "invalid_time", err.Error())
+		}
+	case kindDate:
+		if _, err := time.Parse("20060102", value); err != nil {
+			r.addIssue(SeverityError, file, line, rule.name, "invalid_date", fmt.Sprintf("%q is not a YYYYMMDD date", value))
+		}
+	case kindColor:
+		if !gtfsColorRegex.MatchString(value) {
+			r.addIssue(SeverityError, file, line, rule.name, "invalid_color", fmt.Sprintf("%q is not a six-digit hex colour", value))
+		}
+	case kindURL:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			r.addIssue(SeverityWarning, file, line, rule.name, "invalid_url", fmt.Sprintf("%q is not an http(s) URL", value))
+		}
+	case kindTimezone:
+		if _, err := time.LoadLocation(value); err != nil {
+			r.addIssue(SeverityError, file, line, rule.name, "invalid_timezone", fmt.Sprintf("%q is not a known IANA timezone", value))
+		}
+	}
+}
+
+// checkRecord runs file-specific checks: keys, references and conditional requirements
+func (r *run) checkRecord(spec fileSpec, line int, rw row) {
+	file := spec.name
+
+	switch file {
+	case "agency.txt":
+		r.checkDuplicate(file, line, r.agencies, rw.get("agency_id"))
+
+	case "levels.txt":
+		r.checkDuplicate(file, line, r.levels, rw.get("level_id"))
+
+	case "stops.txt":
+		stopID := rw.get("stop_id")
+		if stopID == "" {
+			return
+		}
+		r.checkDuplicate(file, line, r.stops, stopID)
+
+		locationType := rw.getInt("location_type", 0)
+		r.stopTypes[stopID] = locationType
+
+		// Name and coordinates are required for stops, stations and entrances
+		if locationType <= 2 {
+			if rw.get("stop_name") == "" {
+				r.addIssue(SeverityError, file, line, "stop_name", "missing_required_field", "stop_name is required for location_type 0-2")
+			}
+			r.checkCoordinates(file, line, rw, "stop_lat", "stop_lon", true)
+		}
+
+		parent := rw.get("parent_station")
+		switch {
+		case locationType == 1 && parent != "":
+			r.addIssue(SeverityError, file, line, "parent_station", "forbidden_parent_station", "stations must not have a parent_station")
+		case locationType >= 2 && parent == "":
+			r.addIssue(SeverityError, file, line, "parent_station", "missing_required_field", "parent_station is required for location_type 2-4")
+		}
+		if parent != "" {
+			r.pendingParents = append(r.pendingParents, pendingRef{line: line, id: stopID, ref: parent, field: "parent_station"})
+		}
+
+		if levelID := rw.get("level_id"); levelID != "" {
+			r.checkReference(file, line, "level_id", levelID, r.levels, "levels.txt")
+		}
+
+	case "routes.txt":
+		r.checkDuplicate(file, line, r.routes, rw.get("route_id"))
+
+		if rw.get("route_short_name") == "" && rw.get("route_long_name") == "" {
+			r.addIssue(SeverityError, file, line, "route_short_name", "missing_route_name", "one of route_short_name or route_long_name is required")
+		}
+
+		if routeType, err := strconv.Atoi(rw.get("route_type")); err == nil && !validRouteType(routeType) {
+			r.addIssue(SeverityError, file, line, "route_type", "invalid_enum_value", fmt.Sprintf("%d is not a basic or extended route type", routeType))
+		}
+
+		agencyID := rw.get("agency_id")
+		if agencyID == "" {
+			if len(r.agencies) > 1 {
+				r.addIssue(SeverityError, file, line, "agency_id", "missing_required_field", "agency_id is required when the feed has more than one agency")
+			}
+		} else {
+			r.checkReference(file, line, "agency_id", agencyID, r.agencies, "agency.txt")
+		}
+
+	case "calendar.txt":
+		serviceID := rw.get("service_id")
+		if serviceID == "" {
+			return
+		}
+		if r.services[serviceID] {
+			r.addIssue(SeverityError, file, line, "service_id", "duplicate_key", fmt.Sprintf("service_id %q is defined more than once", serviceID))
+		}
+		r.services[serviceID] = true
+
+		start, startErr := time.Parse("20060102", rw.get("start_date"))
+		end, endErr := time.Parse("20060102", rw.get("end_date"))
+		if startErr == nil && endErr == nil && end.Before(start) {
+			r.addIssue(SeverityError, file, line, "end_date", "invalid_date_range", "end_date is before start_date")
+		}
+
+	case "calendar_dates.txt":
+		serviceID := rw.get("service_id")
+		if serviceID == "" {
+			return
+		}
+		r.checkDuplicate(file, line, r.calendarDates, serviceID+"|"+rw.get("date"))
+		r.services[serviceID] = true
+
+	case "shapes.txt":
+		shapeID := rw.get("shape_id")
+		seq, err := strconv.Atoi(rw.get("shape_pt_sequence"))
+		if shapeID == "" || err != nil {
+			return
+		}
+		if prev := r.shapes.add(shapeID, seq, line); prev > 0 {
+			r.addIssue(SeverityError, file, line, "shape_pt_sequence", "duplicate_key",
+				fmt.Sprintf("shape %q sequence %d duplicates line %d", shapeID, seq, prev))
+		}
+		r.checkCoordinates(file, line, rw, "shape_pt_lat", "shape_pt_lon", false)
+
+	case "trips.txt":
+		r.checkDuplicate(file, line, r.trips, rw.get("trip_id"))
+
+		if routeID := rw.get("route_id"); routeID != "" {
+			r.checkReference(file, line, "route_id", routeID, r.routes, "routes.txt")
+		}
+		if serviceID := rw.get("service_id"); serviceID != "" && !r.services[serviceID] {
+			r.addIssue(SeverityError, file, line, "service_id", "dangling_reference",
+				fmt.Sprintf("service_id %q is not defined in calendar.txt or calendar_dates.txt", serviceID))
+		}
+		// The schema deliberately has no FK on shape_id, so this is only a warning
+		if shapeID := rw.get("shape_id"); shapeID != "" && !r.shapes.has(shapeID) {
+			r.addIssue(SeverityWarning, file, line, "shape_id", "dangling_reference",
+				fmt.Sprintf("shape_id %q is not defined in shapes.txt", shapeID))
+		}
+
+	case "stop_times.txt":
+		tripID := rw.get("trip_id")
+		if tripID != "" {
+			r.checkReference(file, line, "trip_id", tripID, r.trips, "trips.txt")
+		}
+		if stopID := rw.get("stop_id"); stopID != "" {
+			r.checkReference(file, line, "stop_id", stopID, r.stops, "stops.txt")
+		}
+
+		if seq, err := strconv.Atoi(rw.get("stop_sequence")); err == nil && tripID != "" {
+			if prev := r.stopTimes.add(tripID, seq, line); prev > 0 {
+				r.addIssue(SeverityError, file, line, "stop_sequence", "duplicate_key",
+					fmt.Sprintf("trip %q stop_sequence %d duplicates line %d", tripID, seq, prev))
+			}
+		}
+
+		arrival, departure := rw.get("arrival_time"), rw.get("departure_time")
+		if (arrival == "") != (departure == "") {
+			r.addIssue(SeverityWarning, file, line, "arrival_time", "incomplete_times", "arrival_time and departure_time should both be set or both be empty")
+		}
+		if arrival != "" && departure != "" {
+			a, aErr := parseTime(arrival)
+			d, dErr := parseTime(departure)
+			if aErr == nil && dErr == nil && d < a {
+				r.addIssue(SeverityError, file, line, "departure_time", "departure_before_arrival", "departure_time is earlier than arrival_time")
+			}
+		}
+
+	case "pathways.txt":
+		r.checkDuplicate(file, line, r.pathways, rw.get("pathway_id"))
+		for _, field := range []string{"from_stop_id", "to_stop_id"} {
+			if stopID := rw.get(field); stopID != "" {
+				r.checkReference(file, line, field, stopID, r.stops, "stops.txt")
+			}
+		}
+
+	case "transfers.txt":
+		key := strings.Join([]string{rw.get("from_stop_id"), rw.get("to_stop_id"), rw.get("from_trip_id"), rw.get("to_trip_id")}, "|")
+		r.checkDuplicate(file, line, r.transfers, key)
+
+		// The transfers primary key includes both stop IDs, so they can't be empty here
+		for _, field := range []string{"from_stop_id", "to_stop_id"} {
+			stopID := rw.get(field)
+			if stopID == "" {
+				r.addIssue(SeverityError, file, line, field, "missing_required_field", "stop IDs are required by the transfers primary key")
+				continue
+			}
+			r.checkReference(file, line, field, stopID, r.stops, "stops.txt")
+		}
+		for _, field := range []string{"from_route_id", "to_route_id"} {
+			if routeID := rw.get(field); routeID != "" {
+				r.checkReference(file, line, field, routeID, r.routes, "routes.txt")
+			}
+		}
+		for _, field := range []string{"from_trip_id", "to_trip_id"} {
+			if tripID := rw.get(field); tripID != "" {
+				r.checkReference(file, line, field, tripID, r.trips, "trips.txt")
+			}
+		}
+	}
+}
+
+func (r *run) checkDuplicate(file string, line int, seen map[string]int, key string) {
+	if prev, ok := seen[key]; ok {
+		r.addIssue(SeverityError, file, line, "", "duplicate_key",
+			fmt.Sprintf("key %q duplicates line %d", key, prev))
+		return
+	}
+	seen[key] = line
+}
+
+func (r *run) checkReference(file string, line int, field, id string, known map[string]int, target string) {
+	if _, ok := known[id]; !ok {
+		r.addIssue(SeverityError, file, line, field, "dangling_reference",
+			fmt.Sprintf("%s %q is not defined in %s", field, id, target))
+	}
+}
+
+func (r *run) checkCoordinates(file string, line int, rw row, latField, lonField string, required bool) {
+	latStr, lonStr := rw.get(latField), rw.get(lonField)
+	if latStr == "" || lonStr == "" {
+		if required {
+			r.addIssue(SeverityError, file, line, latField, "missing_coordinates", "latitude and longitude are required")
+		}
+		return
+	}
+
+	lat, latErr := strconv.ParseFloat(latStr, 64)
+	lon, lonErr := strconv.ParseFloat(lonStr, 64)
+	if latErr != nil || lonErr != nil {
+		return // already reported by the field rules
+	}
+
+	switch {
+	case lat == 0 && lon == 0:
+		r.addIssue(SeverityWarning, file, line, latField, "null_island", "coordinates are 0,0")
+	case (lat < -90 || lat > 90) && lon >= -90 && lon <= 90:
+		r.addIssue(SeverityWarning, file, line, latField, "swapped_coordinates", "latitude and longitude look swapped")
+	}
+}
+
+// checkParentStations resolves parent_station references once all stops are known
+func (r *run) checkParentStations() {
+	for _, ref := range r.pendingParents {
+		parentType, ok := r.stopTypes[ref.ref]
+		if !ok {
+			r.addIssue(SeverityError, "stops.txt", ref.line, ref.field, "dangling_reference",
+				fmt.Sprintf("parent_station %q is not defined in stops.txt", ref.ref))
+			continue
+		}
+
+		childType := r.stopTypes[ref.id]
+		wantParent := 1 // stops, entrances and generic nodes belong to stations
+		if childType == 4 {
+			wantParent = 0 // boarding areas belong to platforms
+		}
+		if parentType != wantParent {
+			r.addIssue(SeverityWarning, "stops.txt", ref.line, ref.field, "invalid_parent_type",
+				fmt.Sprintf("parent_station %q has location_type %d, expected %d", ref.ref, parentType, wantParent))
+		}
+	}
+	r.pendingParents = nil
+}
+
+// finish runs checks that need every file to have been read
+func (r *run) finish() {
+	// Sorted so the issues kept under MaxIssuesPerCode are the same every run
+	tripIDs := make([]string, 0, len(r.trips))
+	for tripID := range r.trips {
+		tripIDs = append(tripIDs, tripID)
+	}
+	sort.Strings(tripIDs)
+
+	for _, tripID := range tripIDs {
+		if !r.stopTimes.has(tripID) {
+			r.addIssue(SeverityWarning, "trips.txt", r.trips[tripID], "trip_id", "trip_without_stop_times",
+				fmt.Sprintf("trip %q has no stop_times", tripID))
+		}
+	}
+}
+
+func parseTime(value string) (int, error) {
+	m := gtfsTimeRegex.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("%q is not a H:MM:SS time", value)
+	}
+	h, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	sec, _ := strconv.Atoi(m[3])
+	return h*3600 + min*60 + sec, nil
+}
+
+func validRouteType(t int) bool {
+	switch {
+	case t >= 0 && t <= 7, t == 11, t == 12:
+		return true
+	case t >= 100 && t <= 1799: // extended route types
+		return true
+	}
+	return false
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,540 @@
+package validator
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ptvtracker-data/internal/common/logger"
+)
+
+// baseFeed is a small feed without any issues: a station with one platform,
+// a second stop, and two trips on one route, the second running past midnight
+var baseFeed = map[string]string{
+	"agency.txt": `agency_id,agency_name,agency_url,agency_timezone
+A1,Metro,https://example.com,Australia/Melbourne
+`,
+	"stops.txt": `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station
+ST,Central,-37.81,144.96,1,
+S1,Central Platform 1,-37.8101,144.9601,0,ST
+S2,Park St,-37.82,144.97,0,
+`,
+	"routes.txt": `route_id,agency_id,route_short_name,route_long_name,route_type,route_color
+R1,A1,1,Central - Park St,0,FF0000
+`,
+	"calendar.txt": `service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date
+WK,1,1,1,1,1,0,0,20250101,20251231
+`,
+	"calendar_dates.txt": `service_id,date,exception_type
+WK,20251225,2
+`,
+	"shapes.txt": `shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence
+SH1,-37.8101,144.9601,1
+SH1,-37.82,144.97,2
+`,
+	"trips.txt": `route_id,service_id,trip_id,direction_id,shape_id
+R1,WK,T1,0,SH1
+R1,WK,T2,1,SH1
+`,
+	"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+T1,08:00:00,08:00:00,S1,1
+T1,08:10:00,08:10:00,S2,2
+T2,23:55:00,23:55:00,S2,1
+T2,24:05:00,24:06:00,S1,2
+`,
+}
+
+// feed returns baseFeed with files replaced by overrides; an empty override
+// removes the file
+func feed(overrides map[string]string) fstest.MapFS {
+	fsys := make(fstest.MapFS)
+	for name, data := range baseFeed {
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	for name, data := range overrides {
+		if data == "" {
+			delete(fsys, name)
+			continue
+		}
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	return fsys
+}
+
+func validate(t *testing.T, fsys fstest.MapFS, opts Options) *Report {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := zw.AddFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := New(logger.New(io.Discard), opts).ValidateReader(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("validating: %v", err)
+	}
+	return report
+}
+
+// issueKeys renders issues as "severity file:line field code"
+func issueKeys(issues []Issue) []string {
+	keys := make([]string, len(issues))
+	for i, issue := range issues {
+		keys[i] = fmt.Sprintf("%s %s:%d %s %s", issue.Severity, issue.File, issue.Line, issue.Field, issue.Code)
+	}
+	return keys
+}
+
+type validateCase struct {
+	name  string
+	files map[string]string
+	want  []string // issueKeys, in any order
+}
+
+func runCases(t *testing.T, tests []validateCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := validate(t, feed(tt.files), DefaultOptions())
+
+			got := issueKeys(report.Issues)
+			sort.Strings(got)
+			want := append([]string{}, tt.want...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("issues:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+			}
+
+			var errors, warnings int
+			for _, issue := range report.Issues {
+				if issue.Severity == SeverityError {
+					errors++
+				} else {
+					warnings++
+				}
+			}
+			if report.ErrorCount != errors || report.WarningCount != warnings {
+				t.Errorf("counts = %d errors, %d warnings, want %d, %d", report.ErrorCount, report.WarningCount, errors, warnings)
+			}
+		})
+	}
+}
+
+func TestValidateBaseFeed(t *testing.T) {
+	report := validate(t, feed(nil), DefaultOptions())
+	if len(report.Issues) > 0 || report.HasErrors() {
+		t.Fatalf("issues in the base feed: %v", issueKeys(report.Issues))
+	}
+
+	records := map[string]int{}
+	for name, summary := range report.Files {
+		records[name] = summary.Records
+	}
+	want := map[string]int{
+		"agency.txt":         1,
+		"stops.txt":          3,
+		"routes.txt":         1,
+		"calendar.txt":       1,
+		"calendar_dates.txt": 1,
+		"shapes.txt":         2,
+		"trips.txt":          2,
+		"stop_times.txt":     4,
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v, want %v", records, want)
+	}
+}
+
+func TestValidateStructure(t *testing.T) {
+	runCases(t, []validateCase{
+		{
+			name:  "missing calendar",
+			files: map[string]string{"calendar.txt": "", "calendar_dates.txt": ""},
+			want: []string{
+				"error calendar.txt:0  missing_required_file",
+				"error trips.txt:2 service_id dangling_reference",
+				"error trips.txt:3 service_id dangling_reference",
+			},
+		},
+		{
+			name:  "calendar_dates only",
+			files: map[string]string{"calendar.txt": ""},
+		},
+		{
+			name:  "missing required file",
+			files: map[string]string{"agency.txt": ""},
+			want: []string{
+				"error agency.txt:0  missing_required_file",
+				"error routes.txt:2 agency_id dangling_reference",
+			},
+		},
+		{
+			name:  "empty file",
+			files: map[string]string{"calendar_dates.txt": "\n"},
+			want:  []string{"error calendar_dates.txt:1  empty_file"},
+		},
+		{
+			name: "missing required column",
+			files: map[string]string{"routes.txt": `route_id,agency_id,route_short_name
+R1,A1,1
+`},
+			want: []string{"error routes.txt:1 route_type missing_required_column"},
+		},
+		{
+			name: "duplicate column",
+			files: map[string]string{"calendar_dates.txt": `service_id,date,exception_type,date
+WK,20251225,2,20251226
+`},
+			want: []string{"error calendar_dates.txt:1 date duplicate_column"},
+		},
+		{
+			name: "short row",
+			files: map[string]string{"calendar_dates.txt": `service_id,date,exception_type
+WK,20251225
+`},
+			want: []string{
+				"warning calendar_dates.txt:2  column_count_mismatch",
+				"error calendar_dates.txt:2 exception_type missing_required_field",
+			},
+		},
+		{
+			name: "value too long",
+			files: map[string]string{"routes.txt": `route_id,agency_id,route_short_name,route_type
+R1,A1,` + strings.Repeat("x", 51) + `,0
+`},
+			want: []string{"error routes.txt:2 route_short_name value_too_long"},
+		},
+		{
+			name: "missing route name",
+			files: map[string]string{"routes.txt": `route_id,agency_id,route_type
+R1,A1,0
+`},
+			want: []string{"error routes.txt:2 route_short_name missing_route_name"},
+		},
+	})
+}
+
+func TestValidateEnums(t *testing.T) {
+	runCases(t, []validateCase{
+		{
+			name: "route types",
+			files: map[string]string{"routes.txt": `route_id,agency_id,route_short_name,route_type
+R1,A1,1,99
+R2,A1,2,700
+R3,A1,3,x
+`},
+			want: []string{
+				"error routes.txt:2 route_type invalid_enum_value",
+				"error routes.txt:4 route_type invalid_integer",
+			},
+		},
+		{
+			name: "direction and accessibility",
+			files: map[string]string{"trips.txt": `route_id,service_id,trip_id,direction_id,wheelchair_accessible
+R1,WK,T1,2,1
+R1,WK,T2,1,3
+`},
+			want: []string{
+				"error trips.txt:2 direction_id invalid_enum_value",
+				"error trips.txt:3 wheelchair_accessible invalid_enum_value",
+			},
+		},
+		{
+			name: "calendar flags and exception types",
+			files: map[string]string{
+				"calendar.txt": `service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date
+WK,1,1,1,1,1,0,2,20250101,20251231
+`,
+				"calendar_dates.txt": `service_id,date,exception_type
+WK,20251225,3
+`,
+			},
+			want: []string{
+				"error calendar.txt:2 sunday invalid_enum_value",
+				"error calendar_dates.txt:2 exception_type invalid_enum_value",
+			},
+		},
+		{
+			name: "minimum values",
+			files: map[string]string{"shapes.txt": `shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence
+SH1,-37.8101,144.9601,-1
+SH1,-37.82,144.97,2
+`},
+			want: []string{"error shapes.txt:2 shape_pt_sequence value_out_of_range"},
+		},
+		{
+			name: "colours, URLs and timezones",
+			files: map[string]string{
+				"agency.txt": `agency_id,agency_name,agency_url,agency_timezone
+A1,Metro,example.com,Australia/Springfield
+`,
+				"routes.txt": `route_id,agency_id,route_short_name,route_type,route_color
+R1,A1,1,0,red
+`,
+			},
+			want: []string{
+				"warning agency.txt:2 agency_url invalid_url",
+				"error agency.txt:2 agency_timezone invalid_timezone",
+				"error routes.txt:2 route_color invalid_color",
+			},
+		},
+	})
+}
+
+func TestValidateTimes(t *testing.T) {
+	runCases(t, []validateCase{
+		{
+			name: "invalid times",
+			files: map[string]string{"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+T1,8:00,08:00:00,S1,1
+T1,08:10:00,08:60:00,S2,2
+T2,23:55:00,23:55:00,S2,1
+T2,124:05:00,124:05:00,S1,2
+`},
+			want: []string{
+				"error stop_times.txt:2 arrival_time invalid_time",
+				"error stop_times.txt:3 departure_time invalid_time",
+			},
+		},
+		{
+			name: "departure before arrival",
+			files: map[string]string{"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+T1,08:00:00,08:00:00,S1,1
+T1,08:10:00,08:09:59,S2,2
+T2,23:55:00,23:55:00,S2,1
+T2,24:05:00,24:05:00,S1,2
+`},
+			want: []string{"error stop_times.txt:3 departure_time departure_before_arrival"},
+		},
+		{
+			name: "arrival without departure",
+			files: map[string]string{"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+T1,08:00:00,08:00:00,S1,1
+T1,,,S2,2
+T2,23:55:00,,S2,1
+T2,24:05:00,24:05:00,S1,2
+`},
+			want: []string{"warning stop_times.txt:4 arrival_time incomplete_times"},
+		},
+		{
+			name: "dates",
+			files: map[string]string{
+				"calendar.txt": `service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date
+WK,1,1,1,1,1,0,0,20251231,20250101
+`,
+				"calendar_dates.txt": `service_id,date,exception_type
+WK,2025-12-25,2
+WK,20250230,2
+`,
+			},
+			want: []string{
+				"error calendar.txt:2 end_date invalid_date_range",
+				"error calendar_dates.txt:2 date invalid_date",
+				"error calendar_dates.txt:3 date invalid_date",
+			},
+		},
+	})
+}
+
+func TestValidateReferences(t *testing.T) {
+	runCases(t, []validateCase{
+		{
+			name: "trips",
+			files: map[string]string{"trips.txt": `route_id,service_id,trip_id,shape_id
+R9,WK,T1,SH1
+R1,SA,T2,SH9
+`},
+			want: []string{
+				"error trips.txt:2 route_id dangling_reference",
+				"error trips.txt:3 service_id dangling_reference",
+				// The schema has no foreign key on shape_id
+				"warning trips.txt:3 shape_id dangling_reference",
+			},
+		},
+		{
+			name: "stop times",
+			files: map[string]string{"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+T1,08:00:00,08:00:00,S1,1
+T1,08:10:00,08:10:00,S9,2
+T2,23:55:00,23:55:00,S2,1
+T9,24:05:00,24:05:00,S1,2
+`},
+			want: []string{
+				"error stop_times.txt:3 stop_id dangling_reference",
+				"error stop_times.txt:5 trip_id dangling_reference",
+			},
+		},
+		{
+			name: "routes",
+			files: map[string]string{"routes.txt": `route_id,agency_id,route_short_name,route_type
+R1,A9,1,0
+`},
+			want: []string{"error routes.txt:2 agency_id dangling_reference"},
+		},
+		{
+			name: "route without agency in a multi-agency feed",
+			files: map[string]string{
+				"agency.txt": `agency_id,agency_name,agency_url,agency_timezone
+A1,Metro,https://example.com,Australia/Melbourne
+A2,Regional,https://example.com,Australia/Melbourne
+`,
+				"routes.txt": `route_id,agency_id,route_short_name,route_type
+R1,,1,0
+`,
+			},
+			want: []string{"error routes.txt:2 agency_id missing_required_field"},
+		},
+		{
+			name: "parent stations",
+			files: map[string]string{"stops.txt": `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station
+ST,Central,-37.81,144.96,1,ST
+S1,Central Platform 1,-37.8101,144.9601,0,ST9
+S2,Park St,-37.82,144.97,0,S1
+E1,Central Entrance,-37.8102,144.9602,2,
+`},
+			want: []string{
+				"error stops.txt:2 parent_station forbidden_parent_station",
+				"error stops.txt:3 parent_station dangling_reference",
+				"warning stops.txt:4 parent_station invalid_parent_type",
+				"error stops.txt:5 parent_station missing_required_field",
+			},
+		},
+		{
+			name: "transfers",
+			files: map[string]string{"transfers.txt": `from_stop_id,to_stop_id,from_route_id,to_trip_id,transfer_type
+S1,S9,R1,T1,0
+S1,,R9,T9,0
+`},
+			want: []string{
+				"error transfers.txt:2 to_stop_id dangling_reference",
+				"error transfers.txt:3 to_stop_id missing_required_field",
+				"error transfers.txt:3 from_route_id dangling_reference",
+				"error transfers.txt:3 to_trip_id dangling_reference",
+			},
+		},
+	})
+}
+
+func TestValidateDuplicates(t *testing.T) {
+	runCases(t, []validateCase{
+		{
+			name: "stops",
+			files: map[string]string{"stops.txt": baseFeed["stops.txt"] + `S2,Park St,-37.82,144.97,0,
+`},
+			want: []string{"error stops.txt:5  duplicate_key"},
+		},
+		{
+			name: "services",
+			files: map[string]string{
+				"calendar.txt": baseFeed["calendar.txt"] + `WK,0,0,0,0,0,1,1,20250101,20251231
+`,
+				"calendar_dates.txt": baseFeed["calendar_dates.txt"] + `WK,20251225,1
+`,
+			},
+			want: []string{
+				"error calendar.txt:3 service_id duplicate_key",
+				"error calendar_dates.txt:3  duplicate_key",
+			},
+		},
+		{
+			name: "sequences",
+			files: map[string]string{
+				"shapes.txt": baseFeed["shapes.txt"] + `SH1,-37.83,144.98,2
+`,
+				// Sequences are compared within each trip's run of rows
+				"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+T1,08:00:00,08:00:00,S1,1
+T1,08:10:00,08:10:00,S2,2
+T1,08:20:00,08:20:00,S1,2
+T2,23:55:00,23:55:00,S2,1
+T2,24:05:00,24:06:00,S1,2
+`,
+			},
+			want: []string{
+				"error shapes.txt:4 shape_pt_sequence duplicate_key",
+				"error stop_times.txt:4 stop_sequence duplicate_key",
+			},
+		},
+		{
+			name: "trips",
+			files: map[string]string{"trips.txt": baseFeed["trips.txt"] + `R1,WK,T1,0,SH1
+`},
+			want: []string{"error trips.txt:4  duplicate_key"},
+		},
+	})
+}
+
+func TestValidateCoordinates(t *testing.T) {
+	runCases(t, []validateCase{
+		{
+			name: "stops",
+			files: map[string]string{"stops.txt": `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station
+ST,Central,95,144.96,1,
+S1,Central Platform 1,144.9601,-37.8101,0,ST
+S2,Park St,0,0,0,
+S3,Flinders St,,144.97,0,
+S4,Southern Cross,abc,144.95,0,
+N1,Concourse,,,3,ST
+`},
+			want: []string{
+				"error stops.txt:2 stop_lat coordinate_out_of_range",
+				"error stops.txt:3 stop_lat coordinate_out_of_range",
+				"warning stops.txt:3 stop_lat swapped_coordinates",
+				"warning stops.txt:4 stop_lat null_island",
+				"error stops.txt:5 stop_lat missing_coordinates",
+				"error stops.txt:6 stop_lat invalid_float",
+			},
+		},
+		{
+			name: "shapes",
+			files: map[string]string{"shapes.txt": `shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence
+SH1,-37.8101,200,1
+SH1,-37.82,,2
+`},
+			want: []string{
+				"error shapes.txt:2 shape_pt_lon coordinate_out_of_range",
+				"error shapes.txt:3 shape_pt_lon missing_required_field",
+			},
+		},
+	})
+}
+
+func TestTripsWithoutStopTimes(t *testing.T) {
+	trips := baseFeed["trips.txt"] + `R1,WK,T9,0,SH1
+R1,WK,T3,0,SH1
+R1,WK,T5,0,SH1
+`
+	report := validate(t, feed(map[string]string{"trips.txt": trips}), DefaultOptions())
+
+	// Reported in trip ID order, not file or map order
+	want := []string{
+		"warning trips.txt:5 trip_id trip_without_stop_times",
+		"warning trips.txt:6 trip_id trip_without_stop_times",
+		"warning trips.txt:4 trip_id trip_without_stop_times",
+	}
+	if got := issueKeys(report.Issues); !reflect.DeepEqual(got, want) {
+		t.Errorf("issues = %v, want %v", got, want)
+	}
+
+	// The issues kept under the cap are the same every run
+	for range 5 {
+		report = validate(t, feed(map[string]string{"trips.txt": trips}), Options{MaxIssuesPerCode: 1})
+		if got := issueKeys(report.Issues); !reflect.DeepEqual(got, want[:1]) {
+			t.Fatalf("issues = %v, want %v", got, want[:1])
+		}
+		if report.WarningCount != 3 || report.SuppressedIssues != 2 {
+			t.Fatalf("%d warnings, %d suppressed, want 3 and 2", report.WarningCount, report.SuppressedIssues)
+		}
+	}
+}
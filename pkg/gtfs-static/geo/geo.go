@@ -0,0 +1,183 @@
+// Package geo builds compact line geometries from GTFS shape points:
+// Douglas-Peucker simplification with a tolerance in metres, Google encoded
+// polylines and GeoJSON LineStrings.
+//
+// Simplification measures distances on an equirectangular projection around
+// each segment's start, which is plenty accurate for deciding which points to
+// drop and much cheaper than great-circle maths.
+package geo
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+const earthRadius = 6371008.8 // mean radius in metres
+
+// Point is a WGS84 coordinate in degrees
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// Distance returns the great-circle distance between a and b in metres
+func Distance(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLon := radians(b.Lon - a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Length returns the length of a line in metres
+func Length(points []Point) float64 {
+	var total float64
+	for i := 1; i < len(points); i++ {
+		total += Distance(points[i-1], points[i])
+	}
+	return total
+}
+
+// Bounds returns the south-west and north-east corners of points
+func Bounds(points []Point) (sw, ne Point) {
+	if len(points) == 0 {
+		return Point{}, Point{}
+	}
+
+	sw, ne = points[0], points[0]
+	for _, p := range points[1:] {
+		sw.Lat = math.Min(sw.Lat, p.Lat)
+		sw.Lon = math.Min(sw.Lon, p.Lon)
+		ne.Lat = math.Max(ne.Lat, p.Lat)
+		ne.Lon = math.Max(ne.Lon, p.Lon)
+	}
+	return sw, ne
+}
+
+// Simplify reduces a line with the Douglas-Peucker algorithm, dropping
+// points closer than tolerance metres to the simplified line. The first and
+// last points are always kept; a tolerance of zero or less only removes
+// consecutive duplicates.
+func Simplify(points []Point, tolerance float64) []Point {
+	points = dedupe(points)
+	if tolerance <= 0 || len(points) < 3 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// An explicit stack instead of recursion; shapes can have tens of
+	// thousands of points
+	type span struct{ first, last int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDist := -1, tolerance
+		for i := s.first + 1; i < s.last; i++ {
+			if d := segmentDistance(points[i], points[s.first], points[s.last]); d > maxDist {
+				farthest, maxDist = i, d
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+
+		keep[farthest] = true
+		stack = append(stack, span{s.first, farthest}, span{farthest, s.last})
+	}
+
+	simplified := make([]Point, 0, len(points)/4+2)
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// EncodePolyline encodes points in Google's encoded polyline format with
+// five decimal places of precision
+func EncodePolyline(points []Point) string {
+	var b strings.Builder
+	b.Grow(len(points) * 8)
+
+	var prevLat, prevLon int64
+	for _, p := range points {
+		lat := int64(math.Round(p.Lat * 1e5))
+		lon := int64(math.Round(p.Lon * 1e5))
+		encodeValue(&b, lat-prevLat)
+		encodeValue(&b, lon-prevLon)
+		prevLat, prevLon = lat, lon
+	}
+	return b.String()
+}
+
+// GeoJSON returns points as a GeoJSON LineString, whose coordinates are
+// [lon, lat] pairs
+func GeoJSON(points []Point) ([]byte, error) {
+	coords := make([][2]float64, len(points))
+	for i, p := range points {
+		coords[i] = [2]float64{p.Lon, p.Lat}
+	}
+
+	return json.Marshal(struct {
+		Type        string       `json:"type"`
+		Coordinates [][2]float64 `json:"coordinates"`
+	}{"LineString", coords})
+}
+
+func encodeValue(b *strings.Builder, v int64) {
+	// Zig-zag the sign into the lowest bit, then emit 5-bit chunks from the
+	// low end, each but the last flagged with 0x20, offset into printable ASCII
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte(0x20|(u&0x1f)) + 63)
+		u >>= 5
+	}
+	b.WriteByte(byte(u) + 63)
+}
+
+// segmentDistance returns the distance in metres from p to the segment a-b
+func segmentDistance(p, a, b Point) float64 {
+	// Project onto a plane centred on a, in metres
+	scale := earthRadius * math.Pi / 180
+	cosLat := math.Cos(radians(a.Lat))
+	px, py := (p.Lon-a.Lon)*cosLat*scale, (p.Lat-a.Lat)*scale
+	bx, by := (b.Lon-a.Lon)*cosLat*scale, (b.Lat-a.Lat)*scale
+
+	lengthSq := bx*bx + by*by
+	if lengthSq == 0 {
+		return math.Hypot(px, py)
+	}
+
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/lengthSq))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+func dedupe(points []Point) []Point {
+	if len(points) < 2 {
+		return points
+	}
+
+	out := make([]Point, 0, len(points))
+	out = append(out, points[0])
+	for _, p := range points[1:] {
+		if p != out[len(out)-1] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
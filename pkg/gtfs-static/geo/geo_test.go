@@ -0,0 +1,120 @@
+package geo
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEncodePolyline(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Point
+		want   string
+	}{
+		{"empty", nil, ""},
+		// The example from Google's encoded polyline algorithm format documentation
+		{"google example", []Point{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}, "_p~iF~ps|U_ulLnnqC_mqNvxq`@"},
+		{"origin", []Point{{0, 0}}, "??"},
+		{"rounds to five places", []Point{{-37.818066, 144.966994}}, "|iyeFuzxsZ"},
+		{"repeated point", []Point{{-37.81, 144.96}, {-37.81, 144.96}}, "nwweF_owsZ??"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EncodePolyline(tt.points); got != tt.want {
+				t.Errorf("EncodePolyline() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// edge returns the points from a to b, steps apart, without b
+func edge(a, b Point, steps int) []Point {
+	points := make([]Point, steps)
+	for i := range points {
+		f := float64(i) / float64(steps)
+		points[i] = Point{a.Lat + (b.Lat-a.Lat)*f, a.Lon + (b.Lon-a.Lon)*f}
+	}
+	return points
+}
+
+func TestSimplify(t *testing.T) {
+	a, b := Point{-37.80, 144.96}, Point{-37.80, 144.97}
+	straight := append(edge(a, b, 10), b)
+
+	// A spike about 11 m off the straight line; its neighbours are about 9 m
+	// off the lines to it
+	bent := append([]Point(nil), straight...)
+	bent[5].Lat -= 0.0001
+
+	tests := []struct {
+		name      string
+		points    []Point
+		tolerance float64
+		want      []Point
+	}{
+		{"empty", nil, 5, nil},
+		{"single point", []Point{a}, 5, []Point{a}},
+		{"two points", []Point{a, b}, 5, []Point{a, b}},
+		{"straight line", straight, 5, []Point{a, b}},
+		{"spike above tolerance", bent, 10, []Point{a, bent[5], b}},
+		{"spike and neighbours above tolerance", bent, 5, []Point{a, bent[4], bent[5], bent[6], b}},
+		{"spike below tolerance", bent, 20, []Point{a, b}},
+		{"zero tolerance keeps every point", bent, 0, bent},
+		{"duplicates", []Point{a, a, b, b, b}, 0, []Point{a, b}},
+		{"duplicates with tolerance", []Point{a, a, b, b}, 5, []Point{a, b}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Simplify(tt.points, tt.tolerance); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Simplify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A loop ends where it starts, so its first span has no length; the farthest
+// point from the start must still be found instead of everything collapsing
+// to the two ends
+func TestSimplifyClosedLoop(t *testing.T) {
+	corners := []Point{
+		{-37.80, 144.96},
+		{-37.80, 144.97},
+		{-37.81, 144.97},
+		{-37.81, 144.96},
+	}
+
+	var loop []Point
+	for i, c := range corners {
+		loop = append(loop, edge(c, corners[(i+1)%len(corners)], 10)...)
+	}
+	loop = append(loop, corners[0])
+
+	want := append(append([]Point(nil), corners...), corners[0])
+	if got := Simplify(loop, 5); !reflect.DeepEqual(got, want) {
+		t.Errorf("Simplify() = %v, want the corners %v", got, want)
+	}
+
+	if got := Simplify(loop, 0); len(got) != len(loop) {
+		t.Errorf("Simplify() with zero tolerance kept %d of %d points", len(got), len(loop))
+	}
+
+	// The encoded loop returns to its start, so its offsets sum to zero
+	if got, wantEncoded := EncodePolyline(want), "~xueF_owsZ?o}@n}@??n}@o}@?"; got != wantEncoded {
+		t.Errorf("EncodePolyline() = %q, want %q", got, wantEncoded)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// A degree of latitude is about 111.2 km anywhere
+	if d := Distance(Point{-37, 145}, Point{-38, 145}); math.Abs(d-111195) > 1 {
+		t.Errorf("Distance() = %.0f m, want about 111195 m", d)
+	}
+
+	line := []Point{{-37.80, 144.96}, {-37.81, 144.96}, {-37.80, 144.96}}
+	if l, d := Length(line), Distance(line[0], line[1]); math.Abs(l-2*d) > 1e-6 {
+		t.Errorf("Length() = %f, want %f", l, 2*d)
+	}
+}
@@ -0,0 +1,100 @@
+// Package gtfstime converts GTFS schedule times to absolute instants.
+//
+// GTFS stop times are measured from "noon minus 12h" on the service date in
+// the agency's timezone, not from midnight. On most days the two are the
+// same, but on daylight saving transition days noon minus 12h is 23:00 or
+// 01:00 local time, so a naive midnight-based conversion is an hour out.
+// Times may exceed 24:00:00 for trips that run past midnight.
+package gtfstime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseTime parses a GTFS H:MM:SS or HH:MM:SS time into seconds since the
+// start of the service day. Hours may be 24 or more.
+func ParseTime(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid GTFS time %q", s)
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 {
+		return 0, fmt.Errorf("invalid hours in GTFS time %q", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid minutes in GTFS time %q", s)
+	}
+	sec, err := strconv.Atoi(parts[2])
+	if err != nil || sec < 0 || sec > 59 {
+		return 0, fmt.Errorf("invalid seconds in GTFS time %q", s)
+	}
+
+	return h*3600 + m*60 + sec, nil
+}
+
+// FormatTime renders seconds since the start of the service day as
+// HH:MM:SS, keeping hours past 24
+func FormatTime(seconds int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, (seconds%3600)/60, seconds%60)
+}
+
+// ParseServiceDate parses a GTFS YYYYMMDD date as a calendar date in loc
+func ParseServiceDate(s string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("20060102", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid GTFS date %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// ServiceDate returns the calendar date of t in loc, at midnight
+func ServiceDate(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// ServiceDayStart returns the instant GTFS times on serviceDate are measured
+// from: noon minus 12 hours in loc. Only the year, month and day of
+// serviceDate are used.
+func ServiceDayStart(serviceDate time.Time, loc *time.Location) time.Time {
+	noon := time.Date(serviceDate.Year(), serviceDate.Month(), serviceDate.Day(), 12, 0, 0, 0, loc)
+	return noon.Add(-12 * time.Hour)
+}
+
+// Resolve converts a stop time on serviceDate to a UTC instant
+func Resolve(serviceDate time.Time, seconds int, loc *time.Location) time.Time {
+	return ServiceDayStart(serviceDate, loc).Add(time.Duration(seconds) * time.Second).UTC()
+}
+
+// ResolveInZone is Resolve with the agency_timezone name, e.g. "Australia/Melbourne"
+func ResolveInZone(serviceDate time.Time, seconds int, timezone string) (time.Time, error) {
+	loc, err := LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return Resolve(serviceDate, seconds, loc), nil
+}
+
+var locations sync.Map // timezone name -> *time.Location
+
+// LoadLocation is time.LoadLocation with a cache, since agency timezones are
+// looked up for every stop time
+func LoadLocation(timezone string) (*time.Location, error) {
+	if loc, ok := locations.Load(timezone); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("loading timezone %q: %w", timezone, err)
+	}
+	locations.Store(timezone, loc)
+	return loc, nil
+}
@@ -0,0 +1,203 @@
+package gtfstime
+
+import (
+	"testing"
+	"time"
+)
+
+func melbourne(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := LoadLocation("Australia/Melbourne")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"00:00:00", 0},
+		{"8:05:09", 8*3600 + 5*60 + 9},
+		{"08:05:09", 8*3600 + 5*60 + 9},
+		{" 08:05:09 ", 8*3600 + 5*60 + 9},
+		{"23:59:59", 86399},
+		{"24:00:00", 86400},
+		{"25:30:15", 25*3600 + 30*60 + 15},
+		{"47:59:59", 47*3600 + 59*60 + 59},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTime(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"08:00",
+		"08:00:00:00",
+		"0800:00",
+		"aa:00:00",
+		"-1:00:00",
+		"08:60:00",
+		"08:-1:00",
+		"08:0x:00",
+		"08:00:60",
+		"08:00:",
+		"8.5:00:00",
+	} {
+		if got, err := ParseTime(in); err == nil {
+			t.Errorf("ParseTime(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	for _, in := range []string{"00:00:00", "08:05:09", "24:00:00", "25:30:15"} {
+		seconds, err := ParseTime(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatTime(seconds); got != in {
+			t.Errorf("FormatTime(%d) = %q, want %q", seconds, got, in)
+		}
+	}
+}
+
+// Melbourne moves to daylight saving time at 02:00 on the first Sunday of
+// October and back at 03:00 on the first Sunday of April. On those days noon
+// minus 12h is not midnight but 23:00 the evening before in October and 01:00
+// in April.
+func TestResolve(t *testing.T) {
+	loc := melbourne(t)
+
+	tests := []struct {
+		name string
+		date string
+		time string
+		want string // UTC
+	}{
+		{"standard time", "20250701", "08:00:00", "2025-06-30T22:00:00Z"},
+		{"daylight time", "20250115", "08:00:00", "2025-01-14T21:00:00Z"},
+		{"standard time past midnight", "20250701", "24:00:00", "2025-07-01T14:00:00Z"},
+		{"standard time past midnight", "20250701", "25:30:15", "2025-07-01T15:30:15Z"},
+
+		// 2025-10-05: the day starts at 23:00 AEST on the 4th
+		{"DST start day start", "20251005", "00:00:00", "2025-10-04T13:00:00Z"},
+		{"DST start before the change", "20251005", "01:30:00", "2025-10-04T14:30:00Z"},
+		{"DST start morning", "20251005", "08:00:00", "2025-10-04T21:00:00Z"},
+		{"DST start noon", "20251005", "12:00:00", "2025-10-05T01:00:00Z"},
+		{"DST start past midnight", "20251005", "24:00:00", "2025-10-05T13:00:00Z"},
+
+		// The trip leaves the day before and runs into daylight time
+		{"night before DST start", "20251004", "24:00:00", "2025-10-04T14:00:00Z"},
+		{"night before DST start, after the change", "20251004", "26:30:00", "2025-10-04T16:30:00Z"},
+
+		// 2026-04-05: the day starts at 01:00 AEDT
+		{"DST end day start", "20260405", "00:00:00", "2026-04-04T14:00:00Z"},
+		{"DST end morning", "20260405", "08:00:00", "2026-04-04T22:00:00Z"},
+		{"DST end noon", "20260405", "12:00:00", "2026-04-05T02:00:00Z"},
+		{"DST end past midnight", "20260405", "24:00:00", "2026-04-05T14:00:00Z"},
+
+		// The trip leaves in daylight time and runs past the change
+		{"night before DST end", "20260404", "24:00:00", "2026-04-04T13:00:00Z"},
+		{"night before DST end, after the change", "20260404", "27:30:00", "2026-04-04T16:30:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.date+" "+tt.time, func(t *testing.T) {
+			date, err := ParseServiceDate(tt.date, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seconds, err := ParseTime(tt.time)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := time.Parse(time.RFC3339, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := Resolve(date, seconds, loc)
+			if !got.Equal(want) || got.Location() != time.UTC {
+				t.Errorf("Resolve(%s, %s) = %s, want %s", tt.date, tt.time, got, want)
+			}
+
+			zoned, err := ResolveInZone(date, seconds, "Australia/Melbourne")
+			if err != nil || !zoned.Equal(want) {
+				t.Errorf("ResolveInZone(%s, %s) = %s, %v, want %s", tt.date, tt.time, zoned, err, want)
+			}
+		})
+	}
+}
+
+func TestServiceDayStart(t *testing.T) {
+	loc := melbourne(t)
+
+	tests := []struct {
+		date string
+		want string // local time
+	}{
+		{"20250701", "2025-07-01T00:00:00+10:00"},
+		{"20251005", "2025-10-04T23:00:00+10:00"},
+		{"20260405", "2026-04-05T01:00:00+11:00"},
+	}
+	for _, tt := range tests {
+		date, err := ParseServiceDate(tt.date, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := time.Parse(time.RFC3339, tt.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ServiceDayStart(date, loc); !got.Equal(want) {
+			t.Errorf("ServiceDayStart(%s) = %s, want %s", tt.date, got, want)
+		}
+	}
+
+	// Only the calendar date matters, not the time of day or its zone
+	late := time.Date(2025, 10, 5, 22, 15, 0, 0, time.UTC)
+	if got, want := ServiceDayStart(late, loc), time.Date(2025, 10, 4, 13, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("ServiceDayStart(%s) = %s, want %s", late, got, want)
+	}
+}
+
+func TestServiceDate(t *testing.T) {
+	loc := melbourne(t)
+
+	tests := []struct {
+		at   string // UTC
+		want string
+	}{
+		{"2025-10-04T13:30:00Z", "20251004"}, // 23:30 AEST
+		{"2025-10-04T14:30:00Z", "20251005"}, // 00:30 AEST
+		{"2025-10-04T16:30:00Z", "20251005"}, // 03:30 AEDT
+		{"2026-04-04T13:30:00Z", "20260405"}, // 00:30 AEDT
+	}
+	for _, tt := range tests {
+		at, err := time.Parse(time.RFC3339, tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ServiceDate(at, loc)
+		if got.Format("20060102") != tt.want || got.Hour() != 0 || got.Location() != loc {
+			t.Errorf("ServiceDate(%s) = %s, want midnight on %s", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestParseServiceDate(t *testing.T) {
+	for _, in := range []string{"", "2025-07-01", "20251301", "20250230", "2025070"} {
+		if _, err := ParseServiceDate(in, time.UTC); err == nil {
+			t.Errorf("ParseServiceDate(%q) succeeded, want an error", in)
+		}
+	}
+	if _, err := ResolveInZone(time.Now(), 0, "Not/AZone"); err == nil {
+		t.Error("ResolveInZone with an unknown timezone succeeded")
+	}
+}
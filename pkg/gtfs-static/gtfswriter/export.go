@@ -0,0 +1,492 @@
+package gtfswriter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ptvtracker-data/pkg/gtfs-static/gtfstime"
+)
+
+// ExtensionPrefix starts the name of every file holding a derived table, so
+// consumers that only know the spec ignore them
+const ExtensionPrefix = "ext_"
+
+// Options controls what Export writes
+type Options struct {
+	// VersionID is the version to export
+	VersionID int
+
+	// SourceIDs limits the export to these sources; empty exports every
+	// source with data in the version
+	SourceIDs []int
+
+	// PrefixIDs writes every ID as "<source_id>:<id>". IDs are only unique
+	// within a source, so exports of several sources need it to stay valid.
+	PrefixIDs bool
+
+	// Extensions also writes the tables derived at import, such as route
+	// patterns and the stop hierarchy, as ext_*.txt files
+	Extensions bool
+}
+
+// Summary describes a finished export
+type Summary struct {
+	VersionID int
+	SourceIDs []int
+	Files     []FileSummary
+}
+
+// FileSummary is one file of an export
+type FileSummary struct {
+	Name string
+	Rows int
+}
+
+type columnKind int
+
+const (
+	plainColumn   columnKind = iota
+	idColumn                 // prefixed with the source ID when PrefixIDs is set
+	dateColumn               // DATE, written as YYYYMMDD
+	timeColumn               // seconds since the start of the service day, written as HH:MM:SS
+	decimalColumn            // NUMERIC, written without trailing zeros
+)
+
+type column struct {
+	name   string // GTFS header
+	source string // database column, when it differs from name
+	kind   columnKind
+}
+
+// table maps a database table to a GTFS file
+type table struct {
+	file      string
+	name      string
+	columns   []column
+	orderBy   string
+	required  bool // written even when empty
+	extension bool
+	extra     bool // has an extra JSONB column of non-standard fields
+}
+
+// tables lists the files in the order they are written, standard files first
+var tables = []table{
+	{file: "agency.txt", name: "agency", required: true, extra: true, orderBy: "agency_id", columns: []column{
+		{name: "agency_id", kind: idColumn},
+		{name: "agency_name"},
+		{name: "agency_url"},
+		{name: "agency_timezone"},
+		{name: "agency_lang"},
+		{name: "agency_fare_url"},
+	}},
+	{file: "stops.txt", name: "stops", required: true, extra: true, orderBy: "stop_id", columns: []column{
+		{name: "stop_id", kind: idColumn},
+		{name: "stop_name"},
+		{name: "stop_lat", kind: decimalColumn},
+		{name: "stop_lon", kind: decimalColumn},
+		{name: "location_type"},
+		{name: "parent_station", kind: idColumn},
+		{name: "wheelchair_boarding"},
+		{name: "level_id", kind: idColumn},
+	}},
+	{file: "routes.txt", name: "routes", required: true, extra: true, orderBy: "route_id", columns: []column{
+		{name: "route_id", kind: idColumn},
+		{name: "agency_id", kind: idColumn},
+		{name: "route_short_name"},
+		{name: "route_long_name"},
+		{name: "route_type"},
+		{name: "route_color"},
+		{name: "route_text_color"},
+	}},
+	{file: "trips.txt", name: "trips", required: true, extra: true, orderBy: "trip_id", columns: []column{
+		{name: "route_id", kind: idColumn},
+		{name: "service_id", kind: idColumn},
+		{name: "trip_id", kind: idColumn},
+		{name: "trip_headsign"},
+		{name: "direction_id"},
+		{name: "block_id", kind: idColumn},
+		{name: "shape_id", kind: idColumn},
+		{name: "wheelchair_accessible"},
+	}},
+	{file: "stop_times.txt", name: "stop_times", required: true, extra: true, orderBy: "trip_id, stop_sequence", columns: []column{
+		{name: "trip_id", kind: idColumn},
+		{name: "arrival_time", source: "arrival_time_seconds", kind: timeColumn},
+		{name: "departure_time", source: "departure_time_seconds", kind: timeColumn},
+		{name: "stop_id", kind: idColumn},
+		{name: "stop_sequence"},
+		{name: "stop_headsign"},
+		{name: "pickup_type"},
+		{name: "drop_off_type"},
+		{name: "shape_dist_traveled", kind: decimalColumn},
+	}},
+	{file: "calendar.txt", name: "calendar", extra: true, orderBy: "service_id", columns: []column{
+		{name: "service_id", kind: idColumn},
+		{name: "monday"},
+		{name: "tuesday"},
+		{name: "wednesday"},
+		{name: "thursday"},
+		{name: "friday"},
+		{name: "saturday"},
+		{name: "sunday"},
+		{name: "start_date", kind: dateColumn},
+		{name: "end_date", kind: dateColumn},
+	}},
+	{file: "calendar_dates.txt", name: "calendar_dates", extra: true, orderBy: "service_id, date", columns: []column{
+		{name: "service_id", kind: idColumn},
+		{name: "date", kind: dateColumn},
+		{name: "exception_type"},
+	}},
+	{file: "shapes.txt", name: "shapes", extra: true, orderBy: "shape_id, shape_pt_sequence", columns: []column{
+		{name: "shape_id", kind: idColumn},
+		{name: "shape_pt_lat", kind: decimalColumn},
+		{name: "shape_pt_lon", kind: decimalColumn},
+		{name: "shape_pt_sequence"},
+		{name: "shape_dist_traveled", kind: decimalColumn},
+	}},
+	{file: "levels.txt", name: "levels", extra: true, orderBy: "level_id", columns: []column{
+		{name: "level_id", kind: idColumn},
+		{name: "level_index", kind: decimalColumn},
+		{name: "level_name"},
+	}},
+	{file: "pathways.txt", name: "pathways", extra: true, orderBy: "pathway_id", columns: []column{
+		{name: "pathway_id", kind: idColumn},
+		{name: "from_stop_id", kind: idColumn},
+		{name: "to_stop_id", kind: idColumn},
+		{name: "pathway_mode"},
+		{name: "is_bidirectional"},
+		{name: "traversal_time"},
+	}},
+	{file: "transfers.txt", name: "transfers", extra: true, orderBy: "from_stop_id, to_stop_id, from_route_id, to_route_id, from_trip_id, to_trip_id", columns: []column{
+		{name: "from_stop_id", kind: idColumn},
+		{name: "to_stop_id", kind: idColumn},
+		{name: "from_route_id", kind: idColumn},
+		{name: "to_route_id", kind: idColumn},
+		{name: "from_trip_id", kind: idColumn},
+		{name: "to_trip_id", kind: idColumn},
+		{name: "transfer_type"},
+		{name: "min_transfer_time"},
+	}},
+
+	// Derived tables. departure_instants is left out: it is a rolling window
+	// materialized for the active version, not part of the timetable.
+	{file: ExtensionPrefix + "service_dates.txt", name: "service_dates", extension: true, orderBy: "service_id, date", columns: []column{
+		{name: "service_id", kind: idColumn},
+		{name: "date", kind: dateColumn},
+	}},
+	{file: ExtensionPrefix + "shape_geometries.txt", name: "shape_geometries", extension: true, orderBy: "shape_id, tolerance_m", columns: []column{
+		{name: "shape_id", kind: idColumn},
+		{name: "tolerance_m", kind: decimalColumn},
+		{name: "point_count"},
+		{name: "length_m", kind: decimalColumn},
+		{name: "min_lat", kind: decimalColumn},
+		{name: "min_lon", kind: decimalColumn},
+		{name: "max_lat", kind: decimalColumn},
+		{name: "max_lon", kind: decimalColumn},
+		{name: "polyline"}, // the GeoJSON column holds the same points
+	}},
+	{file: ExtensionPrefix + "stop_hierarchy.txt", name: "stop_hierarchy", extension: true, orderBy: "stop_id", columns: []column{
+		{name: "stop_id", kind: idColumn},
+		{name: "station_id", kind: idColumn},
+		{name: "parent_id", kind: idColumn},
+		{name: "location_type"},
+		{name: "depth"},
+		{name: "cluster_id", kind: idColumn},
+	}},
+	{file: ExtensionPrefix + "route_patterns.txt", name: "route_patterns", extension: true, orderBy: "route_id, direction_id, pattern_id", columns: []column{
+		{name: "pattern_id", kind: idColumn},
+		{name: "route_id", kind: idColumn},
+		{name: "direction_id"},
+		{name: "stop_count"},
+		{name: "trip_count"},
+		{name: "headsign"},
+		{name: "shape_id", kind: idColumn},
+		{name: "first_stop_id", kind: idColumn},
+		{name: "last_stop_id", kind: idColumn},
+	}},
+	{file: ExtensionPrefix + "pattern_stops.txt", name: "pattern_stops", extension: true, orderBy: "pattern_id, stop_index", columns: []column{
+		{name: "pattern_id", kind: idColumn},
+		{name: "stop_index"},
+		{name: "stop_id", kind: idColumn},
+	}},
+	{file: ExtensionPrefix + "trip_patterns.txt", name: "trip_patterns", extension: true, orderBy: "trip_id", columns: []column{
+		{name: "trip_id", kind: idColumn},
+		{name: "pattern_id", kind: idColumn},
+	}},
+}
+
+// Export writes a version, or some of its sources, to w as a GTFS zip.
+// Non-standard columns kept at import are written back after the standard
+// ones. Times past midnight keep their hours past 24.
+func Export(ctx context.Context, db *sql.DB, w io.Writer, opts Options) (*Summary, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM gtfs.versions WHERE version_id = $1)", opts.VersionID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("checking version: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("version %d not found", opts.VersionID)
+	}
+
+	sources, err := VersionSources(ctx, db, opts.VersionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.SourceIDs) > 0 {
+		present := make(map[int]bool, len(sources))
+		for _, id := range sources {
+			present[id] = true
+		}
+		for _, id := range opts.SourceIDs {
+			if !present[id] {
+				return nil, fmt.Errorf("source %d has no data in version %d", id, opts.VersionID)
+			}
+		}
+		sources = append([]int(nil), opts.SourceIDs...)
+		sort.Ints(sources)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("version %d has no data to export", opts.VersionID)
+	}
+
+	summary := &Summary{VersionID: opts.VersionID, SourceIDs: sources}
+	zw := NewWriter(w)
+	for _, t := range tables {
+		if t.extension && !opts.Extensions {
+			continue
+		}
+
+		rows, err := exportTable(ctx, db, zw, t, sources, opts)
+		if err != nil {
+			return nil, err
+		}
+		if rows >= 0 {
+			summary.Files = append(summary.Files, FileSummary{Name: t.file, Rows: rows})
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// exportTable writes one table of every source to its file. The file is only
+// created once it has a row, unless the spec requires it; returns -1 when
+// nothing was written.
+func exportTable(ctx context.Context, db *sql.DB, zw *Writer, t table, sources []int, opts Options) (int, error) {
+	var extraKeys []string
+	if t.extra {
+		keys, err := extraColumns(ctx, db, t, sources, opts.VersionID)
+		if err != nil {
+			return 0, err
+		}
+		extraKeys = keys
+	}
+
+	header := make([]string, 0, len(t.columns)+len(extraKeys))
+	exprs := make([]string, 0, len(t.columns)+1)
+	for _, c := range t.columns {
+		header = append(header, c.name)
+		exprs = append(exprs, c.selectExpr())
+	}
+	header = append(header, extraKeys...)
+	if t.extra {
+		exprs = append(exprs, "COALESCE(extra::text, '')")
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM gtfs.%s WHERE version_id = $1 AND source_id = $2 ORDER BY %s",
+		strings.Join(exprs, ", "), t.name, t.orderBy)
+
+	var fw *FileWriter
+	for _, sourceID := range sources {
+		if err := func() error {
+			rows, err := db.QueryContext(ctx, query, opts.VersionID, sourceID)
+			if err != nil {
+				return fmt.Errorf("querying %s of source %d: %w", t.name, sourceID, err)
+			}
+			defer rows.Close()
+
+			values := make([]string, len(exprs))
+			dest := make([]any, len(exprs))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+
+			for rows.Next() {
+				if err := rows.Scan(dest...); err != nil {
+					return fmt.Errorf("scanning %s of source %d: %w", t.name, sourceID, err)
+				}
+
+				record, err := t.record(values, extraKeys, sourceID, opts.PrefixIDs)
+				if err != nil {
+					return fmt.Errorf("%s of source %d: %w", t.name, sourceID, err)
+				}
+
+				if fw == nil {
+					if fw, err = zw.Create(t.file, header); err != nil {
+						return err
+					}
+				}
+				if err := fw.Write(record); err != nil {
+					return err
+				}
+			}
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("iterating %s of source %d: %w", t.name, sourceID, err)
+			}
+			return nil
+		}(); err != nil {
+			return 0, err
+		}
+	}
+
+	if fw == nil {
+		if !t.required {
+			return -1, nil
+		}
+		var err error
+		if fw, err = zw.Create(t.file, header); err != nil {
+			return 0, err
+		}
+	}
+	return fw.Rows(), nil
+}
+
+// record converts a scanned row to the file's fields, appending the extra
+// columns in header order
+func (t table) record(values []string, extraKeys []string, sourceID int, prefixIDs bool) ([]string, error) {
+	record := make([]string, 0, len(t.columns)+len(extraKeys))
+	for i, c := range t.columns {
+		value, err := c.format(values[i], sourceID, prefixIDs)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, value)
+	}
+
+	if len(extraKeys) == 0 {
+		return record, nil
+	}
+
+	var extra map[string]string
+	if raw := values[len(t.columns)]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &extra); err != nil {
+			return nil, fmt.Errorf("decoding extra columns: %w", err)
+		}
+	}
+	for _, key := range extraKeys {
+		record = append(record, extra[key])
+	}
+	return record, nil
+}
+
+func (c column) selectExpr() string {
+	source := c.source
+	if source == "" {
+		source = c.name
+	}
+	if c.kind == dateColumn {
+		return fmt.Sprintf("COALESCE(to_char(%s, 'YYYYMMDD'), '')", source)
+	}
+	return fmt.Sprintf("COALESCE(%s::text, '')", source)
+}
+
+func (c column) format(value string, sourceID int, prefixIDs bool) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch c.kind {
+	case idColumn:
+		if prefixIDs {
+			return strconv.Itoa(sourceID) + ":" + value, nil
+		}
+	case timeColumn:
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s %q: %w", c.name, value, err)
+		}
+		return gtfstime.FormatTime(seconds), nil
+	case decimalColumn:
+		if strings.Contains(value, ".") {
+			value = strings.TrimRight(strings.TrimRight(value, "0"), ".")
+		}
+	}
+	return value, nil
+}
+
+// extraColumns returns the non-standard column names found in a table's
+// extra JSONB across the exported sources, sorted. Names that clash with a
+// standard column are dropped.
+func extraColumns(ctx context.Context, db *sql.DB, t table, sources []int, versionID int) ([]string, error) {
+	standard := make(map[string]bool, len(t.columns))
+	for _, c := range t.columns {
+		standard[c.name] = true
+	}
+
+	query := fmt.Sprintf(`
+		SELECT DISTINCT jsonb_object_keys(extra)
+		FROM gtfs.%s
+		WHERE version_id = $1 AND source_id = $2 AND extra IS NOT NULL
+	`, t.name)
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, sourceID := range sources {
+		if err := func() error {
+			rows, err := db.QueryContext(ctx, query, versionID, sourceID)
+			if err != nil {
+				return fmt.Errorf("querying extra columns of %s: %w", t.name, err)
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var key string
+				if err := rows.Scan(&key); err != nil {
+					return fmt.Errorf("scanning extra column of %s: %w", t.name, err)
+				}
+				if !seen[key] && !standard[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+			return rows.Err()
+		}(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// VersionSources returns the sources with agencies or stops in a version
+func VersionSources(ctx context.Context, db *sql.DB, versionID int) ([]int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT s.source_id
+		FROM gtfs.transport_sources s
+		WHERE EXISTS (SELECT 1 FROM gtfs.agency a WHERE a.version_id = $1 AND a.source_id = s.source_id)
+		   OR EXISTS (SELECT 1 FROM gtfs.stops st WHERE st.version_id = $1 AND st.source_id = s.source_id)
+		ORDER BY s.source_id
+	`, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("querying sources of version %d: %w", versionID, err)
+	}
+	defer rows.Close()
+
+	var sources []int
+	for rows.Next() {
+		var sourceID int
+		if err := rows.Scan(&sourceID); err != nil {
+			return nil, fmt.Errorf("scanning source: %w", err)
+		}
+		sources = append(sources, sourceID)
+	}
+	return sources, rows.Err()
+}
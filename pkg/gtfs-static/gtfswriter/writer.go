@@ -0,0 +1,104 @@
+// Package gtfswriter writes GTFS schedule data as a zip archive, either row
+// by row through Writer or straight from an imported version with Export.
+//
+// Files are plain UTF-8 CSV without a byte order mark, one per GTFS table,
+// at the root of the archive as the spec requires.
+package gtfswriter
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Writer writes GTFS files into a zip archive. Only one file is open at a
+// time; creating the next one finishes the previous.
+type Writer struct {
+	zip     *zip.Writer
+	current *FileWriter
+	files   map[string]bool
+}
+
+// NewWriter starts a GTFS zip on w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zip: zip.NewWriter(w), files: make(map[string]bool)}
+}
+
+// FileWriter writes the records of one GTFS file
+type FileWriter struct {
+	name    string
+	columns int
+	csv     *csv.Writer
+	rows    int
+}
+
+// Create starts a file named name, e.g. "stops.txt", and writes its header
+func (w *Writer) Create(name string, header []string) (*FileWriter, error) {
+	if w.files[name] {
+		return nil, fmt.Errorf("%s already written", name)
+	}
+	if err := w.finish(); err != nil {
+		return nil, err
+	}
+
+	entry, err := w.zip.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", name, err)
+	}
+
+	fw := &FileWriter{name: name, columns: len(header), csv: csv.NewWriter(entry)}
+	if err := fw.csv.Write(header); err != nil {
+		return nil, fmt.Errorf("writing %s header: %w", name, err)
+	}
+
+	w.files[name] = true
+	w.current = fw
+	return fw, nil
+}
+
+// Write adds a record, which must have a field for every header column
+func (fw *FileWriter) Write(record []string) error {
+	if len(record) != fw.columns {
+		return fmt.Errorf("%s: record has %d fields, header has %d", fw.name, len(record), fw.columns)
+	}
+	if err := fw.csv.Write(record); err != nil {
+		return fmt.Errorf("writing %s: %w", fw.name, err)
+	}
+	fw.rows++
+	return nil
+}
+
+// Rows returns the number of records written, not counting the header
+func (fw *FileWriter) Rows() int {
+	return fw.rows
+}
+
+// Close finishes the last file and the zip's central directory. It does not
+// close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+	if err := w.zip.Close(); err != nil {
+		return fmt.Errorf("closing zip: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) finish() error {
+	if w.current == nil {
+		return nil
+	}
+	w.current.csv.Flush()
+	if err := w.current.csv.Error(); err != nil {
+		return fmt.Errorf("writing %s: %w", w.current.name, err)
+	}
+	w.current = nil
+	return nil
+}
@@ -11,6 +11,7 @@ type Agency struct {
 	AgencyTimezone string
 	AgencyLang    string
 	AgencyFareURL string
+	Extra         map[string]string
 }
 
 type Stop struct {
@@ -22,6 +23,7 @@ type Stop struct {
 	ParentStation      string
 	WheelchairBoarding int
 	LevelID            string
+	Extra              map[string]string
 }
 
 type Route struct {
@@ -32,6 +34,7 @@ type Route struct {
 	RouteType      int
 	RouteColor     string
 	RouteTextColor string
+	Extra          map[string]string
 }
 
 type Trip struct {
@@ -43,6 +46,7 @@ type Trip struct {
 	DirectionID          int
 	BlockID              string
 	WheelchairAccessible int
+	Extra                map[string]string
 }
 
 type StopTime struct {
@@ -55,6 +59,7 @@ type StopTime struct {
 	PickupType         int
 	DropOffType        int
 	ShapeDistTraveled  float64
+	Extra              map[string]string
 }
 
 type Calendar struct {
@@ -68,12 +73,14 @@ type Calendar struct {
 	Sunday     int
 	StartDate  time.Time
 	EndDate    time.Time
+	Extra      map[string]string
 }
 
 type CalendarDate struct {
 	ServiceID     string
 	Date          time.Time
 	ExceptionType int
+	Extra         map[string]string
 }
 
 type Shape struct {
@@ -82,12 +89,14 @@ type Shape struct {
 	ShapePtLon        float64
 	ShapePtSequence   int
 	ShapeDistTraveled float64
+	Extra             map[string]string
 }
 
 type Level struct {
 	LevelID    string
 	LevelIndex float64
 	LevelName  string
+	Extra      map[string]string
 }
 
 type Pathway struct {
@@ -97,6 +106,7 @@ type Pathway struct {
 	PathwayMode     int
 	IsBidirectional int
 	TraversalTime   int
+	Extra           map[string]string
 }
 
 type Transfer struct {
@@ -108,4 +118,5 @@ type Transfer struct {
 	ToTripID        string
 	TransferType    int
 	MinTransferTime int
+	Extra           map[string]string
 }
\ No newline at end of file
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type DatasetMetadata struct {
 	ResourceID      string     `json:"id"`
@@ -10,6 +13,22 @@ type DatasetMetadata struct {
 	URL             string     `json:"url"`
 	PackageID       string     `json:"package_id"`
 	DatastoreActive bool       `json:"datastore_active"`
+	Hash            string     `json:"hash"`
+}
+
+// SHA256 returns the resource's hash if CKAN publishes a SHA-256 ("abc..." or
+// "sha256:abc..."), or "" for other or missing hashes
+func (m *DatasetMetadata) SHA256() string {
+	hash := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(m.Hash)), "sha256:")
+	if len(hash) != 64 {
+		return ""
+	}
+	for _, c := range hash {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return ""
+		}
+	}
+	return hash
 }
 
 type DatasetResponse struct {
@@ -21,9 +40,27 @@ type DatasetResponse struct {
 type VersionInfo struct {
 	VersionID    int
 	VersionName  string
+	Dataset      string
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 	IsActive     bool
+	IsPinned     bool
 	SourceURL    string
 	Description  string
+}
+
+// VersionSummary is a row of gtfs.list_versions_with_sizes()
+type VersionSummary struct {
+	VersionID      int        `json:"version_id"`
+	VersionName    string     `json:"version_name"`
+	Dataset        string     `json:"dataset"`
+	CreatedAt      time.Time  `json:"created_at"`
+	IsActive       bool       `json:"is_active"`
+	IsPinned       bool       `json:"is_pinned"`
+	ActivatedAt    *time.Time `json:"activated_at,omitempty"`
+	StopTimesCount int64      `json:"stop_times_count"`
+	TripsCount     int64      `json:"trips_count"`
+	StopsCount     int64      `json:"stops_count"`
+	EstimatedSize  string     `json:"estimated_size"`
+	AgeDays        int        `json:"age_days"`
 }
\ No newline at end of file
@@ -0,0 +1,44 @@
+-- Realtime Stop Time Updates by Station
+-- Looks up stop time updates through gtfs.resolve_stop_platforms (gtfs_static
+-- 013), so a station's stop_id covers updates at every one of its platforms.
+
+SET search_path TO gtfs_rt, gtfs, public;
+
+-- Stop time updates from a source's latest trip updates feed at a stop, or
+-- at any platform of a station
+CREATE OR REPLACE FUNCTION gtfs_rt.get_stop_time_updates(
+    p_stop_id TEXT,
+    p_source_id INTEGER
+)
+RETURNS TABLE (
+    trip_id VARCHAR,
+    route_id VARCHAR,
+    start_date DATE,
+    stop_id VARCHAR,
+    stop_sequence INTEGER,
+    arrival_delay INTEGER,
+    arrival_time BIGINT,
+    departure_delay INTEGER,
+    departure_time BIGINT,
+    schedule_relationship SMALLINT,
+    feed_timestamp TIMESTAMPTZ
+) AS $$
+    WITH latest AS (
+        SELECT fm.feed_message_id, fm.version_id, fm.timestamp
+        FROM gtfs_rt.feed_messages fm
+        WHERE fm.source_id = p_source_id
+          AND fm.feed_type = 'trip_updates'
+        ORDER BY fm.received_at DESC
+        LIMIT 1
+    )
+    SELECT tu.trip_id, tu.route_id, tu.start_date,
+           stu.stop_id, stu.stop_sequence,
+           stu.arrival_delay, stu.arrival_time,
+           stu.departure_delay, stu.departure_time,
+           stu.schedule_relationship, l.timestamp
+    FROM latest l
+    JOIN gtfs_rt.trip_updates tu ON tu.feed_message_id = l.feed_message_id
+    JOIN gtfs_rt.stop_time_updates stu ON stu.trip_update_id = tu.trip_update_id
+    WHERE stu.stop_id = ANY(gtfs.resolve_stop_platforms(p_stop_id, p_source_id, l.version_id))
+    ORDER BY COALESCE(stu.departure_time, stu.arrival_time);
+$$ LANGUAGE sql STABLE;
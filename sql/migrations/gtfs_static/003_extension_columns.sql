@@ -0,0 +1,22 @@
+-- GTFS Static Extension Columns
+-- Non-standard columns found in agency files are kept per row as JSONB,
+-- keyed by the original header name, so vendor-specific data stays reachable.
+
+SET search_path TO gtfs, public;
+
+ALTER TABLE agency ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE stops ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE calendar ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE calendar_dates ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE levels ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE shapes ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE trips ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE stop_times ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE pathways ADD COLUMN IF NOT EXISTS extra JSONB;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS extra JSONB;
+
+-- Lookups by extension key, e.g. WHERE extra ? 'platform_code'
+CREATE INDEX IF NOT EXISTS idx_stops_extra ON stops USING GIN (extra) WHERE extra IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_routes_extra ON routes USING GIN (extra) WHERE extra IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_trips_extra ON trips USING GIN (extra) WHERE extra IS NOT NULL;
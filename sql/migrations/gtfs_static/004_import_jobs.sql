@@ -0,0 +1,45 @@
+-- GTFS Static Import Jobs
+-- Tracks each import of a dataset into an inactive version so an interrupted
+-- import can resume from the last completed source instead of starting over.
+
+SET search_path TO gtfs, public;
+
+-- One job per attempt to load a dataset (identified by source URL and last-modified time)
+CREATE TABLE IF NOT EXISTS import_jobs (
+    job_id SERIAL PRIMARY KEY,
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE,
+    source_url TEXT NOT NULL,
+    last_modified TIMESTAMPTZ NOT NULL, -- UTC
+    status VARCHAR(20) NOT NULL DEFAULT 'running'
+        CHECK (status IN ('running', 'completed', 'failed', 'abandoned')),
+    error TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- UTC
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- UTC
+    finished_at TIMESTAMPTZ -- UTC
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_jobs_dataset ON import_jobs (source_url, last_modified);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_status ON import_jobs (status) WHERE status IN ('running', 'failed');
+
+-- Per-source checkpoint; a source is marked completed in the same transaction that loads it
+CREATE TABLE IF NOT EXISTS import_job_sources (
+    job_id INTEGER NOT NULL REFERENCES import_jobs(job_id) ON DELETE CASCADE,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    error TEXT,
+    started_at TIMESTAMPTZ, -- UTC
+    finished_at TIMESTAMPTZ, -- UTC
+    PRIMARY KEY (job_id, source_id)
+);
+
+-- Rows loaded per file, written alongside the source checkpoint
+CREATE TABLE IF NOT EXISTS import_job_files (
+    job_id INTEGER NOT NULL,
+    source_id INTEGER NOT NULL,
+    file_name VARCHAR(50) NOT NULL,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    completed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- UTC
+    PRIMARY KEY (job_id, source_id, file_name),
+    FOREIGN KEY (job_id, source_id) REFERENCES import_job_sources(job_id, source_id) ON DELETE CASCADE
+);
@@ -0,0 +1,84 @@
+-- GTFS Static Version Management
+-- Adds pinning and activation history so operators can roll back to the
+-- previously active version and hold a version in place during incidents.
+
+SET search_path TO gtfs, public;
+
+-- A pinned version is never deleted by cleanup, and while the active version
+-- is pinned the scheduler does not import or activate newer data
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS is_pinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Last time the version was made active; rollback picks the most recent inactive one
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS activated_at TIMESTAMPTZ; -- UTC
+
+UPDATE versions SET activated_at = updated_at WHERE is_active = TRUE AND activated_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_versions_activated_at ON versions (activated_at DESC) WHERE activated_at IS NOT NULL;
+
+-- The return type changes, so the function has to be dropped first
+DROP FUNCTION IF EXISTS gtfs.list_versions_with_sizes();
+
+CREATE FUNCTION gtfs.list_versions_with_sizes()
+RETURNS TABLE(
+  version_id INTEGER,
+  version_name TEXT,
+  created_at TIMESTAMPTZ,
+  is_active BOOLEAN,
+  is_pinned BOOLEAN,
+  activated_at TIMESTAMPTZ,
+  stop_times_count BIGINT,
+  trips_count BIGINT,
+  stops_count BIGINT,
+  estimated_size TEXT,
+  age_days INTEGER
+)
+LANGUAGE plpgsql AS $$
+BEGIN
+  RETURN QUERY
+  SELECT 
+    v.version_id,
+    v.version_name::TEXT,
+    v.created_at,
+    v.is_active,
+    v.is_pinned,
+    v.activated_at,
+    COALESCE(st.stop_times_count, 0) as stop_times_count,
+    COALESCE(t.trips_count, 0) as trips_count,
+    COALESCE(s.stops_count, 0) as stops_count,
+    CASE 
+      WHEN COALESCE(st.stop_times_count, 0) > 0 THEN
+        pg_size_pretty(
+          -- Estimate based on stop_times table size (usually largest)
+          (pg_total_relation_size('gtfs.stop_times') * COALESCE(st.stop_times_count, 0) / 
+           NULLIF((SELECT COUNT(*) FROM gtfs.stop_times), 0)) +
+          -- Add estimated size for other tables (rough approximation)
+          (pg_total_relation_size('gtfs.trips') * COALESCE(t.trips_count, 0) / 
+           NULLIF((SELECT COUNT(*) FROM gtfs.trips), 0)) +
+          (pg_total_relation_size('gtfs.stops') * COALESCE(s.stops_count, 0) / 
+           NULLIF((SELECT COUNT(*) FROM gtfs.stops), 0))
+        )
+      ELSE '0 bytes'
+    END as estimated_size,
+    EXTRACT(days FROM NOW() - v.created_at)::INTEGER as age_days
+  FROM gtfs.versions v
+  LEFT JOIN (
+    SELECT version_id, COUNT(*) as stop_times_count 
+    FROM gtfs.stop_times 
+    GROUP BY version_id
+  ) st ON v.version_id = st.version_id
+  LEFT JOIN (
+    SELECT version_id, COUNT(*) as trips_count 
+    FROM gtfs.trips 
+    GROUP BY version_id
+  ) t ON v.version_id = t.version_id
+  LEFT JOIN (
+    SELECT version_id, COUNT(*) as stops_count 
+    FROM gtfs.stops 
+    GROUP BY version_id
+  ) s ON v.version_id = s.version_id
+  ORDER BY v.created_at DESC;
+END;
+$$;
+
+COMMENT ON FUNCTION gtfs.list_versions_with_sizes() IS 
+'Lists all GTFS versions with pin and activation state, record counts and estimated storage sizes.';
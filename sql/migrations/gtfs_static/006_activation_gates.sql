@@ -0,0 +1,16 @@
+-- GTFS Static Activation Gates
+-- A fully imported version is only activated once it passes sanity checks
+-- against the active version. A version that fails them is left inactive and
+-- its job is marked rejected, so the same dataset is not re-imported.
+
+SET search_path TO gtfs, public;
+
+ALTER TABLE import_jobs DROP CONSTRAINT IF EXISTS import_jobs_status_check;
+ALTER TABLE import_jobs ADD CONSTRAINT import_jobs_status_check
+    CHECK (status IN ('running', 'completed', 'failed', 'abandoned', 'rejected'));
+
+CREATE INDEX IF NOT EXISTS idx_import_jobs_rejected ON import_jobs (source_url, last_modified) WHERE status = 'rejected';
+
+-- Validation results per source, kept so resumed imports still count earlier sources
+ALTER TABLE import_job_sources ADD COLUMN IF NOT EXISTS validation_errors INTEGER;
+ALTER TABLE import_job_sources ADD COLUMN IF NOT EXISTS validation_warnings INTEGER;
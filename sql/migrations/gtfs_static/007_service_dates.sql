@@ -0,0 +1,293 @@
+-- GTFS Static Service Dates
+-- Expands calendar and calendar_dates into one row per service per day, so
+-- "which services run on this date" is a single indexed lookup instead of
+-- recombining the weekday pattern and exceptions in every query.
+
+SET search_path TO gtfs, public;
+
+CREATE TABLE IF NOT EXISTS service_dates (
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id) DEFERRABLE INITIALLY DEFERRED,
+    service_id VARCHAR(50) NOT NULL,
+    date DATE NOT NULL,
+    PRIMARY KEY (version_id, date, source_id, service_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_dates_service ON service_dates (version_id, source_id, service_id, date);
+
+-- Rebuilds the service dates of a version, optionally for one source.
+-- Called by the importer after each source loads; returns the rows written.
+CREATE OR REPLACE FUNCTION gtfs.expand_service_dates(
+    p_version_id INTEGER,
+    p_source_id INTEGER DEFAULT NULL
+)
+RETURNS BIGINT AS $$
+DECLARE
+    v_count BIGINT;
+BEGIN
+    DELETE FROM gtfs.service_dates
+    WHERE version_id = p_version_id
+      AND (p_source_id IS NULL OR source_id = p_source_id);
+
+    INSERT INTO gtfs.service_dates (version_id, source_id, service_id, date)
+    (
+        SELECT c.version_id, c.source_id, c.service_id, d::DATE
+        FROM gtfs.calendar c
+        CROSS JOIN LATERAL generate_series(c.start_date, c.end_date, INTERVAL '1 day') d
+        WHERE c.version_id = p_version_id
+          AND (p_source_id IS NULL OR c.source_id = p_source_id)
+          AND CASE EXTRACT(ISODOW FROM d)
+              WHEN 1 THEN c.monday WHEN 2 THEN c.tuesday WHEN 3 THEN c.wednesday
+              WHEN 4 THEN c.thursday WHEN 5 THEN c.friday WHEN 6 THEN c.saturday
+              ELSE c.sunday END = 1
+        UNION
+        SELECT cd.version_id, cd.source_id, cd.service_id, cd.date
+        FROM gtfs.calendar_dates cd
+        WHERE cd.version_id = p_version_id
+          AND (p_source_id IS NULL OR cd.source_id = p_source_id)
+          AND cd.exception_type = 1
+    )
+    EXCEPT
+    SELECT cd.version_id, cd.source_id, cd.service_id, cd.date
+    FROM gtfs.calendar_dates cd
+    WHERE cd.version_id = p_version_id
+      AND (p_source_id IS NULL OR cd.source_id = p_source_id)
+      AND cd.exception_type = 2;
+
+    GET DIAGNOSTICS v_count = ROW_COUNT;
+    RETURN v_count;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Backfill versions imported before this migration
+SELECT gtfs.expand_service_dates(version_id) FROM gtfs.versions;
+
+-- Services running on a date in the active version (or p_version_id)
+CREATE OR REPLACE FUNCTION gtfs.active_services(
+    p_date DATE,
+    p_version_id INTEGER DEFAULT NULL
+)
+RETURNS TABLE (
+    source_id INTEGER,
+    service_id VARCHAR
+) AS $$
+    SELECT sd.source_id, sd.service_id
+    FROM gtfs.service_dates sd
+    WHERE sd.date = p_date
+      AND sd.version_id = COALESCE(p_version_id,
+          (SELECT v.version_id FROM gtfs.versions v WHERE v.is_active = true LIMIT 1));
+$$ LANGUAGE sql STABLE;
+
+-- is_service_active now reads the expanded dates of the active version
+CREATE OR REPLACE FUNCTION gtfs.is_service_active(
+    p_source_id text,
+    p_service_id text,
+    p_date date DEFAULT CURRENT_DATE
+)
+RETURNS boolean AS $$
+    SELECT EXISTS (
+        SELECT 1
+        FROM gtfs.service_dates sd
+        JOIN gtfs.versions v ON v.version_id = sd.version_id AND v.is_active = true
+        WHERE sd.source_id = p_source_id::INTEGER
+          AND sd.service_id = p_service_id
+          AND sd.date = p_date
+    );
+$$ LANGUAGE sql STABLE;
+
+-- mv_active_services becomes a slice of service_dates for the active version.
+-- The unique index allows REFRESH MATERIALIZED VIEW CONCURRENTLY.
+DROP MATERIALIZED VIEW IF EXISTS gtfs.mv_active_services;
+
+CREATE MATERIALIZED VIEW gtfs.mv_active_services AS
+SELECT sd.date AS service_date, sd.source_id, sd.service_id
+FROM gtfs.service_dates sd
+JOIN gtfs.versions v ON v.version_id = sd.version_id AND v.is_active = true
+WHERE sd.date BETWEEN CURRENT_DATE - 1 AND CURRENT_DATE + 7;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mv_active_services_unique
+ON gtfs.mv_active_services(service_date, source_id, service_id);
+
+CREATE INDEX IF NOT EXISTS idx_mv_active_services_service
+ON gtfs.mv_active_services(source_id, service_id, service_date);
+
+-- get_stop_departures looks up today's and yesterday's services directly
+CREATE OR REPLACE FUNCTION gtfs.get_stop_departures(
+    p_stop_id TEXT,
+    p_source_id INTEGER,
+    p_limit INTEGER DEFAULT 50,
+    p_version_id INTEGER DEFAULT NULL
+)
+RETURNS TABLE (
+    trip_id VARCHAR,
+    route_id VARCHAR,
+    route_short_name VARCHAR,
+    route_long_name VARCHAR,
+    route_type SMALLINT,
+    route_color VARCHAR,
+    route_text_color VARCHAR,
+    trip_headsign VARCHAR,
+    stop_id VARCHAR,
+    stop_name VARCHAR,
+    scheduled_departure_time_seconds INTEGER,
+    scheduled_departure_time TIME,
+    stop_sequence INTEGER,
+    pickup_type SMALLINT,
+    drop_off_type SMALLINT,
+    source_id INTEGER,
+    version_id INTEGER,
+    platform_id VARCHAR,
+    direction_id SMALLINT
+) AS $$
+DECLARE
+    v_version_id INTEGER;
+    v_platform_ids TEXT[];
+    v_current_time_seconds INTEGER;
+    v_current_date_melbourne DATE;
+    v_yesterday_date_melbourne DATE;
+BEGIN
+    -- Get active version if not specified
+    IF p_version_id IS NULL THEN
+        SELECT v.version_id INTO v_version_id
+        FROM gtfs.versions v
+        WHERE v.is_active = true
+        LIMIT 1;
+    ELSE
+        v_version_id := p_version_id;
+    END IF;
+
+    -- Resolve stop to all relevant platform/stop IDs
+    v_platform_ids := gtfs.resolve_stop_platforms(p_stop_id, p_source_id, v_version_id);
+
+    IF v_platform_ids IS NULL OR array_length(v_platform_ids, 1) = 0 THEN
+        RETURN;
+    END IF;
+
+    -- Get current time context
+    v_current_time_seconds := EXTRACT(EPOCH FROM (NOW() AT TIME ZONE 'Australia/Melbourne')::TIME);
+    v_current_date_melbourne := (NOW() AT TIME ZONE 'Australia/Melbourne')::DATE;
+    v_yesterday_date_melbourne := v_current_date_melbourne - INTERVAL '1 day';
+
+    RETURN QUERY
+    WITH active_services AS (
+        SELECT sd.service_id
+        FROM gtfs.service_dates sd
+        WHERE sd.version_id = v_version_id
+          AND sd.source_id = p_source_id
+          AND sd.date = v_current_date_melbourne
+    ),
+    combined_departures AS (
+        -- Today's departures
+        SELECT
+            st.trip_id, t.trip_headsign, t.direction_id,
+            r.route_id, r.route_short_name, r.route_long_name, r.route_type,
+            r.route_color, r.route_text_color,
+            st.stop_id, s.stop_name,
+            st.departure_time_seconds,
+            st.stop_sequence, st.pickup_type, st.drop_off_type,
+            st.source_id, st.version_id,
+            0 as day_offset
+        FROM gtfs.stop_times st
+        JOIN gtfs.stops s ON st.stop_id = s.stop_id 
+            AND st.version_id = s.version_id 
+            AND st.source_id = s.source_id
+        JOIN gtfs.trips t ON st.trip_id = t.trip_id 
+            AND st.version_id = t.version_id 
+            AND st.source_id = t.source_id
+        JOIN gtfs.routes r ON t.route_id = r.route_id 
+            AND t.version_id = r.version_id 
+            AND t.source_id = r.source_id
+        JOIN active_services acs ON t.service_id = acs.service_id
+        WHERE st.version_id = v_version_id
+          AND st.source_id = p_source_id
+          AND st.stop_id = ANY(v_platform_ids)
+          AND st.departure_time_seconds >= v_current_time_seconds
+          AND EXISTS (
+              SELECT 1 FROM gtfs.stop_times st2
+              WHERE st2.trip_id = st.trip_id
+                AND st2.version_id = st.version_id
+                AND st2.source_id = st.source_id
+                AND st2.stop_sequence > st.stop_sequence
+          )
+
+        UNION ALL
+
+        -- Yesterday's overnight departures  
+        SELECT
+            st.trip_id, t.trip_headsign, t.direction_id,
+            r.route_id, r.route_short_name, r.route_long_name, r.route_type,
+            r.route_color, r.route_text_color,
+            st.stop_id, s.stop_name,
+            st.departure_time_seconds,
+            st.stop_sequence, st.pickup_type, st.drop_off_type,
+            st.source_id, st.version_id,
+            1 as day_offset
+        FROM gtfs.stop_times st
+        JOIN gtfs.stops s ON st.stop_id = s.stop_id 
+            AND st.version_id = s.version_id 
+            AND st.source_id = s.source_id
+        JOIN gtfs.trips t ON st.trip_id = t.trip_id 
+            AND st.version_id = t.version_id 
+            AND st.source_id = t.source_id
+        JOIN gtfs.routes r ON t.route_id = r.route_id 
+            AND t.version_id = r.version_id 
+            AND t.source_id = r.source_id
+        JOIN gtfs.service_dates yesterday_services
+            ON yesterday_services.version_id = v_version_id
+            AND yesterday_services.source_id = p_source_id
+            AND yesterday_services.date = v_yesterday_date_melbourne
+            AND t.service_id = yesterday_services.service_id
+        WHERE st.version_id = v_version_id
+          AND st.source_id = p_source_id
+          AND st.stop_id = ANY(v_platform_ids)
+          AND st.departure_time_seconds >= 86400
+          AND (st.departure_time_seconds - 86400) >= v_current_time_seconds
+          AND EXISTS (
+              SELECT 1 FROM gtfs.stop_times st2
+              WHERE st2.trip_id = st.trip_id
+                AND st2.version_id = st.version_id
+                AND st2.source_id = st.source_id
+                AND st2.stop_sequence > st.stop_sequence
+          )
+    )
+    SELECT
+        cd.trip_id::VARCHAR,
+        cd.route_id::VARCHAR,
+        cd.route_short_name::VARCHAR,
+        cd.route_long_name::VARCHAR,
+        cd.route_type::SMALLINT,
+        cd.route_color::VARCHAR,
+        cd.route_text_color::VARCHAR,
+        cd.trip_headsign::VARCHAR,
+        p_stop_id::VARCHAR as stop_id,
+        cd.stop_name::VARCHAR,
+        CASE 
+            WHEN cd.day_offset = 1 THEN cd.departure_time_seconds - 86400
+            ELSE cd.departure_time_seconds
+        END::INTEGER as scheduled_departure_time_seconds,
+        (TIME '00:00' + (CASE 
+            WHEN cd.day_offset = 1 THEN cd.departure_time_seconds - 86400
+            ELSE cd.departure_time_seconds
+        END) * INTERVAL '1 second')::TIME as scheduled_departure_time,
+        cd.stop_sequence::INTEGER,
+        cd.pickup_type::SMALLINT,
+        cd.drop_off_type::SMALLINT,
+        cd.source_id::INTEGER,
+        cd.version_id::INTEGER,
+        CASE
+            WHEN p_source_id = 2 THEN 
+                COALESCE((SELECT pn.platform_number::VARCHAR 
+                          FROM gtfs.platform_numbers pn 
+                          WHERE pn.platform_id = cd.stop_id), cd.stop_id::VARCHAR)
+            ELSE cd.stop_id::VARCHAR
+        END as platform_id,
+        cd.direction_id::SMALLINT
+    FROM combined_departures cd
+    ORDER BY 
+        CASE 
+            WHEN cd.day_offset = 1 THEN cd.departure_time_seconds - 86400
+            ELSE cd.departure_time_seconds
+        END
+    LIMIT p_limit;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,78 @@
+-- GTFS Static Departure Instants
+-- Optional materialisation of scheduled arrival and departure times as
+-- absolute timestamps for the first days of a version, resolved with the
+-- GTFS "noon minus 12h" rule in each agency's timezone so DST days are right.
+
+SET search_path TO gtfs, public;
+
+CREATE TABLE IF NOT EXISTS departure_instants (
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id) DEFERRABLE INITIALLY DEFERRED,
+    trip_id VARCHAR(100) NOT NULL,
+    stop_sequence INTEGER NOT NULL,
+    service_date DATE NOT NULL,
+    stop_id VARCHAR(50) NOT NULL,
+    arrival_at TIMESTAMPTZ, -- UTC
+    departure_at TIMESTAMPTZ, -- UTC
+    PRIMARY KEY (version_id, source_id, trip_id, service_date, stop_sequence)
+);
+
+CREATE INDEX IF NOT EXISTS idx_departure_instants_stop
+ON departure_instants (version_id, source_id, stop_id, departure_at);
+
+-- Start of a GTFS service day: noon minus 12 hours in the given timezone
+CREATE OR REPLACE FUNCTION gtfs.service_day_start(p_date DATE, p_timezone TEXT)
+RETURNS TIMESTAMPTZ AS $$
+    SELECT ((p_date + TIME '12:00') AT TIME ZONE p_timezone) - INTERVAL '12 hours';
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Rebuilds the departure instants of one source for p_days service days
+-- starting at p_from. Requires service_dates (007). Returns the rows written.
+CREATE OR REPLACE FUNCTION gtfs.materialize_departures(
+    p_version_id INTEGER,
+    p_source_id INTEGER,
+    p_from DATE,
+    p_days INTEGER
+)
+RETURNS BIGINT AS $$
+DECLARE
+    v_count BIGINT;
+BEGIN
+    DELETE FROM gtfs.departure_instants
+    WHERE version_id = p_version_id AND source_id = p_source_id;
+
+    INSERT INTO gtfs.departure_instants
+        (version_id, source_id, trip_id, stop_sequence, service_date, stop_id, arrival_at, departure_at)
+    SELECT
+        st.version_id, st.source_id, st.trip_id, st.stop_sequence, sd.date, st.stop_id,
+        gtfs.service_day_start(sd.date, tz.timezone) + st.arrival_time_seconds * INTERVAL '1 second',
+        gtfs.service_day_start(sd.date, tz.timezone) + st.departure_time_seconds * INTERVAL '1 second'
+    FROM gtfs.service_dates sd
+    JOIN gtfs.trips t ON t.service_id = sd.service_id
+        AND t.source_id = sd.source_id
+        AND t.version_id = sd.version_id
+    JOIN gtfs.routes r ON r.route_id = t.route_id
+        AND r.source_id = t.source_id
+        AND r.version_id = t.version_id
+    -- A route without agency_id belongs to the feed's only agency
+    CROSS JOIN LATERAL (
+        SELECT a.agency_timezone AS timezone
+        FROM gtfs.agency a
+        WHERE a.source_id = r.source_id
+          AND a.version_id = r.version_id
+          AND (r.agency_id IS NULL OR a.agency_id = r.agency_id)
+        ORDER BY a.agency_id
+        LIMIT 1
+    ) tz
+    JOIN gtfs.stop_times st ON st.trip_id = t.trip_id
+        AND st.source_id = t.source_id
+        AND st.version_id = t.version_id
+    WHERE sd.version_id = p_version_id
+      AND sd.source_id = p_source_id
+      AND sd.date >= p_from
+      AND sd.date < p_from + p_days;
+
+    GET DIAGNOSTICS v_count = ROW_COUNT;
+    RETURN v_count;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,304 @@
+-- GTFS Static Datasets
+-- Several independent static feeds (e.g. PTV metro and regional, or other
+-- states) can be loaded side by side. Each dataset has its own versions and
+-- its own active version; transport sources belong to exactly one dataset, so
+-- realtime data and departure queries resolve the version through the source.
+
+SET search_path TO gtfs, public;
+
+CREATE TABLE IF NOT EXISTS datasets (
+    dataset VARCHAR(50) PRIMARY KEY,
+    description TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP -- UTC
+);
+
+INSERT INTO datasets (dataset, description) VALUES
+('default', 'PTV master GTFS schedule')
+ON CONFLICT (dataset) DO NOTHING;
+
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS dataset VARCHAR(50) NOT NULL DEFAULT 'default' REFERENCES datasets(dataset);
+ALTER TABLE transport_sources ADD COLUMN IF NOT EXISTS dataset VARCHAR(50) NOT NULL DEFAULT 'default' REFERENCES datasets(dataset);
+
+-- One active version per dataset instead of one overall
+DROP INDEX IF EXISTS unique_active_version;
+CREATE UNIQUE INDEX IF NOT EXISTS unique_active_version_per_dataset ON versions (dataset) WHERE is_active = TRUE;
+CREATE INDEX IF NOT EXISTS idx_versions_dataset ON versions (dataset, created_at DESC);
+
+-- Active version of each dataset
+CREATE OR REPLACE VIEW gtfs.active_versions AS
+SELECT v.dataset, v.version_id, v.version_name, v.activated_at
+FROM gtfs.versions v
+WHERE v.is_active = true;
+
+-- Active version of the dataset a source belongs to, or NULL
+CREATE OR REPLACE FUNCTION gtfs.active_version_for_source(p_source_id INTEGER)
+RETURNS INTEGER AS $$
+    SELECT v.version_id
+    FROM gtfs.transport_sources ts
+    JOIN gtfs.versions v ON v.dataset = ts.dataset AND v.is_active = true
+    WHERE ts.source_id = p_source_id;
+$$ LANGUAGE sql STABLE;
+
+-- Without a version, services of every dataset's active version are returned
+CREATE OR REPLACE FUNCTION gtfs.active_services(
+    p_date DATE,
+    p_version_id INTEGER DEFAULT NULL
+)
+RETURNS TABLE (
+    source_id INTEGER,
+    service_id VARCHAR
+) AS $$
+    SELECT sd.source_id, sd.service_id
+    FROM gtfs.service_dates sd
+    WHERE sd.date = p_date
+      AND (sd.version_id = p_version_id
+           OR (p_version_id IS NULL AND sd.version_id IN (SELECT version_id FROM gtfs.active_versions)));
+$$ LANGUAGE sql STABLE;
+
+-- list_versions_with_sizes gains the dataset column
+DROP FUNCTION IF EXISTS gtfs.list_versions_with_sizes();
+
+CREATE FUNCTION gtfs.list_versions_with_sizes()
+RETURNS TABLE(
+  version_id INTEGER,
+  version_name TEXT,
+  dataset TEXT,
+  created_at TIMESTAMPTZ,
+  is_active BOOLEAN,
+  is_pinned BOOLEAN,
+  activated_at TIMESTAMPTZ,
+  stop_times_count BIGINT,
+  trips_count BIGINT,
+  stops_count BIGINT,
+  estimated_size TEXT,
+  age_days INTEGER
+)
+LANGUAGE plpgsql AS $$
+BEGIN
+  RETURN QUERY
+  SELECT 
+    v.version_id,
+    v.version_name::TEXT,
+    v.dataset::TEXT,
+    v.created_at,
+    v.is_active,
+    v.is_pinned,
+    v.activated_at,
+    COALESCE(st.stop_times_count, 0) as stop_times_count,
+    COALESCE(t.trips_count, 0) as trips_count,
+    COALESCE(s.stops_count, 0) as stops_count,
+    CASE 
+      WHEN COALESCE(st.stop_times_count, 0) > 0 THEN
+        pg_size_pretty(
+          -- Estimate based on stop_times table size (usually largest)
+          (pg_total_relation_size('gtfs.stop_times') * COALESCE(st.stop_times_count, 0) / 
+           NULLIF((SELECT COUNT(*) FROM gtfs.stop_times), 0)) +
+          -- Add estimated size for other tables (rough approximation)
+          (pg_total_relation_size('gtfs.trips') * COALESCE(t.trips_count, 0) / 
+           NULLIF((SELECT COUNT(*) FROM gtfs.trips), 0)) +
+          (pg_total_relation_size('gtfs.stops') * COALESCE(s.stops_count, 0) / 
+           NULLIF((SELECT COUNT(*) FROM gtfs.stops), 0))
+        )
+      ELSE '0 bytes'
+    END as estimated_size,
+    EXTRACT(days FROM NOW() - v.created_at)::INTEGER as age_days
+  FROM gtfs.versions v
+  LEFT JOIN (
+    SELECT version_id, COUNT(*) as stop_times_count 
+    FROM gtfs.stop_times 
+    GROUP BY version_id
+  ) st ON v.version_id = st.version_id
+  LEFT JOIN (
+    SELECT version_id, COUNT(*) as trips_count 
+    FROM gtfs.trips 
+    GROUP BY version_id
+  ) t ON v.version_id = t.version_id
+  LEFT JOIN (
+    SELECT version_id, COUNT(*) as stops_count 
+    FROM gtfs.stops 
+    GROUP BY version_id
+  ) s ON v.version_id = s.version_id
+  ORDER BY v.dataset, v.created_at DESC;
+END;
+$$;
+
+COMMENT ON FUNCTION gtfs.list_versions_with_sizes() IS 
+'Lists all GTFS versions with dataset, pin and activation state, record counts and estimated storage sizes.';
+
+-- get_stop_departures defaults to the active version of the source's dataset
+CREATE OR REPLACE FUNCTION gtfs.get_stop_departures(
+    p_stop_id TEXT,
+    p_source_id INTEGER,
+    p_limit INTEGER DEFAULT 50,
+    p_version_id INTEGER DEFAULT NULL
+)
+RETURNS TABLE (
+    trip_id VARCHAR,
+    route_id VARCHAR,
+    route_short_name VARCHAR,
+    route_long_name VARCHAR,
+    route_type SMALLINT,
+    route_color VARCHAR,
+    route_text_color VARCHAR,
+    trip_headsign VARCHAR,
+    stop_id VARCHAR,
+    stop_name VARCHAR,
+    scheduled_departure_time_seconds INTEGER,
+    scheduled_departure_time TIME,
+    stop_sequence INTEGER,
+    pickup_type SMALLINT,
+    drop_off_type SMALLINT,
+    source_id INTEGER,
+    version_id INTEGER,
+    platform_id VARCHAR,
+    direction_id SMALLINT
+) AS $$
+DECLARE
+    v_version_id INTEGER;
+    v_platform_ids TEXT[];
+    v_current_time_seconds INTEGER;
+    v_current_date_melbourne DATE;
+    v_yesterday_date_melbourne DATE;
+BEGIN
+    -- Get the active version of the stop's dataset if not specified
+    IF p_version_id IS NULL THEN
+        v_version_id := gtfs.active_version_for_source(p_source_id);
+    ELSE
+        v_version_id := p_version_id;
+    END IF;
+
+    -- Resolve stop to all relevant platform/stop IDs
+    v_platform_ids := gtfs.resolve_stop_platforms(p_stop_id, p_source_id, v_version_id);
+
+    IF v_platform_ids IS NULL OR array_length(v_platform_ids, 1) = 0 THEN
+        RETURN;
+    END IF;
+
+    -- Get current time context
+    v_current_time_seconds := EXTRACT(EPOCH FROM (NOW() AT TIME ZONE 'Australia/Melbourne')::TIME);
+    v_current_date_melbourne := (NOW() AT TIME ZONE 'Australia/Melbourne')::DATE;
+    v_yesterday_date_melbourne := v_current_date_melbourne - INTERVAL '1 day';
+
+    RETURN QUERY
+    WITH active_services AS (
+        SELECT sd.service_id
+        FROM gtfs.service_dates sd
+        WHERE sd.version_id = v_version_id
+          AND sd.source_id = p_source_id
+          AND sd.date = v_current_date_melbourne
+    ),
+    combined_departures AS (
+        -- Today's departures
+        SELECT
+            st.trip_id, t.trip_headsign, t.direction_id,
+            r.route_id, r.route_short_name, r.route_long_name, r.route_type,
+            r.route_color, r.route_text_color,
+            st.stop_id, s.stop_name,
+            st.departure_time_seconds,
+            st.stop_sequence, st.pickup_type, st.drop_off_type,
+            st.source_id, st.version_id,
+            0 as day_offset
+        FROM gtfs.stop_times st
+        JOIN gtfs.stops s ON st.stop_id = s.stop_id 
+            AND st.version_id = s.version_id 
+            AND st.source_id = s.source_id
+        JOIN gtfs.trips t ON st.trip_id = t.trip_id 
+            AND st.version_id = t.version_id 
+            AND st.source_id = t.source_id
+        JOIN gtfs.routes r ON t.route_id = r.route_id 
+            AND t.version_id = r.version_id 
+            AND t.source_id = r.source_id
+        JOIN active_services acs ON t.service_id = acs.service_id
+        WHERE st.version_id = v_version_id
+          AND st.source_id = p_source_id
+          AND st.stop_id = ANY(v_platform_ids)
+          AND st.departure_time_seconds >= v_current_time_seconds
+          AND EXISTS (
+              SELECT 1 FROM gtfs.stop_times st2
+              WHERE st2.trip_id = st.trip_id
+                AND st2.version_id = st.version_id
+                AND st2.source_id = st.source_id
+                AND st2.stop_sequence > st.stop_sequence
+          )
+
+        UNION ALL
+
+        -- Yesterday's overnight departures  
+        SELECT
+            st.trip_id, t.trip_headsign, t.direction_id,
+            r.route_id, r.route_short_name, r.route_long_name, r.route_type,
+            r.route_color, r.route_text_color,
+            st.stop_id, s.stop_name,
+            st.departure_time_seconds,
+            st.stop_sequence, st.pickup_type, st.drop_off_type,
+            st.source_id, st.version_id,
+            1 as day_offset
+        FROM gtfs.stop_times st
+        JOIN gtfs.stops s ON st.stop_id = s.stop_id 
+            AND st.version_id = s.version_id 
+            AND st.source_id = s.source_id
+        JOIN gtfs.trips t ON st.trip_id = t.trip_id 
+            AND st.version_id = t.version_id 
+            AND st.source_id = t.source_id
+        JOIN gtfs.routes r ON t.route_id = r.route_id 
+            AND t.version_id = r.version_id 
+            AND t.source_id = r.source_id
+        JOIN gtfs.service_dates yesterday_services
+            ON yesterday_services.version_id = v_version_id
+            AND yesterday_services.source_id = p_source_id
+            AND yesterday_services.date = v_yesterday_date_melbourne
+            AND t.service_id = yesterday_services.service_id
+        WHERE st.version_id = v_version_id
+          AND st.source_id = p_source_id
+          AND st.stop_id = ANY(v_platform_ids)
+          AND st.departure_time_seconds >= 86400
+          AND (st.departure_time_seconds - 86400) >= v_current_time_seconds
+          AND EXISTS (
+              SELECT 1 FROM gtfs.stop_times st2
+              WHERE st2.trip_id = st.trip_id
+                AND st2.version_id = st.version_id
+                AND st2.source_id = st.source_id
+                AND st2.stop_sequence > st.stop_sequence
+          )
+    )
+    SELECT
+        cd.trip_id::VARCHAR,
+        cd.route_id::VARCHAR,
+        cd.route_short_name::VARCHAR,
+        cd.route_long_name::VARCHAR,
+        cd.route_type::SMALLINT,
+        cd.route_color::VARCHAR,
+        cd.route_text_color::VARCHAR,
+        cd.trip_headsign::VARCHAR,
+        p_stop_id::VARCHAR as stop_id,
+        cd.stop_name::VARCHAR,
+        CASE 
+            WHEN cd.day_offset = 1 THEN cd.departure_time_seconds - 86400
+            ELSE cd.departure_time_seconds
+        END::INTEGER as scheduled_departure_time_seconds,
+        (TIME '00:00' + (CASE 
+            WHEN cd.day_offset = 1 THEN cd.departure_time_seconds - 86400
+            ELSE cd.departure_time_seconds
+        END) * INTERVAL '1 second')::TIME as scheduled_departure_time,
+        cd.stop_sequence::INTEGER,
+        cd.pickup_type::SMALLINT,
+        cd.drop_off_type::SMALLINT,
+        cd.source_id::INTEGER,
+        cd.version_id::INTEGER,
+        CASE
+            WHEN p_source_id = 2 THEN 
+                COALESCE((SELECT pn.platform_number::VARCHAR 
+                          FROM gtfs.platform_numbers pn 
+                          WHERE pn.platform_id = cd.stop_id), cd.stop_id::VARCHAR)
+            ELSE cd.stop_id::VARCHAR
+        END as platform_id,
+        cd.direction_id::SMALLINT
+    FROM combined_departures cd
+    ORDER BY 
+        CASE 
+            WHEN cd.day_offset = 1 THEN cd.departure_time_seconds - 86400
+            ELSE cd.departure_time_seconds
+        END
+    LIMIT p_limit;
+END;
+$$ LANGUAGE plpgsql;
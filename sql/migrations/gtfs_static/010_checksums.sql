@@ -0,0 +1,67 @@
+-- GTFS Static Content Checksums
+-- SHA-256 of each downloaded master archive and of every nested source zip.
+-- A re-upload with identical content is not imported again, and sources
+-- whose zip is unchanged are copied from the active version instead of being
+-- parsed and loaded from scratch.
+
+SET search_path TO gtfs, public;
+
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS archive_sha256 CHAR(64);
+
+CREATE TABLE IF NOT EXISTS version_sources (
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id),
+    sha256 CHAR(64) NOT NULL,
+    copied_from INTEGER, -- version the rows were copied from, NULL when imported
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP, -- UTC
+    PRIMARY KEY (version_id, source_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_versions_archive_sha256 ON versions(dataset, archive_sha256);
+
+-- Copies one source's rows in p_tables from one version to another, keeping
+-- every column except version_id. Returns the rows written.
+CREATE OR REPLACE FUNCTION gtfs.copy_version_source(
+    p_from_version_id INTEGER,
+    p_to_version_id INTEGER,
+    p_source_id INTEGER,
+    p_tables TEXT[]
+)
+RETURNS BIGINT AS $$
+DECLARE
+    v_table TEXT;
+    v_columns TEXT;
+    v_select TEXT;
+    v_rows BIGINT;
+    v_total BIGINT := 0;
+BEGIN
+    FOREACH v_table IN ARRAY p_tables LOOP
+        SELECT
+            string_agg(quote_ident(column_name), ', ' ORDER BY ordinal_position),
+            string_agg(CASE WHEN column_name = 'version_id' THEN '$2' ELSE quote_ident(column_name) END,
+                       ', ' ORDER BY ordinal_position)
+        INTO v_columns, v_select
+        FROM information_schema.columns
+        WHERE table_schema = 'gtfs' AND table_name = v_table;
+
+        IF v_columns IS NULL THEN
+            RAISE EXCEPTION 'table gtfs.% does not exist', v_table;
+        END IF;
+
+        EXECUTE format('DELETE FROM gtfs.%I WHERE version_id = $1 AND source_id = $2', v_table)
+        USING p_to_version_id, p_source_id;
+
+        EXECUTE format('INSERT INTO gtfs.%I (%s) SELECT %s FROM gtfs.%I WHERE version_id = $1 AND source_id = $3',
+                       v_table, v_columns, v_select, v_table)
+        USING p_from_version_id, p_to_version_id, p_source_id;
+
+        GET DIAGNOSTICS v_rows = ROW_COUNT;
+        v_total := v_total + v_rows;
+    END LOOP;
+
+    RETURN v_total;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON FUNCTION gtfs.copy_version_source(INTEGER, INTEGER, INTEGER, TEXT[]) IS
+'Copies a source''s rows in the given tables from one version to another, for sources whose content did not change.';
@@ -0,0 +1,15 @@
+-- GTFS Static Throwaway Versions
+-- Selective imports from the command line (a few sources or files, for
+-- development) can load into a throwaway version. It can never be activated,
+-- doesn't count towards the inactive versions kept as backups, and is
+-- deleted by the first cleanup run a day after it was created.
+
+SET search_path TO gtfs, public;
+
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS is_throwaway BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_versions_throwaway ON versions(created_at) WHERE is_throwaway = TRUE;
+
+-- A selective import into a normal version is marked partial instead: it is
+-- kept like any other version, but is never activated.
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS is_partial BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,27 @@
+-- GTFS Static Shape Geometries
+-- shapes.txt is stored one row per point, so drawing a route means fetching
+-- thousands of rows. The importer also writes each shape as a whole line,
+-- at full resolution (tolerance 0) and Douglas-Peucker simplified at a few
+-- tolerances, as a Google encoded polyline and a GeoJSON LineString.
+
+SET search_path TO gtfs, public;
+
+CREATE TABLE IF NOT EXISTS shape_geometries (
+    shape_id VARCHAR(50) NOT NULL,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id) DEFERRABLE INITIALLY DEFERRED,
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    tolerance_m REAL NOT NULL, -- simplification tolerance in metres, 0 = every point
+    point_count INTEGER NOT NULL,
+    length_m DOUBLE PRECISION NOT NULL, -- length of the full-resolution line
+    min_lat NUMERIC(10,7) NOT NULL,
+    min_lon NUMERIC(10,7) NOT NULL,
+    max_lat NUMERIC(10,7) NOT NULL,
+    max_lon NUMERIC(10,7) NOT NULL,
+    polyline TEXT NOT NULL, -- Google encoded polyline, precision 5
+    geojson JSONB NOT NULL, -- GeoJSON LineString
+    PRIMARY KEY (shape_id, source_id, version_id, tolerance_m)
+);
+
+CREATE INDEX IF NOT EXISTS idx_shape_geometries_version
+ON shape_geometries (version_id, source_id, tolerance_m);
+
@@ -0,0 +1,84 @@
+-- GTFS Static Stop Hierarchy
+-- Built by the importer from stops.txt: every stop's station (the root of its
+-- parent_station chain) and a cluster of stations and standalone stops within
+-- a short distance of each other, which PTV often publishes as separate
+-- stop_ids for what riders see as one stop. Lookups by a station's stop_id
+-- then cover all of its platforms; gtfs_realtime/004 does the same for
+-- realtime stop time updates.
+
+SET search_path TO gtfs, public;
+
+CREATE TABLE IF NOT EXISTS stop_hierarchy (
+    stop_id VARCHAR(50) NOT NULL,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id) DEFERRABLE INITIALLY DEFERRED,
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    station_id VARCHAR(50) NOT NULL, -- root of the parent_station chain, the stop itself if it has no parent
+    parent_id VARCHAR(50),
+    location_type SMALLINT NOT NULL,
+    depth SMALLINT NOT NULL, -- 0 for stations and standalone stops
+    cluster_id VARCHAR(50) NOT NULL, -- smallest station_id of the nearby cluster
+    PRIMARY KEY (stop_id, source_id, version_id),
+    FOREIGN KEY (stop_id, source_id, version_id) REFERENCES stops(stop_id, source_id, version_id) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX IF NOT EXISTS idx_stop_hierarchy_station ON stop_hierarchy (version_id, source_id, station_id);
+CREATE INDEX IF NOT EXISTS idx_stop_hierarchy_cluster ON stop_hierarchy (version_id, source_id, cluster_id);
+
+-- Platforms per station, for versions imported with the hierarchy
+CREATE OR REPLACE VIEW gtfs.station_platforms AS
+SELECT h.version_id, h.source_id, h.station_id, h.stop_id AS platform_id, s.stop_name,
+       s.extra->>'platform_code' AS platform_code
+FROM gtfs.stop_hierarchy h
+JOIN gtfs.stops s ON s.stop_id = h.stop_id AND s.source_id = h.source_id AND s.version_id = h.version_id
+WHERE h.location_type = 0 AND h.depth > 0;
+
+-- Resolves a stop to the stop_ids departures are scheduled at: a station's
+-- platforms, or the stop itself. Falls back to direct parent_station children
+-- for versions imported before the hierarchy existed.
+CREATE OR REPLACE FUNCTION gtfs.resolve_stop_platforms(
+    p_stop_id TEXT,
+    p_source_id INTEGER,
+    p_version_id INTEGER
+)
+RETURNS TEXT[] AS $$
+DECLARE
+    v_ids TEXT[];
+BEGIN
+    SELECT array_agg(h.stop_id::TEXT ORDER BY h.stop_id) INTO v_ids
+    FROM gtfs.stop_hierarchy h
+    WHERE h.version_id = p_version_id
+      AND h.source_id = p_source_id
+      AND h.station_id = p_stop_id
+      AND h.location_type = 0;
+
+    IF v_ids IS NULL THEN
+        SELECT array_agg(s.stop_id::TEXT ORDER BY s.stop_id) INTO v_ids
+        FROM gtfs.stops s
+        WHERE s.version_id = p_version_id
+          AND s.source_id = p_source_id
+          AND (s.stop_id = p_stop_id OR s.parent_station = p_stop_id)
+          AND COALESCE(s.location_type, 0) = 0;
+    END IF;
+
+    RETURN COALESCE(v_ids, ARRAY[p_stop_id]);
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- Resolves a stop to the boarding stop_ids of its whole nearby cluster
+CREATE OR REPLACE FUNCTION gtfs.resolve_stop_cluster(
+    p_stop_id TEXT,
+    p_source_id INTEGER,
+    p_version_id INTEGER
+)
+RETURNS TEXT[] AS $$
+    SELECT COALESCE(array_agg(h.stop_id::TEXT ORDER BY h.stop_id), ARRAY[p_stop_id])
+    FROM gtfs.stop_hierarchy h
+    WHERE h.version_id = p_version_id
+      AND h.source_id = p_source_id
+      AND h.location_type = 0
+      AND h.cluster_id = (
+          SELECT c.cluster_id FROM gtfs.stop_hierarchy c
+          WHERE c.version_id = p_version_id
+            AND c.source_id = p_source_id
+            AND c.stop_id = p_stop_id);
+$$ LANGUAGE sql STABLE;
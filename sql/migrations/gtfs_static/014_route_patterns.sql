@@ -0,0 +1,119 @@
+-- GTFS Static Route Patterns
+-- Trips of a route mostly share a handful of stop sequences. The importer
+-- groups trips by route, direction and ordered stop list into patterns, so
+-- departure boards, line diagrams and headway analytics can work on a few
+-- patterns instead of thousands of trips. A pattern_id is derived from its
+-- route, direction and stops, so the same pattern keeps its ID across versions.
+
+SET search_path TO gtfs, public;
+
+CREATE TABLE IF NOT EXISTS route_patterns (
+    pattern_id VARCHAR(32) NOT NULL,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id) DEFERRABLE INITIALLY DEFERRED,
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    route_id VARCHAR(50) NOT NULL,
+    direction_id SMALLINT,
+    stop_count INTEGER NOT NULL,
+    trip_count INTEGER NOT NULL,
+    headsign VARCHAR(255), -- most common trip_headsign
+    shape_id VARCHAR(50), -- most common shape_id
+    first_stop_id VARCHAR(50) NOT NULL,
+    last_stop_id VARCHAR(50) NOT NULL,
+    PRIMARY KEY (pattern_id, source_id, version_id),
+    FOREIGN KEY (route_id, source_id, version_id) REFERENCES routes(route_id, source_id, version_id) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_patterns_route ON route_patterns (version_id, source_id, route_id, direction_id);
+
+-- Ordered stops of each pattern; stop_index counts from 1
+CREATE TABLE IF NOT EXISTS pattern_stops (
+    pattern_id VARCHAR(32) NOT NULL,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id) DEFERRABLE INITIALLY DEFERRED,
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    stop_index INTEGER NOT NULL,
+    stop_id VARCHAR(50) NOT NULL,
+    PRIMARY KEY (pattern_id, source_id, version_id, stop_index),
+    FOREIGN KEY (pattern_id, source_id, version_id) REFERENCES route_patterns(pattern_id, source_id, version_id) DEFERRABLE INITIALLY DEFERRED,
+    FOREIGN KEY (stop_id, source_id, version_id) REFERENCES stops(stop_id, source_id, version_id) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX IF NOT EXISTS idx_pattern_stops_stop ON pattern_stops (version_id, source_id, stop_id);
+
+-- The pattern each trip follows
+CREATE TABLE IF NOT EXISTS trip_patterns (
+    trip_id VARCHAR(100) NOT NULL,
+    source_id INTEGER NOT NULL REFERENCES transport_sources(source_id) DEFERRABLE INITIALLY DEFERRED,
+    version_id INTEGER NOT NULL REFERENCES versions(version_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    pattern_id VARCHAR(32) NOT NULL,
+    PRIMARY KEY (trip_id, source_id, version_id),
+    FOREIGN KEY (trip_id, source_id, version_id) REFERENCES trips(trip_id, source_id, version_id) DEFERRABLE INITIALLY DEFERRED,
+    FOREIGN KEY (pattern_id, source_id, version_id) REFERENCES route_patterns(pattern_id, source_id, version_id) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX IF NOT EXISTS idx_trip_patterns_pattern ON trip_patterns (version_id, source_id, pattern_id);
+
+-- Rebuilds the route patterns of one source of a version from its trips and
+-- stop times. Called by the importer after each source loads; returns the
+-- number of patterns.
+CREATE OR REPLACE FUNCTION gtfs.build_route_patterns(
+    p_version_id INTEGER,
+    p_source_id INTEGER
+)
+RETURNS BIGINT AS $$
+DECLARE
+    v_count BIGINT;
+BEGIN
+    DELETE FROM gtfs.trip_patterns WHERE version_id = p_version_id AND source_id = p_source_id;
+    DELETE FROM gtfs.pattern_stops WHERE version_id = p_version_id AND source_id = p_source_id;
+    DELETE FROM gtfs.route_patterns WHERE version_id = p_version_id AND source_id = p_source_id;
+
+    -- Each trip's ordered stop list, and the pattern it hashes to. Stop IDs
+    -- are joined with the ASCII unit separator, which stop IDs don't contain.
+    CREATE TEMP TABLE tmp_trip_stops ON COMMIT DROP AS
+    SELECT t.trip_id, t.route_id, t.direction_id, t.trip_headsign, t.shape_id,
+           s.stops, s.stop_count,
+           left(md5(t.route_id || chr(31) || COALESCE(t.direction_id::TEXT, '') || chr(31) || s.stops), 16) AS pattern_id
+    FROM gtfs.trips t
+    JOIN (
+        SELECT st.trip_id,
+               string_agg(st.stop_id, chr(31) ORDER BY st.stop_sequence) AS stops,
+               COUNT(*) AS stop_count
+        FROM gtfs.stop_times st
+        WHERE st.version_id = p_version_id AND st.source_id = p_source_id
+        GROUP BY st.trip_id
+    ) s ON s.trip_id = t.trip_id
+    WHERE t.version_id = p_version_id AND t.source_id = p_source_id;
+
+    INSERT INTO gtfs.route_patterns (
+        pattern_id, source_id, version_id, route_id, direction_id,
+        stop_count, trip_count, headsign, shape_id, first_stop_id, last_stop_id)
+    SELECT pattern_id, p_source_id, p_version_id, MIN(route_id), MIN(direction_id),
+           MIN(stop_count), COUNT(*),
+           mode() WITHIN GROUP (ORDER BY trip_headsign),
+           mode() WITHIN GROUP (ORDER BY shape_id),
+           split_part(MIN(stops), chr(31), 1),
+           (string_to_array(MIN(stops), chr(31)))[MIN(stop_count)]
+    FROM tmp_trip_stops
+    GROUP BY pattern_id;
+
+    GET DIAGNOSTICS v_count = ROW_COUNT;
+
+    INSERT INTO gtfs.pattern_stops (pattern_id, source_id, version_id, stop_index, stop_id)
+    SELECT p.pattern_id, p_source_id, p_version_id, s.stop_index, s.stop_id
+    FROM (SELECT DISTINCT ON (pattern_id) pattern_id, stops FROM tmp_trip_stops) p
+    CROSS JOIN LATERAL unnest(string_to_array(p.stops, chr(31))) WITH ORDINALITY AS s(stop_id, stop_index);
+
+    INSERT INTO gtfs.trip_patterns (trip_id, source_id, version_id, pattern_id)
+    SELECT trip_id, p_source_id, p_version_id, pattern_id
+    FROM tmp_trip_stops;
+
+    DROP TABLE tmp_trip_stops;
+    RETURN v_count;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Backfill versions imported before this migration
+SELECT gtfs.build_route_patterns(v.version_id, s.source_id)
+FROM gtfs.versions v
+CROSS JOIN gtfs.transport_sources s
+WHERE EXISTS (SELECT 1 FROM gtfs.trips t WHERE t.version_id = v.version_id AND t.source_id = s.source_id);